@@ -0,0 +1,16 @@
+package common
+
+import "time"
+
+// Backoff returns the exponential delay before retrying after attempts failures:
+// min for the first one, doubled for each next one, up to max
+func Backoff(attempts int, min, max time.Duration) time.Duration {
+	d := min
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
@@ -0,0 +1,306 @@
+// Package config loads configuration structs (with `env` tags) from layered sources, in order of precedence:
+//
+//  1. `envDefault` tags
+//  2. a YAML/JSON/TOML file selected by ENV (default stg), eg. config/prd.yaml
+//  3. environment variables
+//  4. secret files: NAME_FILE=/run/secrets/name sets NAME to the file content (Kubernetes/Docker secrets),
+//     only for NAME that is an `env` tag of the loaded struct, other *_FILE variables are left alone
+//
+// File keys are env names, nested keys are joined by `_`, lists are joined by `,`:
+//
+//	port: 8080           # PORT
+//	db:
+//	  host: postgres     # DB_HOST
+//	trusted_proxy:       # TRUSTED_PROXY
+//	  - 10.0.0.0/8
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/caarlos0/env/v6"
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	fileSuffix = "_FILE"
+	redacted   = "xxxxx"
+	defaultEnv = "stg" // same as the default of service.AppConfig Env
+)
+
+var (
+	// SensitiveEnv matches env names whose values are redacted by Dump: the last `_` separated segment is a secret
+	// word, eg. DB_PASS or API_KEY but not DB_DSN_URL nor API_KEY_PREFIX
+	SensitiveEnv = regexp.MustCompile(`(?i)(^|_)(PASS|PASSWORD|PASSWD|SECRETS?|TOKENS?|KEYS?|DSN|CREDENTIALS?)$`)
+
+	extensions = []string{".yaml", ".yml", ".json", ".toml"}
+)
+
+// Loader loads config structs from defaults, a config file, env & secret files
+type Loader struct {
+	Env  string // selects the file <Dir>/<Env>.(yaml|yml|json|toml), no file is loaded if empty
+	Dir  string // directory of config files
+	File string // explicit config file, takes precedence over Env/Dir, it must exist
+}
+
+// NewLoader makes a loader from environment: ENV (default "stg" as AppConfig), CONFIG_DIR (default "config")
+// & CONFIG_FILE
+func NewLoader() *Loader {
+	l := &Loader{
+		Env:  os.Getenv("ENV"),
+		Dir:  os.Getenv("CONFIG_DIR"),
+		File: os.Getenv("CONFIG_FILE"),
+	}
+	if l.Env == "" {
+		l.Env = defaultEnv
+	}
+	if l.Dir == "" {
+		l.Dir = "config"
+	}
+	return l
+}
+
+// Load loads then validates targets (pointers to structs) with NewLoader
+func Load(targets ...interface{}) error {
+	return NewLoader().Load(targets...)
+}
+
+// Load loads then validates targets (pointers to structs)
+//
+//	cfg := &Config{}
+//	if err := config.Load(cfg); err != nil { ... }
+func (l *Loader) Load(targets ...interface{}) error {
+	environment, err := l.Environment(targets...)
+	if err != nil {
+		return err
+	}
+
+	validate := newValidator()
+	for _, target := range targets {
+		if err = env.Parse(target, env.Options{Environment: environment}); err != nil {
+			return err
+		}
+		if err = validate.Struct(target); err != nil {
+			return fmt.Errorf("invalid config %T: %w", target, err)
+		}
+	}
+	return nil
+}
+
+// newValidator makes a validator reporting fields by their env name
+func newValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		if name := field.Tag.Get("env"); name != "" && name != "-" {
+			return name
+		}
+		return field.Name
+	})
+	return validate
+}
+
+// Defaults fills target (a pointer to struct) from its `envDefault` tags only, the environment is ignored,
+// so the defaults of NewXConfig functions aren't repeated
+//
+//	func NewConfig() *Config {
+//		cfg := &Config{}
+//		_ = config.Defaults(cfg)
+//		return cfg
+//	}
+func Defaults(target interface{}) error {
+	return env.Parse(target, env.Options{Environment: map[string]string{}})
+}
+
+// Environment returns the merged key/values of the config file & env variables, plus the secret files of
+// the env names of targets
+func (l *Loader) Environment(targets ...interface{}) (map[string]string, error) {
+	environment := map[string]string{}
+
+	path, err := l.file()
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		if err = readFile(path, environment); err != nil {
+			return nil, fmt.Errorf("failed to load config file %s: %w", path, err)
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 {
+			environment[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	names := map[string]bool{}
+	for _, target := range targets {
+		envNames(reflect.ValueOf(target), names)
+	}
+	for name := range names {
+		secretPath := environment[name+fileSuffix]
+		if secretPath == "" {
+			continue
+		}
+		content, err := os.ReadFile(secretPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret file of %s: %w", name, err)
+		}
+		environment[name] = strings.TrimRight(string(content), "\r\n")
+	}
+
+	return environment, nil
+}
+
+// envNames collects the `env` tags of the fields of v, nested structs included
+func envNames(v reflect.Value, names map[string]bool) {
+	walkFields(v, func(name string, _ reflect.Value) {
+		names[name] = true
+	})
+}
+
+// File returns path of the config file to load, empty if there's none
+func (l *Loader) file() (string, error) {
+	if l.File != "" {
+		if _, err := os.Stat(l.File); err != nil {
+			return "", err
+		}
+		return l.File, nil
+	}
+	if l.Env == "" {
+		return "", nil
+	}
+
+	for _, ext := range extensions {
+		path := filepath.Join(l.Dir, l.Env+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// IsConfigFile reports whether path is a structured config file (yaml, json or toml)
+func IsConfigFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// readFile decodes a config file then flattens it into environment
+func readFile(path string, environment map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		err = errors.New("unsupported config file format")
+	}
+	if err != nil {
+		return err
+	}
+
+	flatten("", values, environment)
+	return nil
+}
+
+func flatten(prefix string, value interface{}, environment map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			name := strings.ToUpper(key)
+			if prefix != "" {
+				name = prefix + "_" + name
+			}
+			flatten(name, child, environment)
+		}
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, scalar(item))
+		}
+		environment[prefix] = strings.Join(items, ",")
+	case []map[string]interface{}: // toml array of tables isn't supported by env
+	default:
+		environment[prefix] = scalar(v)
+	}
+}
+
+func scalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Dump returns the env name => value of target fields, sensitive values are redacted so it's safe to log
+func Dump(target interface{}) map[string]interface{} {
+	dump := map[string]interface{}{}
+	dumpStruct(reflect.ValueOf(target), dump)
+	return dump
+}
+
+func dumpStruct(v reflect.Value, dump map[string]interface{}) {
+	walkFields(v, func(name string, field reflect.Value) {
+		value := field.Interface()
+		if SensitiveEnv.MatchString(name) && !field.IsZero() {
+			value = redacted
+		}
+		dump[name] = value
+	})
+}
+
+// walkFields calls fn with the env name & value of fields of v tagged `env`, untagged struct fields are walked
+func walkFields(v reflect.Value, fn func(name string, field reflect.Value)) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("env"), ",")[0]
+		if name == "" {
+			walkFields(v.Field(i), fn)
+			continue
+		}
+		fn(name, v.Field(i))
+	}
+}
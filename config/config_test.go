@@ -0,0 +1,165 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testDBConfig struct {
+	Host string `env:"DB_HOST" envDefault:"localhost"`
+	Pass string `env:"DB_PASS"`
+}
+
+type testConfig struct {
+	Port         int      `env:"TEST_PORT" envDefault:"8088"`
+	Name         string   `env:"TEST_NAME" envDefault:"default" validate:"required"`
+	TrustedProxy []string `env:"TEST_TRUSTED_PROXY" envSeparator:","`
+	Debug        bool     `env:"TEST_DEBUG"`
+	DB           *testDBConfig
+}
+
+func newTestConfig() *testConfig {
+	return &testConfig{DB: &testDBConfig{}}
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "prd.yaml", `
+test_port: 9000
+test_name: from-file
+test_trusted_proxy: [10.0.0.0/8, 192.168.0.0/16]
+db:
+  host: postgres
+  pass: from-file
+`)
+	secret := writeFile(t, dir, "db_pass", "s3cret\n")
+
+	t.Run("Defaults", func(t *testing.T) {
+		cfg := newTestConfig()
+		require.NoError(t, (&Loader{Dir: dir}).Load(cfg))
+		assert.Equal(t, 8088, cfg.Port)
+		assert.Equal(t, "localhost", cfg.DB.Host)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		cfg := newTestConfig()
+		require.NoError(t, (&Loader{Env: "prd", Dir: dir}).Load(cfg))
+		assert.Equal(t, 9000, cfg.Port)
+		assert.Equal(t, "from-file", cfg.Name)
+		assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, cfg.TrustedProxy)
+		assert.Equal(t, "postgres", cfg.DB.Host)
+	})
+
+	t.Run("EnvOverridesFile", func(t *testing.T) {
+		t.Setenv("TEST_PORT", "9100")
+		cfg := newTestConfig()
+		require.NoError(t, (&Loader{Env: "prd", Dir: dir}).Load(cfg))
+		assert.Equal(t, 9100, cfg.Port)
+		assert.Equal(t, "from-file", cfg.Name)
+	})
+
+	t.Run("SecretFile", func(t *testing.T) {
+		t.Setenv("DB_PASS", "from-env")
+		t.Setenv("DB_PASS_FILE", secret)
+		cfg := newTestConfig()
+		require.NoError(t, (&Loader{Env: "prd", Dir: dir}).Load(cfg))
+		assert.Equal(t, "s3cret", cfg.DB.Pass)
+
+		t.Setenv("DB_PASS_FILE", filepath.Join(dir, "missing"))
+		assert.Error(t, (&Loader{Env: "prd", Dir: dir}).Load(newTestConfig()))
+	})
+
+	t.Run("UnrelatedFileVar", func(t *testing.T) {
+		// *_FILE variables that aren't secrets of the config mustn't be read
+		t.Setenv("SSL_CERT_FILE", filepath.Join(dir, "missing.pem"))
+		t.Setenv("CONFIG_FILE", filepath.Join(dir, "missing.yaml"))
+		cfg := newTestConfig()
+		require.NoError(t, (&Loader{Env: "prd", Dir: dir}).Load(cfg))
+		assert.Equal(t, 9000, cfg.Port)
+	})
+
+	t.Run("MissingEnvFile", func(t *testing.T) {
+		cfg := newTestConfig()
+		require.NoError(t, (&Loader{Env: "dev", Dir: dir}).Load(cfg))
+		assert.Equal(t, 8088, cfg.Port)
+	})
+
+	t.Run("MissingExplicitFile", func(t *testing.T) {
+		assert.Error(t, (&Loader{File: filepath.Join(dir, "missing.yaml")}).Load(newTestConfig()))
+	})
+}
+
+func TestLoadFormats(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"app.json": `{"TEST_PORT": 9001, "TEST_DEBUG": true, "db": {"host": "json-db"}}`,
+		"app.toml": "test_port = 9001\ntest_debug = true\n[db]\nhost = \"json-db\"\n",
+		"app.yml":  "TEST_PORT: 9001\nTEST_DEBUG: true\nDB_HOST: json-db\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := newTestConfig()
+			require.NoError(t, (&Loader{File: writeFile(t, dir, name, content)}).Load(cfg))
+			assert.Equal(t, 9001, cfg.Port)
+			assert.True(t, cfg.Debug)
+			assert.Equal(t, "json-db", cfg.DB.Host)
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		assert.Error(t, (&Loader{File: writeFile(t, dir, "bad.json", "{")}).Load(newTestConfig()))
+		assert.Error(t, (&Loader{File: writeFile(t, dir, "app.ini", "a=b")}).Load(newTestConfig()))
+	})
+}
+
+func TestLoadValidation(t *testing.T) {
+	t.Setenv("TEST_NAME", "")
+	err := (&Loader{}).Load(newTestConfig())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid config")
+	assert.Contains(t, err.Error(), "TEST_NAME", "fields are reported by env name")
+}
+
+func TestNewLoaderDefaultEnv(t *testing.T) {
+	t.Setenv("ENV", "")
+	assert.Equal(t, "stg", NewLoader().Env, "same default as AppConfig")
+	t.Setenv("ENV", "prd")
+	assert.Equal(t, "prd", NewLoader().Env)
+}
+
+func TestDump(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Port = 80
+	cfg.DB.Pass = "s3cret"
+
+	dump := Dump(cfg)
+	assert.Equal(t, 80, dump["TEST_PORT"])
+	assert.Equal(t, "localhost", Dump(&testConfig{DB: &testDBConfig{Host: "localhost"}})["DB_HOST"])
+	assert.Equal(t, "xxxxx", dump["DB_PASS"])
+	assert.Equal(t, "", Dump(newTestConfig())["DB_PASS"], "empty secrets are shown as is")
+
+	for name, sensitive := range map[string]bool{
+		"DB_PASS": true, "DB_PASSWORD": true, "JWT_SECRET": true, "API_KEY": true, "DB_DSN": true, "TOKEN": true,
+		"AWS_SECRET_ACCESS_KEY": true, "DB_DSN_URL": false, "API_KEY_PREFIX": false, "MONKEY": false, "PASSTHROUGH": false,
+	} {
+		assert.Equal(t, sensitive, SensitiveEnv.MatchString(name), name)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	t.Setenv("TEST_PORT", "9000")
+	cfg := newTestConfig()
+	require.NoError(t, Defaults(cfg))
+	assert.Equal(t, 8088, cfg.Port, "the environment is ignored")
+	assert.Equal(t, "default", cfg.Name)
+	assert.Equal(t, "localhost", cfg.DB.Host)
+}
@@ -0,0 +1,137 @@
+// Package audit records who changed what on opted-in models:
+// a gorm plugin captures before/after snapshots on create/update/delete
+// and writes them to the audit_log table (or a custom Sink) in the same transaction.
+package audit
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// audit actions
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Auditable is implemented by models whose changes should be recorded,
+// fields tagged `audit:"-"` (eg. password hashes) are excluded from snapshots
+//
+//	type Order struct {
+//		ID     int64
+//		Status string
+//	}
+//
+//	func (Order) Auditable() {}
+type Auditable interface {
+	Auditable()
+}
+
+// JSON presents a json value stored as a text column
+type JSON []byte
+
+// Value implements the driver.Valuer interface.
+func (j JSON) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (j *JSON) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*j = nil
+	case string:
+		*j = JSON(v)
+	case []byte:
+		*j = append((*j)[:0], v...)
+	default:
+		return fmt.Errorf("unsupported type %T for audit json", value)
+	}
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (j *JSON) UnmarshalJSON(b []byte) error {
+	*j = append((*j)[:0], b...)
+	return nil
+}
+
+// Change presents a field change in an update
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// AuditLog presents a change of an entity
+type AuditLog struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	EntityType string    `gorm:"size:128;not null;index:idx_audit_log_entity,priority:1" json:"entity_type"`
+	EntityID   string    `gorm:"size:128;not null;index:idx_audit_log_entity,priority:2" json:"entity_id"`
+	Action     string    `gorm:"size:16;not null" json:"action"`
+	Before     JSON      `gorm:"type:text" json:"before"`
+	After      JSON      `gorm:"type:text" json:"after"`
+	Changes    JSON      `gorm:"type:text" json:"changes,omitempty"`
+	ActorID    string    `gorm:"size:128" json:"actor_id,omitempty"`
+	TenantID   uint64    `gorm:"index" json:"tenant_id,omitempty"`
+	RequestID  string    `gorm:"size:64" json:"request_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// GetSortableFields implements the ginext.SortableFieldsGetter interface.
+func (AuditLog) GetSortableFields() []string {
+	return []string{"id", "created_at"}
+}
+
+// Migrate creates/updates the audit_log table
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&AuditLog{})
+}
+
+// Sink persists audit logs, tx is the transaction of the audited change
+type Sink interface {
+	Write(tx *gorm.DB, logs []*AuditLog) error
+}
+
+// SinkFunc is an adapter to allow the use of ordinary functions as Sink
+type SinkFunc func(tx *gorm.DB, logs []*AuditLog) error
+
+// Write calls f(tx, logs)
+func (f SinkFunc) Write(tx *gorm.DB, logs []*AuditLog) error {
+	return f(tx, logs)
+}
+
+// TableSink writes audit logs to the audit_log table
+type TableSink struct{}
+
+// Write implements the Sink interface.
+func (TableSink) Write(tx *gorm.DB, logs []*AuditLog) error {
+	return tx.Create(&logs).Error
+}
+
+// toJSON encodes v, it returns nil on nil value
+func toJSON(v interface{}) JSON {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/db"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type auditedOrder struct {
+	ID       int64
+	Status   string
+	Secret   string `audit:"-"`
+	Quantity int
+}
+
+func (auditedOrder) Auditable() {}
+
+type plainOrder struct {
+	ID     int64
+	Status string
+}
+
+func setupDB(t *testing.T, sink Sink) *gorm.DB {
+	gormDB, err := db.Open(&db.Config{Driver: "sqlite3", DSN: ":memory:", MaxOpenConns: 1, MaxIdleConns: 1})
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close(gormDB) })
+	require.NoError(t, Migrate(gormDB))
+	require.NoError(t, gormDB.AutoMigrate(&auditedOrder{}, &plainOrder{}))
+	require.NoError(t, gormDB.Use(NewPlugin(sink)))
+	return gormDB
+}
+
+func auditContext() context.Context {
+	ctx := context.WithValue(context.Background(), common.HeaderUserID, "user-1")
+	ctx = context.WithValue(ctx, common.HeaderXRequestID, "req-1")
+	return db.WithTenant(ctx, 3)
+}
+
+func TestAuditPlugin(t *testing.T) {
+	gormDB := setupDB(t, nil)
+	tx := gormDB.WithContext(auditContext())
+
+	order := &auditedOrder{Status: "new", Secret: "s3cr3t", Quantity: 1}
+	require.NoError(t, tx.Create(order).Error)
+	require.NoError(t, tx.Model(order).Update("status", "paid").Error)
+	require.NoError(t, tx.Model(&auditedOrder{}).Where("id = ?", order.ID).Update("status", "paid").Error, "no change")
+	require.NoError(t, tx.Delete(order).Error)
+	require.NoError(t, tx.Create(&plainOrder{Status: "new"}).Error)
+
+	var logs []AuditLog
+	require.NoError(t, gormDB.Order("id").Find(&logs).Error)
+	require.Len(t, logs, 3)
+
+	t.Run("Create", func(t *testing.T) {
+		log := logs[0]
+		assert.Equal(t, ActionCreate, log.Action)
+		assert.Equal(t, "audited_order", log.EntityType)
+		assert.Equal(t, "1", log.EntityID)
+		assert.Equal(t, "user-1", log.ActorID)
+		assert.Equal(t, "req-1", log.RequestID)
+		assert.Equal(t, uint64(3), log.TenantID)
+		assert.Nil(t, log.Before)
+		assert.JSONEq(t, `{"id":1,"status":"new","quantity":1}`, string(log.After))
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		log := logs[1]
+		assert.Equal(t, ActionUpdate, log.Action)
+		assert.JSONEq(t, `{"status":{"from":"new","to":"paid"}}`, string(log.Changes))
+		assert.JSONEq(t, `{"id":1,"status":"new","quantity":1}`, string(log.Before))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		log := logs[2]
+		assert.Equal(t, ActionDelete, log.Action)
+		assert.JSONEq(t, `{"id":1,"status":"paid","quantity":1}`, string(log.Before))
+		assert.Nil(t, log.After)
+	})
+}
+
+func TestAuditSinkInSameTransaction(t *testing.T) {
+	sinkErr := errors.New("sink is down")
+	gormDB := setupDB(t, SinkFunc(func(tx *gorm.DB, logs []*AuditLog) error {
+		return sinkErr
+	}))
+
+	err := gormDB.Create(&auditedOrder{Status: "new"}).Error
+	assert.ErrorIs(t, err, sinkErr)
+
+	var count int64
+	gormDB.Model(&auditedOrder{}).Count(&count)
+	assert.Equal(t, int64(0), count, "the change should be rolled back")
+}
+
+func TestHistoryHandler(t *testing.T) {
+	gormDB := setupDB(t, nil)
+	tx := gormDB.WithContext(auditContext())
+	order := &auditedOrder{Status: "new"}
+	require.NoError(t, tx.Create(order).Error)
+	require.NoError(t, tx.Model(order).Update("status", "paid").Error)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ginext.CreateErrorHandler())
+	router.GET("/audit/:entity/:id", ginext.WrapHandler(HistoryHandler(gormDB)))
+
+	doRequest := func(tenant string) map[string]interface{} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/audit/audited_order/1", nil)
+		req.Header.Set(common.HeaderTenantID, tenant)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		body := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body
+	}
+
+	body := doRequest("3")
+	logs := body["data"].([]interface{})
+	require.Len(t, logs, 2)
+	assert.Equal(t, ActionUpdate, logs[0].(map[string]interface{})["action"], "newest first")
+	assert.Equal(t, float64(2), body["meta"].(map[string]interface{})["total"])
+
+	body = doRequest("4")
+	assert.Empty(t, body["data"])
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit/audited_order/1", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code, "no tenant, no history")
+
+	router.GET("/admin/audit/:entity/:id", ginext.WrapHandler(GlobalHistoryHandler(gormDB)))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/audit/audited_order/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	body = map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Len(t, body["data"], 2)
+}
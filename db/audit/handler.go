@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"net/http"
+
+	"github.com/praslar/cloud0/db"
+	"github.com/praslar/cloud0/ginext"
+	"gorm.io/gorm"
+)
+
+// ErrMissingTenant is returned by HistoryHandler when there's no tenant in context
+var ErrMissingTenant = ginext.NewError(http.StatusForbidden, "missing tenant")
+
+// HistoryHandler returns paginated audit logs of an entity (newest first) of the tenant in context,
+// it reads route params `entity` (table name) & `id`, requests without tenant get 403
+//
+//	router.GET("/audit/:entity/:id", ginext.AuthRequiredMiddleware, ginext.WrapHandler(audit.HistoryHandler(nil)))
+func HistoryHandler(gormDB *gorm.DB) ginext.Handler {
+	return historyHandler(gormDB, false)
+}
+
+// GlobalHistoryHandler is HistoryHandler returning the logs of all tenants, it's meant for admin routes
+//
+//	admin.GET("/audit/:entity/:id", ginext.WrapHandler(audit.GlobalHistoryHandler(nil)))
+func GlobalHistoryHandler(gormDB *gorm.DB) ginext.Handler {
+	return historyHandler(gormDB, true)
+}
+
+func historyHandler(gormDB *gorm.DB, global bool) ginext.Handler {
+	return func(r *ginext.Request) (*ginext.Response, error) {
+		ctx := r.Context()
+		tenantID, ok := db.TenantFromContext(ctx)
+		if !ok && !global {
+			return nil, ErrMissingTenant
+		}
+
+		pager := ginext.NewPagerWithGinCtx(r.GinCtx)
+		if pager.Sort == "" {
+			pager.Sort = "-id"
+		}
+
+		tx := gormDB
+		if tx == nil {
+			tx = db.GetDB()
+		}
+		tx = tx.WithContext(ctx).Model(&AuditLog{}).
+			Where("entity_type = ? AND entity_id = ?", r.Param("entity"), r.Param("id"))
+		if !global {
+			tx = tx.Where("tenant_id = ?", tenantID)
+		}
+
+		var logs []*AuditLog
+		if err := pager.DoQuery(&logs, tx).Error; err != nil {
+			return nil, err
+		}
+
+		return ginext.NewResponseWithPager(http.StatusOK, logs, pager), nil
+	}
+}
@@ -0,0 +1,295 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const beforeRowsKey = "cloud0:audit_before_rows"
+
+var _ gorm.Plugin = &Plugin{}
+
+// snapshot presents the audited columns of a row
+type snapshot map[string]interface{}
+
+// Plugin is a gorm plugin that records changes on Auditable models
+//
+//	_ = db.GetDB().Use(audit.NewPlugin(nil))
+type Plugin struct {
+	Sink Sink
+}
+
+// NewPlugin makes an audit plugin, audit logs are written to the audit_log table if sink is nil
+func NewPlugin(sink Sink) *Plugin {
+	if sink == nil {
+		sink = TableSink{}
+	}
+	return &Plugin{Sink: sink}
+}
+
+// Name implements the gorm.Plugin interface.
+func (p *Plugin) Name() string {
+	return "cloud0:audit"
+}
+
+// Initialize implements the gorm.Plugin interface.
+func (p *Plugin) Initialize(gormDB *gorm.DB) error {
+	cb := gormDB.Callback()
+	if err := cb.Create().After("gorm:after_create").Before("gorm:commit_or_rollback_transaction").
+		Register("cloud0:audit_after_create", p.afterCreate); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("cloud0:audit_before_update", p.captureBefore); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:after_update").Before("gorm:commit_or_rollback_transaction").
+		Register("cloud0:audit_after_update", p.afterUpdate); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("cloud0:audit_before_delete", p.captureBefore); err != nil {
+		return err
+	}
+	return cb.Delete().After("gorm:after_delete").Before("gorm:commit_or_rollback_transaction").
+		Register("cloud0:audit_after_delete", p.afterDelete)
+}
+
+func isAuditable(gormDB *gorm.DB) bool {
+	stmt := gormDB.Statement
+	if gormDB.Error != nil || stmt.Schema == nil {
+		return false
+	}
+	_, ok := reflect.New(stmt.Schema.ModelType).Interface().(Auditable)
+	return ok
+}
+
+func (p *Plugin) afterCreate(gormDB *gorm.DB) {
+	if !isAuditable(gormDB) || gormDB.RowsAffected == 0 {
+		return
+	}
+
+	var logs []*AuditLog
+	for _, rv := range structValues(gormDB.Statement.ReflectValue) {
+		after := takeSnapshot(gormDB.Statement.Schema, rv)
+		logs = append(logs, p.newLog(gormDB, ActionCreate, entityID(gormDB.Statement.Schema, rv), nil, after))
+	}
+	p.write(gormDB, logs)
+}
+
+// captureBefore loads rows that are going to be changed
+func (p *Plugin) captureBefore(gormDB *gorm.DB) {
+	gormDB.Statement.Settings.Delete(beforeRowsKey)
+	if !isAuditable(gormDB) {
+		return
+	}
+
+	stmt := gormDB.Statement
+	var conds []clause.Expression
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok {
+			conds = append(conds, where.Exprs...)
+		}
+	}
+	if stmt.ReflectValue.Kind() == reflect.Struct {
+		conds = append(conds, primaryKeyConds(stmt.Schema, stmt.ReflectValue)...)
+	}
+	if len(conds) == 0 && !stmt.AllowGlobalUpdate {
+		// gorm refuses to run it anyway
+		return
+	}
+
+	rows, err := p.load(gormDB, conds)
+	if err != nil {
+		_ = gormDB.AddError(fmt.Errorf("audit: failed to load rows before changing: %w", err))
+		return
+	}
+	stmt.Settings.Store(beforeRowsKey, rows)
+}
+
+func (p *Plugin) afterUpdate(gormDB *gorm.DB) {
+	before := beforeRows(gormDB)
+	if !isAuditable(gormDB) || len(before) == 0 || gormDB.RowsAffected == 0 {
+		return
+	}
+
+	s := gormDB.Statement.Schema
+	var ors []clause.Expression
+	for _, rv := range before {
+		ors = append(ors, clause.And(primaryKeyConds(s, rv)...))
+	}
+	after, err := p.load(gormDB, []clause.Expression{clause.Or(ors...)})
+	if err != nil {
+		_ = gormDB.AddError(fmt.Errorf("audit: failed to load rows after updating: %w", err))
+		return
+	}
+	afterByID := map[string]snapshot{}
+	for _, rv := range after {
+		afterByID[entityID(s, rv)] = takeSnapshot(s, rv)
+	}
+
+	var logs []*AuditLog
+	for _, rv := range before {
+		id := entityID(s, rv)
+		beforeSnapshot, afterSnapshot := takeSnapshot(s, rv), afterByID[id]
+		if afterSnapshot == nil || len(diff(beforeSnapshot, afterSnapshot)) == 0 {
+			continue
+		}
+		logs = append(logs, p.newLog(gormDB, ActionUpdate, id, beforeSnapshot, afterSnapshot))
+	}
+	p.write(gormDB, logs)
+}
+
+func (p *Plugin) afterDelete(gormDB *gorm.DB) {
+	before := beforeRows(gormDB)
+	if !isAuditable(gormDB) || len(before) == 0 || gormDB.RowsAffected == 0 {
+		return
+	}
+
+	s := gormDB.Statement.Schema
+	var logs []*AuditLog
+	for _, rv := range before {
+		logs = append(logs, p.newLog(gormDB, ActionDelete, entityID(s, rv), takeSnapshot(s, rv), nil))
+	}
+	p.write(gormDB, logs)
+}
+
+func (p *Plugin) write(gormDB *gorm.DB, logs []*AuditLog) {
+	if len(logs) == 0 {
+		return
+	}
+	if err := p.Sink.Write(gormDB.Session(&gorm.Session{NewDB: true}), logs); err != nil {
+		_ = gormDB.AddError(fmt.Errorf("audit: failed to write logs: %w", err))
+	}
+}
+
+func (p *Plugin) newLog(gormDB *gorm.DB, action, id string, before, after snapshot) *AuditLog {
+	ctx := gormDB.Statement.Context
+	log := &AuditLog{
+		EntityType: entityType(gormDB.Statement.Schema),
+		EntityID:   id,
+		Action:     action,
+		Before:     toJSON(nilIfEmpty(before)),
+		After:      toJSON(nilIfEmpty(after)),
+	}
+	if action == ActionUpdate {
+		log.Changes = toJSON(diff(before, after))
+	}
+	if ctx != nil {
+		log.ActorID, _ = ctx.Value(common.HeaderUserID).(string)
+		log.RequestID, _ = ctx.Value(common.HeaderXRequestID).(string)
+		log.TenantID, _ = db.TenantFromContext(ctx)
+	}
+	return log
+}
+
+// load finds rows of current model matching conds within the same connection (transaction)
+func (p *Plugin) load(gormDB *gorm.DB, conds []clause.Expression) ([]reflect.Value, error) {
+	stmt := gormDB.Statement
+	rows := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+	tx := gormDB.Session(&gorm.Session{NewDB: true}).Model(reflect.New(stmt.Schema.ModelType).Interface())
+	if len(conds) > 0 {
+		tx = tx.Clauses(clause.Where{Exprs: conds})
+	}
+	if err := tx.Find(rows.Interface()).Error; err != nil {
+		return nil, err
+	}
+	return structValues(rows.Elem()), nil
+}
+
+func beforeRows(gormDB *gorm.DB) []reflect.Value {
+	if v, ok := gormDB.Statement.Settings.Load(beforeRowsKey); ok {
+		rows, _ := v.([]reflect.Value)
+		return rows
+	}
+	return nil
+}
+
+// structValues returns struct values of a struct or a slice of structs
+func structValues(rv reflect.Value) []reflect.Value {
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Struct:
+		return []reflect.Value{rv}
+	case reflect.Slice, reflect.Array:
+		values := make([]reflect.Value, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if item := reflect.Indirect(rv.Index(i)); item.Kind() == reflect.Struct {
+				values = append(values, item)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func primaryKeyConds(s *schema.Schema, rv reflect.Value) []clause.Expression {
+	var conds []clause.Expression
+	for _, field := range s.PrimaryFields {
+		value, isZero := field.ValueOf(rv)
+		if isZero {
+			return nil
+		}
+		conds = append(conds, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: value})
+	}
+	return conds
+}
+
+func entityType(s *schema.Schema) string {
+	table := s.Table
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		table = table[i+1:]
+	}
+	return table
+}
+
+func entityID(s *schema.Schema, rv reflect.Value) string {
+	var ids []string
+	for _, field := range s.PrimaryFields {
+		value, _ := field.ValueOf(rv)
+		ids = append(ids, fmt.Sprint(value))
+	}
+	return strings.Join(ids, ",")
+}
+
+// takeSnapshot returns audited columns of a row, normalized as decoded json
+func takeSnapshot(s *schema.Schema, rv reflect.Value) snapshot {
+	values := map[string]interface{}{}
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.Tag.Get("audit") == "-" {
+			continue
+		}
+		values[field.DBName], _ = field.ValueOf(rv)
+	}
+
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return values
+	}
+	snap := snapshot{}
+	_ = json.Unmarshal(raw, &snap)
+	return snap
+}
+
+func diff(before, after snapshot) map[string]Change {
+	changes := map[string]Change{}
+	for k, to := range after {
+		if from := before[k]; !reflect.DeepEqual(from, to) {
+			changes[k] = Change{From: from, To: to}
+		}
+	}
+	return changes
+}
+
+func nilIfEmpty(s snapshot) interface{} {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
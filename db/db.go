@@ -4,12 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"time"
 
-	"gorm.io/driver/postgres"
-	"gorm.io/driver/sqlite"
+	"github.com/praslar/cloud0/logger"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 )
 
@@ -28,61 +27,175 @@ type Config struct {
 	ConnMaxLifetime int    `env:"DB_CONN_MAX_LIFETIME" envDefault:"600"`
 
 	Host   string `env:"DB_HOST"`
-	Port   string `env:"DB_PORT" envDefault:"5432"`
+	Port   string `env:"DB_PORT"` // default: 5432 for postgres, 3306 for mysql
 	User   string `env:"DB_USER"`
 	Pass   string `env:"DB_PASS"`
 	Name   string `env:"DB_NAME"`
 	Schema string `env:"DB_SCHEMA" envDefault:"public"`
+
+	SSLMode          string `env:"DB_SSLMODE" envDefault:"disable"` // disable, require, verify-ca or verify-full
+	SSLRootCert      string `env:"DB_SSLROOTCERT"`                  // path to the CA certificate
+	SSLCert          string `env:"DB_SSLCERT"`                      // path to the client certificate
+	SSLKey           string `env:"DB_SSLKEY"`                       // path to the client private key
+	ApplicationName  string `env:"DB_APPLICATION_NAME"`             // default: app name
+	StatementTimeout int    `env:"DB_STATEMENT_TIMEOUT"`            // in milliseconds, 0 means no timeout
+	Params           string `env:"DB_PARAMS"`                       // extra params in query string form, eg. target_session_attrs=read-write&timezone=UTC
+	URLFormat        bool   `env:"DB_DSN_URL" envDefault:"false"`   // build URL form DSN (postgres://...) instead of key=value
+
+	LogLevel      string  `env:"DB_LOG_LEVEL"`                       // silent, error, warn or info, default: warn (info if app is in debug mode)
+	SlowThreshold int     `env:"DB_SLOW_THRESHOLD" envDefault:"200"` // in milliseconds, 0 to disable
+	LogParams     bool    `env:"DB_LOG_PARAMS" envDefault:"false"`   // don't redact query parameters
+	LogSampleRate float64 `env:"DB_LOG_SAMPLE_RATE" envDefault:"1"`  // ratio of normal queries to be logged, in range [0, 1], 0 logs all
+
+	ConnectRetryTimeout    int  `env:"DB_CONNECT_RETRY_TIMEOUT" envDefault:"30"`        // in seconds, how long Open retries connecting on startup, 0 to disable
+	ConnectRetryMinBackoff int  `env:"DB_CONNECT_RETRY_MIN_BACKOFF" envDefault:"500"`   // in milliseconds
+	ConnectRetryMaxBackoff int  `env:"DB_CONNECT_RETRY_MAX_BACKOFF" envDefault:"10000"` // in milliseconds
+	LazyConnect            bool `env:"DB_LAZY_CONNECT" envDefault:"false"`              // start (degraded) even if DB is unreachable
+	HealthCheckInterval    int  `env:"DB_HEALTH_CHECK_INTERVAL" envDefault:"10"`        // in seconds
+
+	TenantStrategy     string `env:"DB_TENANT_STRATEGY" envDefault:"column"`         // column or schema (postgres only)
+	TenantSchemaFormat string `env:"DB_TENANT_SCHEMA_FORMAT" envDefault:"tenant_%d"` // schema name of a tenant with schema strategy
 }
 
+const (
+	defaultSchema  = "public"
+	connectTimeout = 5 * time.Second
+	pingTimeout    = 2 * time.Second
+)
+
 // GetDSN returns a dsn that is read from ENV or built from separated env DB_*
 func (c Config) GetDSN() string {
 	if c.DSN != "" {
 		return c.DSN
 	}
 
-	c.DSN = fmt.Sprintf(
-		"host=%s port=%s user=%s dbname=%s password=%s sslmode=disable connect_timeout=5",
-		c.Host,
-		c.Port,
-		c.User,
-		c.Name,
-		c.Pass,
-	)
+	if c.Driver == "mysql" || c.Driver == "mariadb" {
+		return c.mysqlDSN()
+	}
 
-	return c.DSN
+	if c.URLFormat {
+		return c.postgresURL()
+	}
+
+	return c.postgresDSN()
+}
+
+// port returns the configured port or the default one of the driver
+func (c Config) port() string {
+	if c.Port != "" {
+		return c.Port
+	}
+	if c.Driver == "mysql" || c.Driver == "mariadb" {
+		return "3306"
+	}
+	return "5432"
 }
 
 // Open open a DB connection
-//  dbDefault, err := Open(config)
+//
+//	dbDefault, err := Open(config)
 func Open(config *Config) (*gorm.DB, error) {
+	return OpenContext(context.Background(), config)
+}
+
+// OpenContext opens a DB connection, on connection errors it retries with exponential backoff
+// until config.ConnectRetryTimeout (or ctx) exceeds.
+// With config.LazyConnect, it doesn't wait for the DB to be reachable, see HealthWatcher to track its state
+func OpenContext(ctx context.Context, config *Config) (*gorm.DB, error) {
+	l := logger.Tag("db.Open")
 	naming := &schema.NamingStrategy{
 		SingularTable: true,
 	}
 	cfg := &gorm.Config{
-		NamingStrategy: naming,
-		Logger:         logger.Default.LogMode(logger.Silent),
-	}
-
-	var dialector gorm.Dialector
-	switch config.Driver {
-	case "sqlite", "sqlite3":
-		dialector = sqlite.Open(config.GetDSN())
-	case "postgres":
-		dialector = postgres.Open(config.GetDSN())
-		naming.TablePrefix = config.Schema + "."
-	default:
+		NamingStrategy:       naming,
+		Logger:               NewGormLogger(config),
+		DisableAutomaticPing: true, // ping with timeout below
+	}
+
+	driver, ok := lookupDriver(config.Driver)
+	if !ok {
 		return nil, fmt.Errorf("unsupported driver %s", config.Driver)
 	}
+	if driver.TablePrefix != nil {
+		naming.TablePrefix = driver.TablePrefix(config)
+	}
+	if config.LogSampleRate < 0 || config.LogSampleRate > 1 {
+		return nil, fmt.Errorf("log sample rate %v is out of range [0, 1]", config.LogSampleRate)
+	}
+	if _, err := url.ParseQuery(config.Params); err != nil {
+		return nil, fmt.Errorf("invalid DB params %q: %w", config.Params, err)
+	}
+	if driver.Setup != nil {
+		if err := driver.Setup(config); err != nil {
+			return nil, err
+		}
+	}
+	if config.TenantStrategy == TenantStrategySchema && config.Driver != "postgres" {
+		return nil, fmt.Errorf("tenant schema strategy is not supported by driver %s", config.Driver)
+	}
 
-	db, err := gorm.Open(dialector, cfg)
-	if err != nil {
-		return nil, err
+	if config.ConnectRetryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(config.ConnectRetryTimeout)*time.Second)
+		defer cancel()
+	}
+
+	var (
+		db      *gorm.DB
+		err     error
+		backoff = config.retryMinBackoff()
+	)
+	for attempt := 1; ; attempt++ {
+		if db == nil {
+			if db, err = gorm.Open(driver.Dialector(config), cfg); err == nil {
+				if err = setup(db, config); err != nil {
+					Close(db)
+					return nil, err
+				}
+			} else {
+				db = nil
+			}
+		}
+
+		if err == nil && !config.LazyConnect {
+			err = ping(ctx, db)
+		}
+		if err == nil {
+			return db, nil
+		}
+
+		if config.ConnectRetryTimeout <= 0 {
+			Close(db)
+			return nil, err
+		}
+
+		l.WithError(err).Warnf("failed to connect to database (attempt %d), retry in %v", attempt, backoff)
+		select {
+		case <-ctx.Done():
+			Close(db)
+			return nil, fmt.Errorf("gave up connecting to database after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > config.retryMaxBackoff() {
+			backoff = config.retryMaxBackoff()
+		}
+	}
+}
+
+// setup registers cloud0 plugins & configures the connection pool
+func setup(db *gorm.DB, config *Config) error {
+	tenantPlugin := &TenantPlugin{
+		Strategy:     config.TenantStrategy,
+		SchemaFormat: config.TenantSchemaFormat,
+	}
+	if err := db.Use(tenantPlugin); err != nil {
+		return err
 	}
 
 	theDB, err := db.DB()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if config.MaxIdleConns > 0 {
@@ -95,13 +208,37 @@ func Open(config *Config) (*gorm.DB, error) {
 		theDB.SetConnMaxLifetime(time.Duration(config.ConnMaxLifetime) * time.Second)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second * 2)
+	return nil
+}
+
+// ping checks the connection to DB within pingTimeout
+func ping(ctx context.Context, db *gorm.DB) error {
+	theDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
 	defer cancel()
 	if err = theDB.PingContext(ctx); err != nil {
-		return nil, fmt.Errorf("error while ping DB: %v", err)
+		return fmt.Errorf("error while ping DB: %w", err)
 	}
 
-	return db, nil
+	return nil
+}
+
+func (c Config) retryMinBackoff() time.Duration {
+	if c.ConnectRetryMinBackoff > 0 {
+		return time.Duration(c.ConnectRetryMinBackoff) * time.Millisecond
+	}
+	return 500 * time.Millisecond
+}
+
+func (c Config) retryMaxBackoff() time.Duration {
+	if c.ConnectRetryMaxBackoff > 0 {
+		return time.Duration(c.ConnectRetryMaxBackoff) * time.Millisecond
+	}
+	return 10 * time.Second
 }
 
 // Close release a DB instance
@@ -125,7 +262,7 @@ var inMemorySqliteCfg = &Config{
 }
 
 // MustSetupTest setups an in-memory DB for testing and set to default
-// it'll panic if errors occur
+// it'll panic if errors occur, see package dbtest for isolated databases per test
 func MustSetupTest() {
 	db, err := Open(inMemorySqliteCfg)
 	if err != nil {
@@ -21,15 +21,22 @@ func TestOpenDB(t *testing.T) {
 	t.Run("panic on unsupported driver", func(t *testing.T) {
 		assert.Panics(t, func() {
 			unsupportedDriverCfg := *inMemorySqliteCfg
-			unsupportedDriverCfg.Driver = "mysql"
+			unsupportedDriverCfg.Driver = "oracle"
 			MustOpenDefault(&unsupportedDriverCfg)
 		})
 	})
 
+	t.Run("error on log sample rate out of range", func(t *testing.T) {
+		cfg := *inMemorySqliteCfg
+		cfg.LogSampleRate = 1.5
+		_, err := Open(&cfg)
+		assert.Error(t, err)
+	})
+
 	t.Run("panic on invalid setup test", func(t *testing.T) {
 		assert.Panics(t, func() {
 			oldValue := inMemorySqliteCfg.Driver
-			inMemorySqliteCfg.Driver = "mysql"
+			inMemorySqliteCfg.Driver = "oracle"
 			defer func() {
 				inMemorySqliteCfg.Driver = oldValue
 			}()
@@ -0,0 +1,131 @@
+// Package dbtest provides isolated databases, fixtures & factories for tests.
+//
+// Each test gets its own database: a unique in-memory SQLite by default,
+// or a transaction on a real Postgres (rolled back on cleanup) when DBTEST_POSTGRES_DSN is set,
+// models are then migrated once per DSN before the transaction begins so parallel tests don't wait for each other's DDL
+//
+//	DBTEST_POSTGRES_DSN="host=localhost user=test dbname=test sslmode=disable" go test ./...
+package dbtest
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/praslar/cloud0/db"
+	"gorm.io/gorm"
+)
+
+// EnvPostgresDSN is the env to run tests against a real Postgres
+const EnvPostgresDSN = "DBTEST_POSTGRES_DSN"
+
+var (
+	sqliteSeq uint64
+
+	// postgres connections shared by tests, keyed by DSN
+	postgresMu sync.Mutex
+	postgresDB = map[string]*postgresBase{}
+)
+
+// postgresBase is a postgres connection shared by tests & the models already migrated into it
+type postgresBase struct {
+	db       *gorm.DB
+	mu       sync.Mutex
+	migrated map[reflect.Type]bool
+}
+
+// New returns a fresh isolated database for the test then migrates models into it,
+// it's safe to be used in parallel tests
+//
+//	func TestCreateUser(t *testing.T) {
+//		t.Parallel()
+//		gormDB := dbtest.New(t, &User{})
+//		...
+//	}
+func New(t testing.TB, models ...interface{}) *gorm.DB {
+	t.Helper()
+
+	if dsn := os.Getenv(EnvPostgresDSN); dsn != "" {
+		return newPostgresTx(t, dsn, models)
+	}
+
+	gormDB := newSqlite(t)
+	if len(models) > 0 {
+		if err := gormDB.AutoMigrate(models...); err != nil {
+			t.Fatalf("dbtest: failed to migrate models: %v", err)
+		}
+	}
+	return gormDB
+}
+
+// newSqlite opens a unique in-memory SQLite database, closed on cleanup
+func newSqlite(t testing.TB) *gorm.DB {
+	name := fmt.Sprintf("dbtest_%d", atomic.AddUint64(&sqliteSeq, 1))
+	gormDB, err := db.Open(&db.Config{
+		Driver:       "sqlite3",
+		DSN:          fmt.Sprintf("file:%s?mode=memory&cache=shared", name),
+		MaxOpenConns: 1, // sqlite doesn't support concurrency writing
+		MaxIdleConns: 1,
+	})
+	if err != nil {
+		t.Fatalf("dbtest: failed to open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close(gormDB) })
+
+	return gormDB
+}
+
+// newPostgresTx migrates models into the shared postgres database if they aren't yet,
+// then begins a transaction on it, rolled back on cleanup
+func newPostgresTx(t testing.TB, dsn string, models []interface{}) *gorm.DB {
+	postgresMu.Lock()
+	base, ok := postgresDB[dsn]
+	if !ok {
+		gormDB, err := db.Open(&db.Config{Driver: "postgres", DSN: dsn, MaxOpenConns: 25, MaxIdleConns: 25})
+		if err != nil {
+			postgresMu.Unlock()
+			t.Fatalf("dbtest: failed to open postgres: %v", err)
+		}
+		base = &postgresBase{db: gormDB, migrated: map[reflect.Type]bool{}}
+		postgresDB[dsn] = base
+	}
+	postgresMu.Unlock()
+
+	if err := base.migrate(models); err != nil {
+		t.Fatalf("dbtest: failed to migrate models: %v", err)
+	}
+
+	tx := base.db.Begin()
+	if tx.Error != nil {
+		t.Fatalf("dbtest: failed to begin transaction: %v", tx.Error)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+
+	return tx
+}
+
+// migrate migrates the models that aren't migrated yet, outside of any test transaction
+func (b *postgresBase) migrate(models []interface{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var pending []interface{}
+	for _, model := range models {
+		if typ := reflect.TypeOf(model); !b.migrated[typ] {
+			pending = append(pending, model)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := b.db.AutoMigrate(pending...); err != nil {
+		return err
+	}
+	for _, model := range pending {
+		b.migrated[reflect.TypeOf(model)] = true
+	}
+	return nil
+}
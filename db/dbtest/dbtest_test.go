@@ -0,0 +1,75 @@
+package dbtest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type author struct {
+	ID   int64
+	Name string
+}
+
+type book struct {
+	ID       int64
+	Title    string
+	AuthorID int64
+}
+
+func TestIsolatedDatabases(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		i := i
+		t.Run(fmt.Sprintf("Parallel%d", i), func(t *testing.T) {
+			t.Parallel()
+			gormDB := New(t, &author{})
+
+			for j := 0; j <= i; j++ {
+				require.NoError(t, gormDB.Create(&author{Name: "someone"}).Error)
+			}
+
+			var count int64
+			require.NoError(t, gormDB.Model(&author{}).Count(&count).Error)
+			assert.Equal(t, int64(i+1), count)
+		})
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	gormDB := New(t, &author{}, &book{})
+	LoadFixtures(t, gormDB, "testdata/fixtures.yaml", "testdata/fixtures.json")
+
+	var authors []author
+	require.NoError(t, gormDB.Order("id").Find(&authors).Error)
+	require.Len(t, authors, 3)
+	assert.Equal(t, "Nam Cao", authors[0].Name)
+
+	var b book
+	require.NoError(t, gormDB.Where("title = ?", "De Men phieu luu ky").Take(&b).Error)
+	assert.Equal(t, int64(3), b.AuthorID)
+}
+
+func TestFactory(t *testing.T) {
+	gormDB := New(t, &author{})
+	authorFactory := NewFactory(func(seq int) *author {
+		return &author{Name: fmt.Sprintf("author %d", seq)}
+	})
+
+	built := authorFactory.Build()
+	assert.Equal(t, "author 1", built.Name)
+	assert.Zero(t, built.ID)
+
+	created := authorFactory.Create(t, gormDB, func(a *author) { a.Name += " (edited)" })
+	assert.NotZero(t, created.ID)
+	assert.Equal(t, "author 2 (edited)", created.Name)
+
+	many := authorFactory.CreateMany(t, gormDB, 3)
+	assert.Len(t, many, 3)
+	assert.Equal(t, "author 5", many[2].Name)
+
+	var count int64
+	gormDB.Model(&author{}).Count(&count)
+	assert.Equal(t, int64(4), count)
+}
@@ -0,0 +1,65 @@
+package dbtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// LoadFixtures inserts rows from YAML or JSON files, tables are loaded in the file order
+// so the ones referenced by foreign keys should come first
+//
+//	# testdata/users.yaml
+//	user:
+//	  - id: 1
+//	    name: John
+//	order:
+//	  - id: 1
+//	    user_id: 1
+func LoadFixtures(t testing.TB, gormDB *gorm.DB, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if err := loadFixture(gormDB, path); err != nil {
+			t.Fatalf("dbtest: failed to load fixture %s: %v", path, err)
+		}
+	}
+}
+
+func loadFixture(gormDB *gorm.DB, path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// JSON is a subset of YAML, decode into nodes to keep tables order
+	var doc yaml.Node
+	if err = yaml.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("fixture should be a map of table => rows")
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		table := root.Content[i].Value
+		var rows []map[string]interface{}
+		if err = root.Content[i+1].Decode(&rows); err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+		for _, row := range rows {
+			if err = gormDB.Table(table).Create(row).Error; err != nil {
+				return fmt.Errorf("table %s: %w", table, err)
+			}
+		}
+	}
+
+	return nil
+}
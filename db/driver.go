@@ -0,0 +1,97 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Driver describes how Open connects to a kind of database
+type Driver struct {
+	// Dialector makes a gorm dialector from config
+	Dialector func(config *Config) gorm.Dialector
+	// TablePrefix returns the prefix of table names (eg. schema), it's optional
+	TablePrefix func(config *Config) string
+	// Setup prepares the driver before connecting (eg. registers TLS configs), it's optional
+	Setup func(config *Config) error
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]Driver{}
+)
+
+func init() {
+	RegisterDriver("postgres", Driver{
+		Dialector: func(config *Config) gorm.Dialector {
+			return postgres.Open(config.GetDSN())
+		},
+		TablePrefix: func(config *Config) string {
+			if config.Schema == "" {
+				return ""
+			}
+			return config.Schema + "."
+		},
+	})
+
+	mysqlDriver := Driver{
+		Dialector: func(config *Config) gorm.Dialector {
+			// don't query server version on opening, it'd fail when DB is unreachable
+			return gormmysql.New(gormmysql.Config{DSN: config.GetDSN(), SkipInitializeWithVersion: config.LazyConnect})
+		},
+		// schema is database in MySQL, only qualify tables when it's set to another database
+		TablePrefix: func(config *Config) string {
+			if config.Schema == "" || config.Schema == defaultSchema || config.Schema == config.Name {
+				return ""
+			}
+			return config.Schema + "."
+		},
+		Setup: registerMySQLTLS,
+	}
+	RegisterDriver("mysql", mysqlDriver)
+	RegisterDriver("mariadb", mysqlDriver)
+}
+
+// RegisterDriver makes a driver available by name for Open (Config.Driver),
+// registering an existing name replaces the driver
+//
+//	import "gorm.io/driver/sqlserver"
+//
+//	db.RegisterDriver("sqlserver", db.Driver{
+//		Dialector: func(config *db.Config) gorm.Dialector {
+//			return sqlserver.Open(config.DSN)
+//		},
+//	})
+func RegisterDriver(name string, driver Driver) {
+	if driver.Dialector == nil {
+		panic(fmt.Sprintf("db: nil dialector of driver %s", name))
+	}
+
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[name] = driver
+}
+
+// Drivers returns sorted names of registered drivers
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupDriver(name string) (Driver, bool) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	driver, ok := drivers[name]
+	return driver, ok
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRegisterDriver(t *testing.T) {
+	assert.Subset(t, Drivers(), []string{"postgres", "mysql", "sqlite", "sqlite3", "sqlite-purego"})
+
+	RegisterDriver("test-sqlite", Driver{
+		Dialector: func(config *Config) gorm.Dialector {
+			return &sqlite.Dialector{DriverName: "sqlite", DSN: config.GetDSN()}
+		},
+	})
+	cfg := *inMemorySqliteCfg
+	cfg.Driver = "test-sqlite"
+	gormDB, err := Open(&cfg)
+	require.NoError(t, err)
+	Close(gormDB)
+
+	assert.Panics(t, func() {
+		RegisterDriver("nil", Driver{})
+	})
+}
+
+func TestOpenPureGoSqlite(t *testing.T) {
+	cfg := *inMemorySqliteCfg
+	cfg.Driver = "sqlite-purego"
+	gormDB, err := Open(&cfg)
+	require.NoError(t, err)
+	defer Close(gormDB)
+
+	require.NoError(t, gormDB.AutoMigrate(&sampleModel{}))
+	require.NoError(t, gormDB.Create(&sampleModel{Message: "pure go"}).Error)
+
+	var got sampleModel
+	require.NoError(t, gormDB.First(&got).Error)
+	assert.Equal(t, "pure go", got.Message)
+}
+
+func TestMysqlConfig(t *testing.T) {
+	c := Config{
+		Driver: "mysql",
+		Host:   "localhost",
+		User:   "test",
+		Pass:   "p@ss word",
+		Name:   "db_test",
+		Schema: "public",
+	}
+	assert.Equal(t, "test:p@ss word@tcp(localhost:3306)/db_test?parseTime=true&timeout=5s&charset=utf8mb4", c.GetDSN())
+
+	mysqlDriver, ok := lookupDriver("mysql")
+	require.True(t, ok)
+	assert.Equal(t, "", mysqlDriver.TablePrefix(&c), "default postgres schema shouldn't be used")
+
+	c.Schema = "shared"
+	assert.Equal(t, "shared.", mysqlDriver.TablePrefix(&c))
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/praslar/cloud0/logger"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+var (
+	_ gormlogger.Interface = &GormLogger{}
+
+	// literalRegexp matches string & numeric literals in an explained SQL
+	literalRegexp = regexp.MustCompile(`'(?:[^']|'')*'|\b\d+(?:\.\d+)?\b`)
+)
+
+// GormLogger is an adapter that writes gorm logs through cloud0 logger,
+// x-request-id is attached to the log entry if the query is run with a request context
+//
+//	db.GetDB().WithContext(ginext.FromGinRequestContext(c)).Find(&users)
+type GormLogger struct {
+	Level         gormlogger.LogLevel
+	SlowThreshold time.Duration // queries running longer than this are logged as warnings, 0 to disable
+	LogParams     bool          // log query parameters as is, they are redacted by default
+	SampleRate    float64       // ratio of normal (info level) queries to be logged, in range [0, 1], 0 logs all as 1 does
+}
+
+// NewGormLogger makes a gorm logger from database config
+func NewGormLogger(config *Config) *GormLogger {
+	return &GormLogger{
+		Level:         ParseLogLevel(config.LogLevel),
+		SlowThreshold: time.Duration(config.SlowThreshold) * time.Millisecond,
+		LogParams:     config.LogParams,
+		SampleRate:    config.LogSampleRate,
+	}
+}
+
+// ParseLogLevel converts a level name (silent, error, warn, info) to gorm log level, default is warn
+func ParseLogLevel(level string) gormlogger.LogLevel {
+	switch strings.ToLower(level) {
+	case "silent":
+		return gormlogger.Silent
+	case "error":
+		return gormlogger.Error
+	case "info", "debug":
+		return gormlogger.Info
+	default:
+		return gormlogger.Warn
+	}
+}
+
+// LogMode implements the gorm logger.Interface.
+func (g *GormLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	newLogger := *g
+	newLogger.Level = level
+	return &newLogger
+}
+
+// Info implements the gorm logger.Interface.
+func (g *GormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if g.Level >= gormlogger.Info {
+		logger.WithCtx(ctx, "gorm").Infof(msg, args...)
+	}
+}
+
+// Warn implements the gorm logger.Interface.
+func (g *GormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if g.Level >= gormlogger.Warn {
+		logger.WithCtx(ctx, "gorm").Warnf(msg, args...)
+	}
+}
+
+// Error implements the gorm logger.Interface.
+func (g *GormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if g.Level >= gormlogger.Error {
+		logger.WithCtx(ctx, "gorm").Errorf(msg, args...)
+	}
+}
+
+// Trace implements the gorm logger.Interface.
+// errors (except record not found) & slow queries are always logged, normal queries are sampled
+func (g *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if g.Level <= gormlogger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	isError := err != nil && !errors.Is(err, gormlogger.ErrRecordNotFound) && g.Level >= gormlogger.Error
+	isSlow := g.SlowThreshold > 0 && elapsed > g.SlowThreshold && g.Level >= gormlogger.Warn
+	isNormal := g.Level >= gormlogger.Info && g.sampled()
+	if !isError && !isSlow && !isNormal {
+		return
+	}
+
+	sql, rows := fc()
+	if !g.LogParams {
+		sql = RedactSQL(sql)
+	}
+
+	l := logger.WithCtx(ctx, "gorm").
+		WithField("sql", sql).
+		WithField("latency", float64(elapsed.Microseconds())/1000)
+	if rows >= 0 {
+		l = l.WithField("rows", rows)
+	}
+
+	switch {
+	case isError:
+		l.WithError(err).Error("query error")
+	case isSlow:
+		l.WithField("slow_threshold", g.SlowThreshold.String()).Warn(fmt.Sprintf("slow query >= %v", g.SlowThreshold))
+	default:
+		l.Info("query")
+	}
+}
+
+func (g *GormLogger) sampled() bool {
+	return g.SampleRate <= 0 || g.SampleRate >= 1 || rand.Float64() < g.SampleRate
+}
+
+// RedactSQL replaces string & numeric literals in an explained SQL by ?
+func RedactSQL(sql string) string {
+	return literalRegexp.ReplaceAllString(sql, "?")
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/praslar/cloud0/logger"
+	"github.com/stretchr/testify/assert"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	logger.Init("db.test")
+	buf := &bytes.Buffer{}
+	out := logger.DefaultLogger.Out
+	logger.DefaultLogger.SetOutput(buf)
+	t.Cleanup(func() {
+		logger.DefaultLogger.SetOutput(out)
+	})
+	return buf
+}
+
+func TestRedactSQL(t *testing.T) {
+	sql := `SELECT * FROM "user" WHERE name = 'O''Neil' AND age > 18 AND t1.id = 3.5 LIMIT 1`
+	assert.Equal(t, `SELECT * FROM "user" WHERE name = ? AND age > ? AND t1.id = ? LIMIT ?`, RedactSQL(sql))
+}
+
+func TestParseLogLevel(t *testing.T) {
+	assert.Equal(t, gormlogger.Silent, ParseLogLevel("silent"))
+	assert.Equal(t, gormlogger.Error, ParseLogLevel("ERROR"))
+	assert.Equal(t, gormlogger.Info, ParseLogLevel("info"))
+	assert.Equal(t, gormlogger.Warn, ParseLogLevel(""))
+}
+
+func TestGormLoggerTrace(t *testing.T) {
+	fc := func() (string, int64) {
+		return "SELECT * FROM users WHERE email = 'john@example.com'", 1
+	}
+	ctx := context.WithValue(context.Background(), "x-request-id", "req-1")
+
+	t.Run("LogNormalQueryAtInfoLevel", func(t *testing.T) {
+		buf := captureLog(t)
+		l := &GormLogger{Level: gormlogger.Info}
+		l.Trace(ctx, time.Now(), fc, nil)
+		assert.Contains(t, buf.String(), "req-1")
+		assert.Contains(t, buf.String(), "email = ?")
+		assert.NotContains(t, buf.String(), "john@example.com")
+	})
+
+	t.Run("SampleNormalQueries", func(t *testing.T) {
+		buf := captureLog(t)
+		l := &GormLogger{Level: gormlogger.Info, SampleRate: 0.000001}
+		l.Trace(ctx, time.Now(), fc, nil)
+		assert.Empty(t, buf.String())
+		l.Trace(ctx, time.Now(), fc, errors.New("connection refused"))
+		assert.Contains(t, buf.String(), "connection refused", "errors aren't sampled")
+	})
+
+	t.Run("LogParamsIfAllowed", func(t *testing.T) {
+		buf := captureLog(t)
+		l := &GormLogger{Level: gormlogger.Info, LogParams: true}
+		l.Trace(ctx, time.Now(), fc, nil)
+		assert.Contains(t, buf.String(), "john@example.com")
+	})
+
+	t.Run("SkipNormalQueryAtWarnLevel", func(t *testing.T) {
+		buf := captureLog(t)
+		l := &GormLogger{Level: gormlogger.Warn, SlowThreshold: time.Second}
+		l.Trace(ctx, time.Now(), fc, nil)
+		l.Trace(ctx, time.Now(), fc, gormlogger.ErrRecordNotFound)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("WarnSlowQuery", func(t *testing.T) {
+		buf := captureLog(t)
+		l := &GormLogger{Level: gormlogger.Warn, SlowThreshold: time.Millisecond}
+		l.Trace(ctx, time.Now().Add(-time.Second), fc, nil)
+		assert.Contains(t, buf.String(), "slow query")
+		assert.Contains(t, buf.String(), "warning")
+	})
+
+	t.Run("LogError", func(t *testing.T) {
+		buf := captureLog(t)
+		l := &GormLogger{Level: gormlogger.Error}
+		l.Trace(ctx, time.Now(), fc, errors.New("connection refused"))
+		assert.Contains(t, buf.String(), "connection refused")
+	})
+
+	t.Run("SilentLevel", func(t *testing.T) {
+		buf := captureLog(t)
+		l := (&GormLogger{Level: gormlogger.Info}).LogMode(gormlogger.Silent)
+		l.Trace(ctx, time.Now(), fc, errors.New("connection refused"))
+		assert.Empty(t, buf.String())
+	})
+}
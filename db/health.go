@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/praslar/cloud0/logger"
+	"gorm.io/gorm"
+)
+
+// HealthWatcher pings the database periodically to keep track of its reachability,
+// database/sql reconnects by itself so this is all we need to report the real state on health endpoints.
+// It implements service.Runner
+type HealthWatcher struct {
+	DB       *gorm.DB // use db.GetDB() if nil
+	Interval time.Duration
+
+	healthy int32
+	checked int32
+}
+
+// NewHealthWatcher makes a new health watcher, the state is unhealthy until the first check
+func NewHealthWatcher(gormDB *gorm.DB, interval time.Duration) *HealthWatcher {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &HealthWatcher{DB: gormDB, Interval: interval}
+}
+
+// Healthy reports whether the database was reachable at the last check
+func (w *HealthWatcher) Healthy() bool {
+	return atomic.LoadInt32(&w.healthy) == 1
+}
+
+// Check pings the database then updates the state, transitions are logged
+func (w *HealthWatcher) Check(ctx context.Context) error {
+	l := logger.Tag("db.HealthWatcher")
+	gormDB := w.DB
+	if gormDB == nil {
+		gormDB = GetDB()
+	}
+
+	err := ping(ctx, gormDB)
+	var healthy int32
+	if err == nil {
+		healthy = 1
+	}
+
+	firstCheck := atomic.SwapInt32(&w.checked, 1) == 0
+	if old := atomic.SwapInt32(&w.healthy, healthy); old != healthy || firstCheck {
+		if healthy == 1 && !firstCheck {
+			l.Info("database is reachable again")
+		} else if healthy == 0 {
+			l.WithError(err).Warn("database is unreachable")
+		}
+	}
+
+	return err
+}
+
+// Run checks the database every interval until ctx is done
+func (w *HealthWatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		_ = w.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// unreachablePostgresCfg points to a port that nobody listens on
+var unreachablePostgresCfg = Config{
+	Driver: "postgres",
+	Host:   "127.0.0.1",
+	Port:   "1",
+	Schema: "public",
+}
+
+// flakyDialector fails to initialize the first failures times
+type flakyDialector struct {
+	gorm.Dialector
+	failures *int
+}
+
+func (d flakyDialector) Initialize(db *gorm.DB) error {
+	if *d.failures > 0 {
+		*d.failures--
+		return errors.New("connection refused")
+	}
+	return d.Dialector.Initialize(db)
+}
+
+func TestOpenRetry(t *testing.T) {
+	t.Run("SucceedAfterRetrying", func(t *testing.T) {
+		failures := 2
+		sqliteDriver, _ := lookupDriver("sqlite")
+		RegisterDriver("flaky", Driver{Dialector: func(config *Config) gorm.Dialector {
+			return flakyDialector{Dialector: sqliteDriver.Dialector(config), failures: &failures}
+		}})
+
+		cfg := *inMemorySqliteCfg
+		cfg.Driver = "flaky"
+		cfg.ConnectRetryTimeout = 5
+		cfg.ConnectRetryMinBackoff = 10
+		gormDB, err := Open(&cfg)
+		require.NoError(t, err)
+		Close(gormDB)
+		assert.Equal(t, 0, failures)
+	})
+
+	t.Run("GiveUpAfterTimeout", func(t *testing.T) {
+		cfg := unreachablePostgresCfg
+		cfg.ConnectRetryTimeout = 1
+		cfg.ConnectRetryMinBackoff = 100
+
+		start := time.Now()
+		_, err := Open(&cfg)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "gave up connecting to database")
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("NoRetryByDefault", func(t *testing.T) {
+		cfg := unreachablePostgresCfg
+		_, err := Open(&cfg)
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "gave up")
+	})
+}
+
+func TestLazyConnectAndHealthWatcher(t *testing.T) {
+	cfg := unreachablePostgresCfg
+	cfg.LazyConnect = true
+	gormDB, err := Open(&cfg)
+	require.NoError(t, err, "lazy connect shouldn't wait for DB")
+	defer Close(gormDB)
+
+	w := NewHealthWatcher(gormDB, time.Second)
+	assert.Error(t, w.Check(context.Background()))
+	assert.False(t, w.Healthy())
+
+	sqliteDB, err := Open(inMemorySqliteCfg)
+	require.NoError(t, err)
+	defer Close(sqliteDB)
+
+	w.DB = sqliteDB
+	assert.NoError(t, w.Check(context.Background()))
+	assert.True(t, w.Healthy())
+}
@@ -0,0 +1,110 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/praslar/cloud0/logger"
+)
+
+// LeaderElection elects a single leader among the replicas holding the same lock name,
+// it's a BaseApp runner:
+//
+//	election := lock.NewLeaderElection(lock.New(nil), "report-leader")
+//	election.OnElected = func(ctx context.Context) { ... } // ctx is canceled when leadership is lost
+//	app.RegisterRunner(election)
+type LeaderElection struct {
+	Locker   *Locker
+	Name     string
+	Interval time.Duration // how often to try to acquire / refresh the lock, should be less than Locker.TTL
+
+	// OnElected is called in a new goroutine on gaining leadership, ctx is canceled when it's lost,
+	// it must return promptly then as leadership isn't given up (nor OnRevoked called) until it returns
+	OnElected func(ctx context.Context)
+	// OnRevoked is called after losing leadership (lock lost or shutting down)
+	OnRevoked func()
+
+	leader int32
+}
+
+// NewLeaderElection makes a new leader election for the lock name
+func NewLeaderElection(locker *Locker, name string) *LeaderElection {
+	return &LeaderElection{Locker: locker, Name: name, Interval: 10 * time.Second}
+}
+
+// IsLeader reports whether this replica is currently the leader
+func (e *LeaderElection) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+// Run campaigns for leadership until ctx is done
+func (e *LeaderElection) Run(ctx context.Context) error {
+	interval := e.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	log := logger.Tag("lock.LeaderElection").WithField("name", e.Name)
+
+	for {
+		lock, err := e.Locker.TryLock(ctx, e.Name)
+		switch {
+		case err == nil:
+			log.Info("elected as leader")
+			e.lead(ctx, lock, interval)
+			log.Info("leadership revoked")
+		case err != ErrNotAcquired && ctx.Err() == nil:
+			log.WithError(err).Warn("failed to campaign for leadership")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// lead holds the leadership until the lock is lost or ctx is done
+func (e *LeaderElection) lead(ctx context.Context, lock *Lock, interval time.Duration) {
+	atomic.StoreInt32(&e.leader, 1)
+	leaderCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	if e.OnElected != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.OnElected(leaderCtx)
+		}()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			if err := lock.Refresh(ctx); err != nil {
+				logger.Tag("lock.LeaderElection").WithField("name", e.Name).WithError(err).Warn("failed to keep leadership")
+				break loop
+			}
+		}
+	}
+
+	// the leader's work must be done before OnRevoked, a new election or returning from Run
+	cancel()
+	wg.Wait()
+	atomic.StoreInt32(&e.leader, 0)
+	if e.OnRevoked != nil {
+		e.OnRevoked()
+	}
+
+	releaseCtx, cancelRelease := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelRelease()
+	if err := lock.Release(releaseCtx); err != nil {
+		logger.Tag("lock.LeaderElection").WithField("name", e.Name).WithError(err).Warn("failed to release leadership")
+	}
+}
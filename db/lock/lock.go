@@ -0,0 +1,245 @@
+// Package lock provides distributed locks & leader election across replicas.
+//
+// On Postgres it uses advisory locks (session or transaction scoped), other databases (eg. SQLite in tests)
+// fall back to a lock table with expiration.
+package lock
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/praslar/cloud0/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const defaultTTL = 30 * time.Second
+
+var (
+	// ErrNotAcquired is returned by TryLock when the lock is held by someone else
+	ErrNotAcquired = errors.New("lock: not acquired")
+	// ErrLost is returned by Refresh when the lock isn't held anymore (connection lost or expired)
+	ErrLost = errors.New("lock: lost")
+)
+
+// DistributedLock presents a lock in the fallback lock table
+type DistributedLock struct {
+	Name      string    `gorm:"primaryKey;size:255"`
+	Owner     string    `gorm:"size:64;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// Locker acquires named locks
+//
+//	locker := lock.New(nil)
+//	l, err := locker.TryLock(ctx, "daily-report")
+//	if err == lock.ErrNotAcquired {
+//		return nil // another replica is doing it
+//	}
+//	defer l.Release(context.Background())
+type Locker struct {
+	DB           *gorm.DB      // use db.GetDB() if nil
+	TTL          time.Duration // expiration of locks in the fallback table, they should be refreshed before expiring
+	PollInterval time.Duration // how often Lock retries
+
+	migrateOnce sync.Once
+	migrateErr  error
+}
+
+// New makes a new locker
+func New(gormDB *gorm.DB) *Locker {
+	return &Locker{DB: gormDB, TTL: defaultTTL, PollInterval: time.Second}
+}
+
+func (l *Locker) getDB() *gorm.DB {
+	if l.DB != nil {
+		return l.DB
+	}
+	return db.GetDB()
+}
+
+func (l *Locker) ttl() time.Duration {
+	if l.TTL > 0 {
+		return l.TTL
+	}
+	return defaultTTL
+}
+
+func isPostgres(gormDB *gorm.DB) bool {
+	return gormDB.Dialector.Name() == "postgres"
+}
+
+// Key converts a lock name to an advisory lock key
+func Key(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// Lock presents an acquired lock
+type Lock struct {
+	Name string
+
+	locker *Locker
+	conn   *sql.Conn // postgres: the session holding the advisory lock
+	owner  string    // fallback table: owner token
+}
+
+// TryLock acquires the lock without waiting, ErrNotAcquired is returned if it's held by someone else.
+// On Postgres, it's a session advisory lock held on a dedicated connection until Release
+func (l *Locker) TryLock(ctx context.Context, name string) (*Lock, error) {
+	gormDB := l.getDB()
+	if isPostgres(gormDB) {
+		return l.tryAdvisoryLock(ctx, gormDB, name)
+	}
+	return l.tryTableLock(ctx, gormDB, name)
+}
+
+// Lock acquires the lock, waiting until it's available or ctx is done
+func (l *Locker) Lock(ctx context.Context, name string) (*Lock, error) {
+	interval := l.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	for {
+		lock, err := l.TryLock(ctx, name)
+		if err != ErrNotAcquired {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// TryTxLock acquires a lock scoped to the transaction tx, it's released on commit/rollback.
+// On Postgres it's pg_try_advisory_xact_lock, with the fallback table it takes a write lock on the lock row
+// so it may wait for other transactions holding it
+func (l *Locker) TryTxLock(tx *gorm.DB, name string) (bool, error) {
+	if isPostgres(tx) {
+		var acquired bool
+		err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", Key(name)).Scan(&acquired).Error
+		return acquired, err
+	}
+
+	if err := l.migrate(tx); err != nil {
+		return false, err
+	}
+	// an expired row, it never blocks session locks but holds the row until the transaction ends
+	row := &DistributedLock{Name: name, Owner: "tx:" + uuid.New().String(), ExpiresAt: time.Unix(0, 0)}
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"name": name}),
+	}).Create(row).Error
+	return err == nil, err
+}
+
+func (l *Locker) tryAdvisoryLock(ctx context.Context, gormDB *gorm.DB, name string) (*Lock, error) {
+	sqlDB, err := gormDB.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired bool
+	if err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", Key(name)).Scan(&acquired); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if !acquired {
+		_ = conn.Close()
+		return nil, ErrNotAcquired
+	}
+
+	return &Lock{Name: name, locker: l, conn: conn}, nil
+}
+
+func (l *Locker) tryTableLock(ctx context.Context, gormDB *gorm.DB, name string) (*Lock, error) {
+	if err := l.migrate(gormDB); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	owner := uuid.New().String()
+	acquired := false
+	err := gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// take over the expired lock
+		err := tx.Where(clause.Eq{Column: "name", Value: name}).Where("expires_at < ?", now).Delete(&DistributedLock{}).Error
+		if err != nil {
+			return err
+		}
+
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&DistributedLock{Name: name, Owner: owner, ExpiresAt: now.Add(l.ttl())})
+		acquired = res.RowsAffected == 1
+		return res.Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, ErrNotAcquired
+	}
+
+	return &Lock{Name: name, locker: l, owner: owner}, nil
+}
+
+// migrate creates the fallback lock table once
+func (l *Locker) migrate(gormDB *gorm.DB) error {
+	l.migrateOnce.Do(func() {
+		l.migrateErr = gormDB.Session(&gorm.Session{NewDB: true}).AutoMigrate(&DistributedLock{})
+	})
+	return l.migrateErr
+}
+
+// Refresh checks the lock is still held and extends its expiration (fallback table),
+// ErrLost is returned if it isn't held anymore
+func (lock *Lock) Refresh(ctx context.Context) error {
+	if lock.conn != nil {
+		if err := lock.conn.PingContext(ctx); err != nil {
+			return ErrLost
+		}
+		return nil
+	}
+
+	res := lock.locker.getDB().WithContext(ctx).Model(&DistributedLock{}).
+		Where(clause.Eq{Column: "name", Value: lock.Name}).Where("owner = ?", lock.owner).
+		Update("expires_at", time.Now().Add(lock.locker.ttl()))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrLost
+	}
+	return nil
+}
+
+// Release releases the lock
+func (lock *Lock) Release(ctx context.Context) error {
+	if lock.conn != nil {
+		defer lock.conn.Close()
+		_, err := lock.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", Key(lock.Name))
+		if err != nil {
+			// the session may still hold the lock, discard the connection instead of returning it to the pool
+			_ = lock.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		return err
+	}
+
+	return lock.locker.getDB().WithContext(ctx).
+		Where(clause.Eq{Column: "name", Value: lock.Name}).Where("owner = ?", lock.owner).
+		Delete(&DistributedLock{}).Error
+}
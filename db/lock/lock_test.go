@@ -0,0 +1,187 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/praslar/cloud0/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupDB(t *testing.T) *gorm.DB {
+	gormDB, err := db.Open(&db.Config{Driver: "sqlite3", DSN: ":memory:", MaxOpenConns: 1, MaxIdleConns: 1})
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close(gormDB) })
+	return gormDB
+}
+
+func TestTryLock(t *testing.T) {
+	gormDB := setupDB(t)
+	ctx := context.Background()
+	a, b := New(gormDB), New(gormDB)
+
+	lock, err := a.TryLock(ctx, "job")
+	require.NoError(t, err)
+
+	_, err = b.TryLock(ctx, "job")
+	assert.Equal(t, ErrNotAcquired, err)
+
+	other, err := b.TryLock(ctx, "another-job")
+	require.NoError(t, err)
+	require.NoError(t, other.Release(ctx))
+
+	require.NoError(t, lock.Refresh(ctx))
+	require.NoError(t, lock.Release(ctx))
+	assert.Equal(t, ErrLost, lock.Refresh(ctx))
+
+	lock, err = b.TryLock(ctx, "job")
+	require.NoError(t, err)
+	require.NoError(t, lock.Release(ctx))
+}
+
+func TestReleaseDiscardsSessionOnError(t *testing.T) {
+	sqlDB, err := setupDB(t).DB()
+	require.NoError(t, err)
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	require.NoError(t, err)
+
+	// pg_advisory_unlock doesn't exist on sqlite, as if the unlock failed
+	lock := &Lock{Name: "job", conn: conn}
+	assert.Error(t, lock.Release(ctx))
+	assert.Zero(t, sqlDB.Stats().OpenConnections, "the session isn't returned to the pool")
+}
+
+func TestTryLockExpired(t *testing.T) {
+	gormDB := setupDB(t)
+	ctx := context.Background()
+	a, b := New(gormDB), New(gormDB)
+	a.TTL = 50 * time.Millisecond
+
+	stale, err := a.TryLock(ctx, "job")
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	lock, err := b.TryLock(ctx, "job")
+	require.NoError(t, err, "expired lock should be taken over")
+	assert.Equal(t, ErrLost, stale.Refresh(ctx))
+	require.NoError(t, lock.Release(ctx))
+}
+
+func TestLockWaits(t *testing.T) {
+	gormDB := setupDB(t)
+	ctx := context.Background()
+	locker := New(gormDB)
+	locker.PollInterval = 10 * time.Millisecond
+
+	held, err := locker.TryLock(ctx, "job")
+	require.NoError(t, err)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = locker.Lock(timeoutCtx, "job")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		_ = held.Release(ctx)
+	}()
+	lock, err := locker.Lock(ctx, "job")
+	require.NoError(t, err)
+	require.NoError(t, lock.Release(ctx))
+}
+
+func TestTryTxLock(t *testing.T) {
+	gormDB := setupDB(t)
+	locker := New(gormDB)
+
+	err := gormDB.Transaction(func(tx *gorm.DB) error {
+		acquired, err := locker.TryTxLock(tx, "job")
+		assert.True(t, acquired)
+		return err
+	})
+	require.NoError(t, err)
+
+	// the transaction lock never blocks session locks after the transaction ends
+	lock, err := locker.TryLock(context.Background(), "job")
+	require.NoError(t, err)
+	require.NoError(t, lock.Release(context.Background()))
+}
+
+func TestLeaderElection(t *testing.T) {
+	gormDB := setupDB(t)
+
+	var elected, revoked int32
+	newElection := func() *LeaderElection {
+		e := NewLeaderElection(New(gormDB), "leader")
+		e.Interval = 10 * time.Millisecond
+		e.OnElected = func(ctx context.Context) {
+			atomic.AddInt32(&elected, 1)
+			<-ctx.Done()
+		}
+		e.OnRevoked = func() { atomic.AddInt32(&revoked, 1) }
+		return e
+	}
+	first, second := newElection(), newElection()
+
+	ctx1, stop1 := context.WithCancel(context.Background())
+	done1 := make(chan struct{})
+	go func() { _ = first.Run(ctx1); close(done1) }()
+	require.Eventually(t, first.IsLeader, time.Second, 5*time.Millisecond)
+
+	ctx2, stop2 := context.WithCancel(context.Background())
+	defer stop2()
+	go func() { _ = second.Run(ctx2) }()
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, second.IsLeader(), "only one leader at a time")
+
+	stop1()
+	<-done1
+	assert.False(t, first.IsLeader())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&revoked))
+
+	require.Eventually(t, second.IsLeader, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&elected))
+}
+
+func TestLeaderElectionWaitsForLeader(t *testing.T) {
+	gormDB := setupDB(t)
+
+	var running int32
+	var events []string
+	var mu sync.Mutex
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	e := NewLeaderElection(New(gormDB), "slow-leader")
+	e.Interval = 10 * time.Millisecond
+	e.OnElected = func(ctx context.Context) {
+		atomic.AddInt32(&running, 1)
+		<-ctx.Done()
+		// slow to stop
+		time.Sleep(30 * time.Millisecond)
+		record("stopped")
+		atomic.AddInt32(&running, -1)
+	}
+	e.OnRevoked = func() { record("revoked") }
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { _ = e.Run(ctx); close(done) }()
+	require.Eventually(t, e.IsLeader, time.Second, 5*time.Millisecond)
+
+	stop()
+	<-done
+	assert.Zero(t, atomic.LoadInt32(&running), "Run returns once the leader has stopped")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"stopped", "revoked"}, events)
+}
@@ -0,0 +1,104 @@
+// Package outbox implements the transactional outbox pattern:
+// events are written to an outbox table in the same transaction as the business data,
+// then a relay delivers them to a message broker/webhook with at-least-once semantic.
+package outbox
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Message statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Headers presents message headers, stored as a json text column
+type Headers map[string]string
+
+// Value implements the driver.Valuer interface.
+func (h Headers) Value() (driver.Value, error) {
+	if h == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (h *Headers) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return fmt.Errorf("unsupported type %T for outbox headers", value)
+	}
+	return json.Unmarshal(raw, h)
+}
+
+// OutboxMessage presents an event waiting to be (or already) published
+type OutboxMessage struct {
+	ID            int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Topic         string     `gorm:"size:255;not null" json:"topic"`
+	Key           string     `gorm:"size:255" json:"key"`
+	Payload       []byte     `json:"payload"`
+	Headers       Headers    `gorm:"type:text" json:"headers"`
+	Status        string     `gorm:"size:16;not null;index:idx_outbox_message_relay,priority:1" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_message_relay,priority:2" json:"next_attempt_at"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+// Migrate creates/updates the outbox table
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&OutboxMessage{})
+}
+
+// Enqueue writes a message to the outbox, tx should be the caller's transaction
+// so the message is only visible to the relay once the business data has been committed
+//
+//	err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+//		if err := tx.Create(order).Error; err != nil {
+//			return err
+//		}
+//		return outbox.Enqueue(tx, "order.created", order.Code, payload, nil)
+//	})
+func Enqueue(tx *gorm.DB, topic, key string, payload []byte, headers map[string]string) error {
+	if tx == nil {
+		return errors.New("outbox: nil transaction")
+	}
+	if topic == "" {
+		return errors.New("outbox: topic is required")
+	}
+
+	now := time.Now()
+	msg := &OutboxMessage{
+		Topic:         topic,
+		Key:           key,
+		Payload:       payload,
+		Headers:       headers,
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+
+	return tx.Create(msg).Error
+}
@@ -0,0 +1,183 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/praslar/cloud0/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(context.Context, []*OutboxMessage) error {
+	return errors.New("broker is down")
+}
+
+func setupDB(t *testing.T) *gorm.DB {
+	gormDB, err := db.Open(&db.Config{Driver: "sqlite3", DSN: ":memory:", MaxOpenConns: 1, MaxIdleConns: 1})
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close(gormDB) })
+	require.NoError(t, Migrate(gormDB))
+	return gormDB
+}
+
+func TestEnqueueInTransaction(t *testing.T) {
+	gormDB := setupDB(t)
+
+	t.Run("CommitWithTransaction", func(t *testing.T) {
+		err := gormDB.Transaction(func(tx *gorm.DB) error {
+			return Enqueue(tx, "order.created", "order-1", []byte(`{"id":1}`), map[string]string{"source": "test"})
+		})
+		require.NoError(t, err)
+
+		var msg OutboxMessage
+		require.NoError(t, gormDB.First(&msg).Error)
+		assert.Equal(t, "order.created", msg.Topic)
+		assert.Equal(t, StatusPending, msg.Status)
+		assert.Equal(t, "test", msg.Headers["source"])
+	})
+
+	t.Run("RollbackWithTransaction", func(t *testing.T) {
+		_ = gormDB.Transaction(func(tx *gorm.DB) error {
+			require.NoError(t, Enqueue(tx, "order.created", "order-2", []byte(`{}`), nil))
+			return errors.New("business error")
+		})
+
+		var count int64
+		gormDB.Model(&OutboxMessage{}).Where("key = ?", "order-2").Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("TopicRequired", func(t *testing.T) {
+		assert.Error(t, Enqueue(gormDB, "", "", nil, nil))
+	})
+}
+
+func TestRelayProcess(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("PublishAndMarkDelivered", func(t *testing.T) {
+		gormDB := setupDB(t)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, Enqueue(gormDB, "topic", "", []byte(`{}`), nil))
+		}
+
+		pub := NewMemoryPublisher()
+		relay := NewRelay(gormDB, pub, nil)
+		relay.Config.BatchSize = 2
+
+		n, err := relay.ProcessOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		n, err = relay.ProcessOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Len(t, pub.Messages(), 3)
+
+		var delivered int64
+		gormDB.Model(&OutboxMessage{}).Where("status = ?", StatusDelivered).Count(&delivered)
+		assert.Equal(t, int64(3), delivered)
+	})
+
+	t.Run("BackoffThenFailed", func(t *testing.T) {
+		gormDB := setupDB(t)
+		require.NoError(t, Enqueue(gormDB, "topic", "", []byte(`{}`), nil))
+
+		relay := NewRelay(gormDB, failingPublisher{}, nil)
+		relay.Config.MaxAttempts = 2
+		relay.Config.MinBackoff = 0
+
+		for i := 0; i < 2; i++ {
+			n, err := relay.ProcessOnce(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+		}
+
+		var msg OutboxMessage
+		require.NoError(t, gormDB.First(&msg).Error)
+		assert.Equal(t, StatusFailed, msg.Status)
+		assert.Equal(t, 2, msg.Attempts)
+		assert.Equal(t, "broker is down", msg.LastError)
+
+		n, err := relay.ProcessOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, n, "failed messages shouldn't be claimed again")
+	})
+
+	t.Run("SkipNotDueMessages", func(t *testing.T) {
+		gormDB := setupDB(t)
+		require.NoError(t, Enqueue(gormDB, "topic", "", []byte(`{}`), nil))
+
+		relay := NewRelay(gormDB, failingPublisher{}, nil)
+		relay.Config.MinBackoff = time.Hour
+		_, _ = relay.ProcessOnce(ctx)
+
+		n, err := relay.ProcessOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("PruneDelivered", func(t *testing.T) {
+		gormDB := setupDB(t)
+		require.NoError(t, Enqueue(gormDB, "topic", "", []byte(`{}`), nil))
+
+		relay := NewRelay(gormDB, NewMemoryPublisher(), nil)
+		_, err := relay.ProcessOnce(ctx)
+		require.NoError(t, err)
+
+		relay.Config.Retention = -time.Minute
+		n, err := relay.Prune(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+	})
+}
+
+func TestRelayBackoff(t *testing.T) {
+	relay := NewRelay(nil, nil, &RelayConfig{MinBackoff: time.Second, MaxBackoff: 5 * time.Second})
+	assert.Equal(t, time.Second, relay.backoff(1))
+	assert.Equal(t, 4*time.Second, relay.backoff(3))
+	assert.Equal(t, 5*time.Second, relay.backoff(10))
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	var got map[string][]map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(raw, &got)
+		if r.Header.Get("x-api-key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	pub := NewWebhookPublisher(srv.URL)
+	messages := []*OutboxMessage{
+		{ID: 1, Topic: "json", Payload: []byte(`{"a":1}`)},
+		{ID: 2, Topic: "text", Payload: []byte(`plain`)},
+	}
+
+	assert.Error(t, pub.Publish(context.Background(), messages))
+
+	pub.Header.Set("x-api-key", "secret")
+	require.NoError(t, pub.Publish(context.Background(), messages))
+	require.Len(t, got["messages"], 2)
+	assert.Equal(t, map[string]interface{}{"a": float64(1)}, got["messages"][0]["payload"])
+	assert.Equal(t, "plain", got["messages"][1]["payload"])
+}
+
+func TestNewRelayConfig(t *testing.T) {
+	t.Setenv("OUTBOX_BATCH_SIZE", "5")
+	cfg := NewRelayConfig()
+	assert.Equal(t, 100, cfg.BatchSize, "the environment isn't read")
+	assert.Equal(t, 10*time.Minute, cfg.MaxBackoff)
+	assert.Equal(t, 72*time.Hour, cfg.Retention)
+}
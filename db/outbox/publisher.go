@@ -0,0 +1,125 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Publisher delivers a batch of outbox messages to the outside world,
+// returning an error means the whole batch will be retried later
+type Publisher interface {
+	Publish(ctx context.Context, messages []*OutboxMessage) error
+}
+
+// MemoryPublisher keeps published messages in memory, it's useful for testing & local development
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []*OutboxMessage
+}
+
+// NewMemoryPublisher makes a new in-memory publisher
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// Publish implements the Publisher interface.
+func (p *MemoryPublisher) Publish(_ context.Context, messages []*OutboxMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, messages...)
+	return nil
+}
+
+// Messages returns a copy of published messages
+func (p *MemoryPublisher) Messages() []*OutboxMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*OutboxMessage(nil), p.messages...)
+}
+
+// webhookMessage presents a message in the webhook request body
+type webhookMessage struct {
+	ID        int64             `json:"id"`
+	Topic     string            `json:"topic"`
+	Key       string            `json:"key,omitempty"`
+	Payload   json.RawMessage   `json:"payload"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// WebhookPublisher posts batches of messages as json to an HTTP endpoint,
+// any non 2xx response is considered as a failure
+//
+//	{"messages": [{"id": 1, "topic": "order.created", "key": "...", "payload": {...}, "headers": {...}}]}
+//
+// payloads that aren't valid json are sent as json strings
+type WebhookPublisher struct {
+	URL    string
+	Header http.Header
+	Client *http.Client
+}
+
+// NewWebhookPublisher makes a webhook publisher with a default 10 seconds timeout client
+func NewWebhookPublisher(url string) *WebhookPublisher {
+	return &WebhookPublisher{
+		URL:    url,
+		Header: http.Header{},
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Publish implements the Publisher interface.
+func (p *WebhookPublisher) Publish(ctx context.Context, messages []*OutboxMessage) error {
+	body := struct {
+		Messages []webhookMessage `json:"messages"`
+	}{}
+	for _, m := range messages {
+		payload := json.RawMessage(m.Payload)
+		if !json.Valid(m.Payload) {
+			payload, _ = json.Marshal(string(m.Payload))
+		}
+		body.Messages = append(body.Messages, webhookMessage{
+			ID:        m.ID,
+			Topic:     m.Topic,
+			Key:       m.Key,
+			Payload:   payload,
+			Headers:   m.Headers,
+			CreatedAt: m.CreatedAt,
+		})
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	for k, v := range p.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("content-type", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded status %d", rsp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/config"
+	"github.com/praslar/cloud0/db"
+	"github.com/praslar/cloud0/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RelayConfig presents configuration of the outbox relay
+type RelayConfig struct {
+	BatchSize     int           `env:"OUTBOX_BATCH_SIZE" envDefault:"100"`
+	PollInterval  time.Duration `env:"OUTBOX_POLL_INTERVAL" envDefault:"1s"`
+	MaxAttempts   int           `env:"OUTBOX_MAX_ATTEMPTS" envDefault:"10"`
+	MinBackoff    time.Duration `env:"OUTBOX_MIN_BACKOFF" envDefault:"1s"`
+	MaxBackoff    time.Duration `env:"OUTBOX_MAX_BACKOFF" envDefault:"10m"`
+	Retention     time.Duration `env:"OUTBOX_RETENTION" envDefault:"72h"` // how long delivered messages are kept
+	PruneInterval time.Duration `env:"OUTBOX_PRUNE_INTERVAL" envDefault:"1h"`
+}
+
+// NewRelayConfig returns a config filled with default values, the environment isn't read:
+// load the config with app.RegisterConfig (or config.Load) to apply OUTBOX_* variables
+func NewRelayConfig() *RelayConfig {
+	cfg := &RelayConfig{}
+	_ = config.Defaults(cfg)
+	return cfg
+}
+
+// Relay polls pending messages from the outbox then hands them to the publisher,
+// it implements service.Runner so it can be managed by BaseApp
+//
+//	app.RegisterRunner(outbox.NewRelay(nil, outbox.NewWebhookPublisher(url), nil))
+//
+// multiple relays (replicas) can run concurrently, rows are claimed with FOR UPDATE SKIP LOCKED
+type Relay struct {
+	DB        *gorm.DB // use db.GetDB() if nil
+	Publisher Publisher
+	Config    *RelayConfig
+}
+
+// NewRelay makes a new relay, a nil config means default config
+func NewRelay(gormDB *gorm.DB, publisher Publisher, config *RelayConfig) *Relay {
+	if config == nil {
+		config = NewRelayConfig()
+	}
+	return &Relay{
+		DB:        gormDB,
+		Publisher: publisher,
+		Config:    config,
+	}
+}
+
+func (r *Relay) getDB() *gorm.DB {
+	if r.DB != nil {
+		return r.DB
+	}
+	return db.GetDB()
+}
+
+// Run polls & publishes messages until ctx is done
+func (r *Relay) Run(ctx context.Context) error {
+	l := logger.Tag("outbox.Relay")
+	if r.Publisher == nil {
+		return errors.New("outbox: nil publisher")
+	}
+
+	pollTicker := time.NewTicker(r.Config.PollInterval)
+	defer pollTicker.Stop()
+	pruneTicker := time.NewTicker(r.Config.PruneInterval)
+	defer pruneTicker.Stop()
+
+	for {
+		// drain the outbox as long as we get full batches
+		for {
+			n, err := r.ProcessOnce(ctx)
+			if err != nil && ctx.Err() == nil {
+				l.WithError(err).Error("failed to process outbox messages")
+			}
+			if err != nil || n < r.Config.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-pruneTicker.C:
+			if n, err := r.Prune(ctx); err != nil {
+				l.WithError(err).Error("failed to prune outbox messages")
+			} else if n > 0 {
+				l.Infof("pruned %d delivered outbox messages", n)
+			}
+		case <-pollTicker.C:
+		}
+	}
+}
+
+// ProcessOnce claims a batch of due messages, publishes them then updates their states,
+// it returns the number of claimed messages
+func (r *Relay) ProcessOnce(ctx context.Context) (int, error) {
+	var claimed int
+	err := r.getDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var messages []*OutboxMessage
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", StatusPending, time.Now()).
+			Order("id").
+			Limit(r.Config.BatchSize).
+			Find(&messages).Error
+		if err != nil {
+			return err
+		}
+
+		claimed = len(messages)
+		if claimed == 0 {
+			return nil
+		}
+
+		publishErr := r.Publisher.Publish(ctx, messages)
+		now := time.Now()
+
+		if publishErr == nil {
+			ids := make([]int64, 0, len(messages))
+			for _, m := range messages {
+				ids = append(ids, m.ID)
+			}
+			return tx.Model(&OutboxMessage{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+				"status":       StatusDelivered,
+				"attempts":     gorm.Expr("attempts + 1"),
+				"delivered_at": now,
+				"last_error":   "",
+			}).Error
+		}
+
+		for _, m := range messages {
+			attempts := m.Attempts + 1
+			updates := map[string]interface{}{
+				"attempts":        attempts,
+				"last_error":      publishErr.Error(),
+				"next_attempt_at": now.Add(r.backoff(attempts)),
+			}
+			if r.Config.MaxAttempts > 0 && attempts >= r.Config.MaxAttempts {
+				updates["status"] = StatusFailed
+			}
+			if err := tx.Model(&OutboxMessage{}).Where("id = ?", m.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return claimed, err
+}
+
+// Prune deletes delivered messages older than the retention
+func (r *Relay) Prune(ctx context.Context) (int64, error) {
+	tx := r.getDB().WithContext(ctx).
+		Where("status = ? AND delivered_at < ?", StatusDelivered, time.Now().Add(-r.Config.Retention)).
+		Delete(&OutboxMessage{})
+	return tx.RowsAffected, tx.Error
+}
+
+// backoff returns the exponential delay before the next attempt
+func (r *Relay) backoff(attempts int) time.Duration {
+	return common.Backoff(attempts, r.Config.MinBackoff, r.Config.MaxBackoff)
+}
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/praslar/cloud0/ginext"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	versionColumn   = "version"
+	upsertBatchSize = 500
+)
+
+var (
+	// ErrNotFound is returned by Repository when the record doesn't exist, it's rendered as 404 by ginext.ErrorHandler
+	ErrNotFound = ginext.NewError(http.StatusNotFound, "record not found")
+	// ErrConflict is returned by Repository on updating a stale record (optimistic locking), it's rendered as 409
+	ErrConflict = ginext.NewError(http.StatusConflict, "record has been modified by another request")
+)
+
+// VersionModel can be embedded into models to enable optimistic locking in Repository.Update,
+// any integer field with column name `version` works as well
+type VersionModel struct {
+	Version int64 `gorm:"not null;default:1" json:"version"`
+}
+
+// Repository provides common context-aware CRUD operations on model T,
+// soft delete is supported as usual by adding a gorm.DeletedAt field to the model
+//
+//	type UserRepo struct {
+//		*db.Repository[User]
+//	}
+//
+//	repo := db.NewRepository[User](nil)
+//	user, err := repo.Get(ctx, id)
+type Repository[T any] struct {
+	db *gorm.DB
+
+	schemaOnce sync.Once
+	schema     *schema.Schema
+	schemaErr  error
+}
+
+// NewRepository makes a new repository of model T, it uses the default DB if gormDB is nil
+func NewRepository[T any](gormDB *gorm.DB) *Repository[T] {
+	return &Repository[T]{db: gormDB}
+}
+
+// DB returns a gorm session bound to ctx
+func (r *Repository[T]) DB(ctx context.Context) *gorm.DB {
+	gormDB := r.db
+	if gormDB == nil {
+		gormDB = GetDB()
+	}
+	return gormDB.WithContext(ctx)
+}
+
+// Schema returns the parsed gorm schema of T
+func (r *Repository[T]) Schema() (*schema.Schema, error) {
+	r.schemaOnce.Do(func() {
+		stmt := &gorm.Statement{DB: r.DB(context.Background())}
+		r.schemaErr = stmt.Parse(new(T))
+		r.schema = stmt.Schema
+	})
+	return r.schema, r.schemaErr
+}
+
+// Create inserts a new record
+func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
+	return r.DB(ctx).Create(entity).Error
+}
+
+// Get finds a record by its primary key, ErrNotFound is returned if it doesn't exist
+func (r *Repository[T]) Get(ctx context.Context, id interface{}) (*T, error) {
+	entity := new(T)
+	if err := r.DB(ctx).Where(r.primaryKeyCond(id)).Take(entity).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return entity, nil
+}
+
+// Update saves all fields of a record (except created_at) by its primary key,
+// if the model has a version column, the update only succeeds when the version is unchanged
+// since the record was read (then the version is increased), ErrConflict is returned otherwise
+func (r *Repository[T]) Update(ctx context.Context, entity *T) error {
+	s, err := r.Schema()
+	if err != nil {
+		return err
+	}
+
+	tx := r.DB(ctx).Model(entity).Select("*").Omit("created_at")
+	versionField := s.LookUpField(versionColumn)
+	if versionField == nil {
+		tx = tx.Updates(entity)
+		if tx.Error == nil && tx.RowsAffected == 0 {
+			// mysql reports 0 affected rows when the values are unchanged
+			return r.exists(ctx, s, entity)
+		}
+		return tx.Error
+	}
+
+	rv := reflect.ValueOf(entity).Elem()
+	current, _ := versionField.ValueOf(rv)
+	currentVersion := reflect.ValueOf(current).Convert(reflect.TypeOf(int64(0))).Int()
+	if err = versionField.Set(rv, currentVersion+1); err != nil {
+		return err
+	}
+
+	tx = tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: versionColumn}, Value: currentVersion}).Updates(entity)
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		_ = versionField.Set(rv, currentVersion)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// exists returns ErrNotFound if the record having the primary key of entity doesn't exist
+func (r *Repository[T]) exists(ctx context.Context, s *schema.Schema, entity *T) error {
+	rv := reflect.ValueOf(entity).Elem()
+	tx := r.DB(ctx).Model(new(T))
+	for _, field := range s.PrimaryFields {
+		value, _ := field.ValueOf(rv)
+		tx = tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: value})
+	}
+
+	var count int64
+	if err := tx.Limit(1).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete deletes a record by its primary key (soft delete if the model has gorm.DeletedAt),
+// ErrNotFound is returned if it doesn't exist
+func (r *Repository[T]) Delete(ctx context.Context, id interface{}) error {
+	tx := r.DB(ctx).Where(r.primaryKeyCond(id)).Delete(new(T))
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return tx.Error
+}
+
+// HardDelete permanently deletes a record by its primary key, even it's soft deleted
+func (r *Repository[T]) HardDelete(ctx context.Context, id interface{}) error {
+	tx := r.DB(ctx).Unscoped().Where(r.primaryKeyCond(id)).Delete(new(T))
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return tx.Error
+}
+
+// Restore un-deletes a soft deleted record
+func (r *Repository[T]) Restore(ctx context.Context, id interface{}) error {
+	tx := r.DB(ctx).Unscoped().Model(new(T)).Where(r.primaryKeyCond(id)).Update("deleted_at", nil)
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return tx.Error
+}
+
+// List finds records paginated & sorted by pager, scopes can be used to filter them
+//
+//	users, err := repo.List(ctx, ginext.NewPagerWithGinCtx(c), func(tx *gorm.DB) *gorm.DB {
+//		return tx.Where("active = ?", true)
+//	})
+//	return ginext.NewResponseWithPager(http.StatusOK, users, pager), err
+func (r *Repository[T]) List(ctx context.Context, pager *ginext.Pager, scopes ...func(*gorm.DB) *gorm.DB) ([]*T, error) {
+	var entities []*T
+	tx := r.DB(ctx).Model(new(T)).Scopes(scopes...)
+	if err := pager.DoQuery(&entities, tx).Error; err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// Upsert inserts records in batches, records conflicting on conflictColumns (primary key by default)
+// are updated instead (except their primary key & created_at, the version is increased),
+// it works on both postgres & sqlite (INSERT ... ON CONFLICT)
+func (r *Repository[T]) Upsert(ctx context.Context, entities []*T, conflictColumns ...string) error {
+	if len(entities) == 0 {
+		return nil
+	}
+	s, err := r.Schema()
+	if err != nil {
+		return err
+	}
+
+	onConflict := clause.OnConflict{}
+	for _, column := range conflictColumns {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: column})
+	}
+	if len(onConflict.Columns) == 0 {
+		for _, field := range s.PrimaryFields {
+			onConflict.Columns = append(onConflict.Columns, clause.Column{Name: field.DBName})
+		}
+	}
+
+	kept := map[string]bool{"created_at": true, versionColumn: true}
+	for _, column := range onConflict.Columns {
+		kept[column.Name] = true
+	}
+	var updated []string
+	for _, field := range s.Fields {
+		// same columns as gorm's UpdateAll, fields with a DB default aren't inserted
+		if field.DBName == "" || field.PrimaryKey || !field.Creatable || kept[field.DBName] ||
+			(field.HasDefaultValue && field.DefaultValueInterface == nil) {
+			continue
+		}
+		updated = append(updated, field.DBName)
+	}
+	onConflict.DoUpdates = clause.AssignmentColumns(updated)
+	if s.LookUpField(versionColumn) != nil {
+		onConflict.DoUpdates = append(onConflict.DoUpdates, clause.Assignment{
+			Column: clause.Column{Name: versionColumn},
+			Value:  clause.Expr{SQL: "? + 1", Vars: []interface{}{clause.Column{Table: clause.CurrentTable, Name: versionColumn}}},
+		})
+	}
+	onConflict.DoNothing = len(onConflict.DoUpdates) == 0
+
+	return r.DB(ctx).Clauses(onConflict).CreateInBatches(entities, upsertBatchSize).Error
+}
+
+// WithDeleted is a scope including soft deleted records
+//
+//	repo.List(ctx, pager, db.WithDeleted)
+func WithDeleted(tx *gorm.DB) *gorm.DB {
+	return tx.Unscoped()
+}
+
+// primaryKeyCond makes a condition on the (single) primary key
+func (r *Repository[T]) primaryKeyCond(id interface{}) clause.Expression {
+	column := "id"
+	if s, err := r.Schema(); err == nil && s.PrioritizedPrimaryField != nil {
+		column = s.PrioritizedPrimaryField.DBName
+	}
+	return clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: id}
+}
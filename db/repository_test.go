@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/praslar/cloud0/ginext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type repoProduct struct {
+	ID    int64  `json:"id"`
+	SKU   string `gorm:"uniqueIndex;size:32" json:"sku"`
+	Name  string `json:"name"`
+	Price int    `json:"price"`
+	VersionModel
+	DeletedAt gorm.DeletedAt
+}
+
+func (repoProduct) GetSortableFields() []string {
+	return []string{"id", "price"}
+}
+
+type repoTag struct {
+	ID   int64
+	Name string
+}
+
+type repoStamped struct {
+	ID        int64
+	Name      string
+	CreatedAt time.Time
+}
+
+func setupRepository(t *testing.T) *Repository[repoProduct] {
+	cfg := *inMemorySqliteCfg
+	gormDB, err := Open(&cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { Close(gormDB) })
+	require.NoError(t, gormDB.AutoMigrate(&repoProduct{}, &repoTag{}))
+	return NewRepository[repoProduct](gormDB)
+}
+
+func TestRepositoryCRUD(t *testing.T) {
+	ctx := context.Background()
+	repo := setupRepository(t)
+
+	p := &repoProduct{SKU: "A1", Name: "Apple", Price: 10}
+	require.NoError(t, repo.Create(ctx, p))
+	assert.NotZero(t, p.ID)
+	assert.Equal(t, int64(1), p.Version)
+
+	got, err := repo.Get(ctx, p.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Apple", got.Name)
+
+	_, err = repo.Get(ctx, 999)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	got.Price = 0
+	require.NoError(t, repo.Update(ctx, got))
+	assert.Equal(t, int64(2), got.Version)
+	got, _ = repo.Get(ctx, p.ID)
+	assert.Equal(t, 0, got.Price, "zero values should be updated as well")
+
+	require.NoError(t, repo.Delete(ctx, p.ID))
+	_, err = repo.Get(ctx, p.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, repo.Delete(ctx, p.ID), ErrNotFound)
+
+	require.NoError(t, repo.Restore(ctx, p.ID))
+	_, err = repo.Get(ctx, p.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.HardDelete(ctx, p.ID))
+	assert.ErrorIs(t, repo.Restore(ctx, p.ID), ErrNotFound)
+}
+
+func TestRepositoryOptimisticLocking(t *testing.T) {
+	ctx := context.Background()
+	repo := setupRepository(t)
+	require.NoError(t, repo.Create(ctx, &repoProduct{SKU: "A1", Name: "Apple"}))
+
+	first, _ := repo.Get(ctx, 1)
+	second, _ := repo.Get(ctx, 1)
+
+	first.Name = "Green apple"
+	require.NoError(t, repo.Update(ctx, first))
+
+	second.Name = "Red apple"
+	assert.ErrorIs(t, repo.Update(ctx, second), ErrConflict)
+	assert.Equal(t, int64(1), second.Version, "version should be kept on conflict")
+
+	got, _ := repo.Get(ctx, 1)
+	assert.Equal(t, "Green apple", got.Name)
+}
+
+func TestRepositoryUpdateWithoutVersion(t *testing.T) {
+	ctx := context.Background()
+	repo := setupRepository(t)
+	tagRepo := NewRepository[repoTag](repo.db)
+
+	require.NoError(t, tagRepo.Create(ctx, &repoTag{Name: "new"}))
+	require.NoError(t, tagRepo.Update(ctx, &repoTag{ID: 1, Name: "hot"}))
+	require.NoError(t, tagRepo.Update(ctx, &repoTag{ID: 1, Name: "hot"}), "unchanged values")
+	assert.ErrorIs(t, tagRepo.Update(ctx, &repoTag{ID: 2, Name: "hot"}), ErrNotFound)
+}
+
+func TestRepositoryList(t *testing.T) {
+	ctx := context.Background()
+	repo := setupRepository(t)
+	for i, sku := range []string{"A", "B", "C", "D", "E"} {
+		require.NoError(t, repo.Create(ctx, &repoProduct{SKU: sku, Price: i * 10}))
+	}
+	require.NoError(t, repo.Delete(ctx, 5))
+
+	pager := &ginext.Pager{Page: 1, PageSize: 2, Sort: "-price"}
+	products, err := repo.List(ctx, pager)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), pager.TotalRows)
+	require.Len(t, products, 2)
+	assert.Equal(t, "D", products[0].SKU)
+
+	pager = &ginext.Pager{Page: 1, PageSize: 10}
+	products, err = repo.List(ctx, pager, WithDeleted, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("price >= ?", 30)
+	})
+	require.NoError(t, err)
+	assert.Len(t, products, 2)
+}
+
+func TestRepositoryUpsert(t *testing.T) {
+	ctx := context.Background()
+	repo := setupRepository(t)
+	require.NoError(t, repo.Create(ctx, &repoProduct{SKU: "A", Name: "Apple", Price: 10}))
+
+	err := repo.Upsert(ctx, []*repoProduct{
+		{SKU: "A", Name: "Apple", Price: 15},
+		{SKU: "B", Name: "Banana", Price: 5},
+	}, "sku")
+	require.NoError(t, err)
+
+	var count int64
+	repo.DB(ctx).Model(&repoProduct{}).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	got, err := repo.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 15, got.Price)
+	assert.Equal(t, int64(2), got.Version, "the version is increased, not overwritten")
+
+	stamped := NewRepository[repoStamped](repo.db)
+	createdAt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.db.AutoMigrate(&repoStamped{}))
+	require.NoError(t, stamped.Create(ctx, &repoStamped{ID: 1, Name: "old", CreatedAt: createdAt}))
+	require.NoError(t, stamped.Upsert(ctx, []*repoStamped{{ID: 1, Name: "new", CreatedAt: time.Now()}}))
+	var row repoStamped
+	require.NoError(t, repo.db.First(&row, 1).Error)
+	assert.Equal(t, "new", row.Name)
+	assert.True(t, createdAt.Equal(row.CreatedAt), "created_at is kept")
+
+	assert.NoError(t, repo.Upsert(ctx, nil))
+}
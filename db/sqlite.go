@@ -0,0 +1,18 @@
+package db
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	// pure-Go SQLite driver, registered as "sqlite" in database/sql
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	// cgo-free SQLite, it's also the default sqlite driver when building with CGO_ENABLED=0
+	RegisterDriver("sqlite-purego", Driver{Dialector: pureGoSqliteDialector})
+}
+
+func pureGoSqliteDialector(config *Config) gorm.Dialector {
+	return &sqlite.Dialector{DriverName: "sqlite", DSN: config.GetDSN()}
+}
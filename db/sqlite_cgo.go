@@ -0,0 +1,18 @@
+//go:build cgo
+
+package db
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func init() {
+	cgoSqlite := Driver{
+		Dialector: func(config *Config) gorm.Dialector {
+			return sqlite.Open(config.GetDSN())
+		},
+	}
+	RegisterDriver("sqlite", cgoSqlite)
+	RegisterDriver("sqlite3", cgoSqlite)
+}
@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package db
+
+func init() {
+	// mattn/go-sqlite3 doesn't work without cgo, fallback to the pure-Go driver
+	pureGoSqlite := Driver{Dialector: pureGoSqliteDialector}
+	RegisterDriver("sqlite", pureGoSqlite)
+	RegisterDriver("sqlite3", pureGoSqlite)
+}
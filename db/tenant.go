@@ -0,0 +1,290 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/praslar/cloud0/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tenant isolation strategies
+const (
+	TenantStrategyColumn = "column" // rows of all tenants share tables, filtered by tenant_id column
+	TenantStrategySchema = "schema" // each tenant has its own postgres schema
+)
+
+const tenantColumn = "tenant_id"
+
+type skipTenantScopeKey struct{}
+
+var (
+	// ErrMissingTenant is returned when querying a tenant scoped model without tenant in context
+	ErrMissingTenant = errors.New("missing tenant in context")
+	// ErrTenantMismatch is returned when creating a record that belongs to another tenant
+	ErrTenantMismatch = errors.New("record belongs to another tenant")
+
+	_ gorm.Plugin  = &TenantPlugin{}
+	_ TenantScoped = &TenantModel{}
+)
+
+// TenantScoped is implemented by models whose rows belong to a tenant,
+// queries, updates & deletes on those models are automatically filtered by the tenant in context
+// and the tenant is set on create.
+// The model must have a tenant_id column (not required with schema strategy)
+type TenantScoped interface {
+	TenantScoped()
+}
+
+// TenantModel can be embedded into models to make them tenant scoped
+//
+//	type Order struct {
+//		ID int64
+//		db.TenantModel
+//	}
+type TenantModel struct {
+	TenantID uint64 `gorm:"index;not null" json:"tenant_id"`
+}
+
+// TenantScoped implements the TenantScoped interface.
+func (TenantModel) TenantScoped() {}
+
+// WithTenant returns a copy of ctx carrying tenant ID, ginext.FromGinRequestContext does it for you
+func WithTenant(ctx context.Context, tenantID uint64) context.Context {
+	return context.WithValue(ctx, common.HeaderTenantID, tenantID)
+}
+
+// TenantFromContext returns tenant ID set in ctx
+func TenantFromContext(ctx context.Context) (uint64, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	switch v := ctx.Value(common.HeaderTenantID).(type) {
+	case uint64:
+		return v, v != 0
+	case string:
+		id, err := strconv.ParseUint(v, 10, 64)
+		return id, err == nil && id != 0
+	}
+	return 0, false
+}
+
+// WithoutTenantScope returns a copy of ctx that disables tenant scoping,
+// it should only be used for admin/background jobs working across tenants
+//
+//	db.GetDB().WithContext(db.WithoutTenantScope(ctx)).Find(&orders)
+func WithoutTenantScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipTenantScopeKey{}, true)
+}
+
+func isTenantScopeSkipped(ctx context.Context) bool {
+	skipped, _ := ctx.Value(skipTenantScopeKey{}).(bool)
+	return skipped
+}
+
+// TenantSchema returns the schema name of a tenant with the schema strategy
+func (c Config) TenantSchema(tenantID uint64) string {
+	return tenantSchema(c.TenantSchemaFormat, tenantID)
+}
+
+func tenantSchema(format string, tenantID uint64) string {
+	if format == "" {
+		format = "tenant_%d"
+	}
+	return fmt.Sprintf(format, tenantID)
+}
+
+// unqualifiedTable strips the schema from a table name
+func unqualifiedTable(table string) string {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		return table[i+1:]
+	}
+	return table
+}
+
+// TenantPlugin is a gorm plugin enforcing tenant isolation on TenantScoped models,
+// it's registered by Open
+type TenantPlugin struct {
+	Strategy     string
+	SchemaFormat string // format of tenant schema name, eg. tenant_%d
+}
+
+// Name implements the gorm.Plugin interface.
+func (p *TenantPlugin) Name() string {
+	return "cloud0:tenant"
+}
+
+// Initialize implements the gorm.Plugin interface.
+func (p *TenantPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("cloud0:tenant_create", p.beforeCreate); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("cloud0:tenant_query", p.scope); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("cloud0:tenant_row", p.scope); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("cloud0:tenant_update", p.beforeUpdate); err != nil {
+		return err
+	}
+	return cb.Delete().Before("gorm:delete").Register("cloud0:tenant_delete", p.scope)
+}
+
+// tenantOf returns tenant ID of current statement, ok is false if the model isn't tenant scoped
+// or the scoping is skipped
+func (p *TenantPlugin) tenantOf(db *gorm.DB) (tenantID uint64, ok bool) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || isTenantScopeSkipped(stmt.Context) {
+		return 0, false
+	}
+	if _, scoped := reflect.New(stmt.Schema.ModelType).Interface().(TenantScoped); !scoped {
+		return 0, false
+	}
+
+	tenantID, found := TenantFromContext(stmt.Context)
+	if !found {
+		_ = db.AddError(fmt.Errorf("%w: %s is tenant scoped", ErrMissingTenant, stmt.Schema.Name))
+		return 0, false
+	}
+
+	return tenantID, true
+}
+
+func (p *TenantPlugin) scope(db *gorm.DB) {
+	tenantID, ok := p.tenantOf(db)
+	if !ok {
+		return
+	}
+
+	if p.Strategy == TenantStrategySchema {
+		p.useTenantSchema(db, tenantID)
+		return
+	}
+
+	p.where(db, tenantID)
+}
+
+func (p *TenantPlugin) where(db *gorm.DB, tenantID uint64) {
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: tenantColumn}, Value: tenantID},
+	}})
+}
+
+// beforeUpdate scopes updates like scope does & keeps rows in their tenant: tenant_id is never updated,
+// updating it to another tenant fails with ErrTenantMismatch
+func (p *TenantPlugin) beforeUpdate(db *gorm.DB) {
+	tenantID, ok := p.tenantOf(db)
+	if !ok {
+		return
+	}
+
+	if p.Strategy == TenantStrategySchema {
+		p.useTenantSchema(db, tenantID)
+		return
+	}
+
+	p.where(db, tenantID)
+	stmt := db.Statement
+	otherTenant := func(v interface{}) bool {
+		return v != nil && fmt.Sprint(v) != "0" && fmt.Sprint(v) != strconv.FormatUint(tenantID, 10)
+	}
+	field := stmt.Schema.LookUpField(tenantColumn)
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		for k, v := range dest {
+			if (k == tenantColumn || field != nil && k == field.Name) && otherTenant(v) {
+				_ = db.AddError(ErrTenantMismatch)
+			}
+		}
+	default:
+		rv := reflect.Indirect(reflect.ValueOf(dest))
+		if field != nil && rv.Kind() == reflect.Struct && rv.Type() == stmt.Schema.ModelType {
+			if v, isZero := field.ValueOf(rv); !isZero && otherTenant(v) {
+				_ = db.AddError(ErrTenantMismatch)
+			}
+		}
+	}
+	// Save & Select("*") would write tenant_id as well
+	stmt.Omits = append(stmt.Omits, tenantColumn)
+}
+
+func (p *TenantPlugin) beforeCreate(db *gorm.DB) {
+	tenantID, ok := p.tenantOf(db)
+	if !ok {
+		return
+	}
+
+	if p.Strategy == TenantStrategySchema {
+		p.useTenantSchema(db, tenantID)
+		return
+	}
+
+	stmt := db.Statement
+	field := stmt.Schema.LookUpField(tenantColumn)
+	if field == nil {
+		_ = db.AddError(fmt.Errorf("tenant scoped model %s has no %s column", stmt.Schema.Name, tenantColumn))
+		return
+	}
+
+	setTenant := func(rv reflect.Value) {
+		current, isZero := field.ValueOf(rv)
+		if isZero {
+			_ = db.AddError(field.Set(rv, tenantID))
+		} else if fmt.Sprint(current) != strconv.FormatUint(tenantID, 10) {
+			_ = db.AddError(ErrTenantMismatch)
+		}
+	}
+
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Struct:
+		setTenant(stmt.ReflectValue)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			rv := reflect.Indirect(stmt.ReflectValue.Index(i))
+			if rv.Kind() == reflect.Struct {
+				setTenant(rv)
+			}
+		}
+	case reflect.Map:
+		if m, ok := stmt.Dest.(map[string]interface{}); ok {
+			m[tenantColumn] = tenantID
+		}
+	}
+}
+
+// useTenantSchema points the statement to the table in tenant schema
+func (p *TenantPlugin) useTenantSchema(db *gorm.DB, tenantID uint64) {
+	table := db.Statement.Table
+	if table == "" {
+		table = db.Statement.Schema.Table
+	}
+	db.Statement.Table = tenantSchema(p.SchemaFormat, tenantID) + "." + unqualifiedTable(table)
+	db.Statement.TableExpr = &clause.Expr{SQL: db.Statement.Quote(db.Statement.Table)}
+}
+
+// MigrateTenantSchema creates the schema of a tenant (with schema strategy) then migrates tenant scoped models into it
+func MigrateTenantSchema(db *gorm.DB, config *Config, tenantID uint64, models ...interface{}) error {
+	schemaName := config.TenantSchema(tenantID)
+	if err := db.Exec(fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS "%s"`, schemaName)).Error; err != nil {
+		return err
+	}
+
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		if err := db.Table(schemaName + "." + unqualifiedTable(stmt.Schema.Table)).AutoMigrate(model); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type tenantOrder struct {
+	ID   int64
+	Code string
+	TenantModel
+}
+
+func setupTenantDB(t *testing.T) *gorm.DB {
+	cfg := *inMemorySqliteCfg
+	gormDB, err := Open(&cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { Close(gormDB) })
+	require.NoError(t, gormDB.AutoMigrate(&tenantOrder{}, &sampleModel{}))
+	return gormDB
+}
+
+func TestTenantScope(t *testing.T) {
+	gormDB := setupTenantDB(t)
+	tenant1 := WithTenant(context.Background(), 1)
+	tenant2 := WithTenant(context.Background(), 2)
+
+	require.NoError(t, gormDB.WithContext(tenant1).Create(&tenantOrder{Code: "A"}).Error)
+	require.NoError(t, gormDB.WithContext(tenant1).Create([]*tenantOrder{{Code: "B"}, {Code: "C"}}).Error)
+	require.NoError(t, gormDB.WithContext(tenant2).Create(&tenantOrder{Code: "D"}).Error)
+
+	t.Run("SetTenantOnCreate", func(t *testing.T) {
+		var order tenantOrder
+		require.NoError(t, gormDB.WithContext(tenant2).First(&order).Error)
+		assert.Equal(t, uint64(2), order.TenantID)
+	})
+
+	t.Run("RejectCreatingForAnotherTenant", func(t *testing.T) {
+		err := gormDB.WithContext(tenant1).Create(&tenantOrder{Code: "E", TenantModel: TenantModel{TenantID: 2}}).Error
+		assert.ErrorIs(t, err, ErrTenantMismatch)
+	})
+
+	t.Run("ScopeQueries", func(t *testing.T) {
+		var orders []tenantOrder
+		require.NoError(t, gormDB.WithContext(tenant1).Find(&orders).Error)
+		assert.Len(t, orders, 3)
+
+		var count int64
+		require.NoError(t, gormDB.WithContext(tenant2).Model(&tenantOrder{}).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("ScopeUpdatesAndDeletes", func(t *testing.T) {
+		tx := gormDB.WithContext(tenant2).Model(&tenantOrder{}).Where("code IN ?", []string{"A", "D"}).Update("code", "X")
+		require.NoError(t, tx.Error)
+		assert.Equal(t, int64(1), tx.RowsAffected)
+
+		tx = gormDB.WithContext(tenant2).Where("code = ?", "B").Delete(&tenantOrder{})
+		require.NoError(t, tx.Error)
+		assert.Equal(t, int64(0), tx.RowsAffected)
+	})
+
+	t.Run("ScopeScanAndRows", func(t *testing.T) {
+		var codes []string
+		require.NoError(t, gormDB.WithContext(tenant1).Model(&tenantOrder{}).Select("code").Scan(&codes).Error)
+		assert.ElementsMatch(t, []string{"A", "B", "C"}, codes)
+
+		rows, err := gormDB.WithContext(tenant2).Model(&tenantOrder{}).Select("code").Rows()
+		require.NoError(t, err)
+		defer rows.Close()
+		codes = nil
+		for rows.Next() {
+			var code string
+			require.NoError(t, rows.Scan(&code))
+			codes = append(codes, code)
+		}
+		assert.Equal(t, []string{"X"}, codes)
+	})
+
+	t.Run("KeepRowsInTheirTenant", func(t *testing.T) {
+		var order tenantOrder
+		require.NoError(t, gormDB.WithContext(tenant1).Where("code = ?", "A").First(&order).Error)
+
+		order.TenantID = 2
+		assert.ErrorIs(t, gormDB.WithContext(tenant1).Save(&order).Error, ErrTenantMismatch)
+		err := gormDB.WithContext(tenant1).Model(&order).Updates(map[string]interface{}{"tenant_id": 2}).Error
+		assert.ErrorIs(t, err, ErrTenantMismatch)
+
+		order.TenantID = 0
+		order.Code = "A2"
+		require.NoError(t, gormDB.WithContext(tenant1).Save(&order).Error)
+		var saved tenantOrder
+		require.NoError(t, gormDB.WithContext(WithoutTenantScope(context.Background())).First(&saved, order.ID).Error)
+		assert.Equal(t, "A2", saved.Code)
+		assert.Equal(t, uint64(1), saved.TenantID, "tenant_id isn't written by Save")
+	})
+
+	t.Run("ErrorWithoutTenant", func(t *testing.T) {
+		var orders []tenantOrder
+		err := gormDB.WithContext(context.Background()).Find(&orders).Error
+		assert.ErrorIs(t, err, ErrMissingTenant)
+		assert.ErrorIs(t, gormDB.Create(&tenantOrder{Code: "F"}).Error, ErrMissingTenant)
+	})
+
+	t.Run("BypassScope", func(t *testing.T) {
+		var count int64
+		ctx := WithoutTenantScope(context.Background())
+		require.NoError(t, gormDB.WithContext(ctx).Model(&tenantOrder{}).Count(&count).Error)
+		assert.Equal(t, int64(4), count)
+	})
+
+	t.Run("IgnoreNotScopedModels", func(t *testing.T) {
+		assert.NoError(t, gormDB.Create(&sampleModel{Message: "shared"}).Error)
+	})
+}
+
+func TestTenantSchemaStrategy(t *testing.T) {
+	sqliteDriver, _ := lookupDriver("sqlite")
+	gormDB, err := gorm.Open(sqliteDriver.Dialector(inMemorySqliteCfg), &gorm.Config{
+		NamingStrategy: &schema.NamingStrategy{SingularTable: true, TablePrefix: "public."},
+	})
+	require.NoError(t, err)
+	require.NoError(t, gormDB.Use(&TenantPlugin{Strategy: TenantStrategySchema}))
+
+	ctx := WithTenant(context.Background(), 42)
+	stmt := gormDB.Session(&gorm.Session{DryRun: true}).WithContext(ctx).Find(&[]tenantOrder{}).Statement
+	assert.Contains(t, stmt.SQL.String(), "`tenant_42`.`tenant_order`")
+	assert.NotContains(t, stmt.SQL.String(), "public")
+	assert.NotContains(t, stmt.SQL.String(), "tenant_id")
+
+	assert.Equal(t, "shop_42", Config{TenantSchemaFormat: "shop_%d"}.TenantSchema(42))
+}
+
+func TestTenantFromContext(t *testing.T) {
+	_, ok := TenantFromContext(context.Background())
+	assert.False(t, ok)
+
+	id, ok := TenantFromContext(context.WithValue(context.Background(), "x-tenant-id", "12"))
+	assert.True(t, ok)
+	assert.Equal(t, uint64(12), id)
+}
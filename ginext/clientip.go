@@ -0,0 +1,171 @@
+package ginext
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContextKeyClientIP is the gin context key of the client IP resolved by ClientIPMiddleware
+const ContextKeyClientIP = "client-ip"
+
+// ClientIPResolver resolves the client IP of requests, forwarding headers (Forwarded, X-Forwarded-For & X-Real-IP)
+// are only honored when the request comes from a trusted proxy so clients can't spoof their IP
+type ClientIPResolver struct {
+	trusted atomic.Value // []*net.IPNet
+}
+
+// NewClientIPResolver makes a new resolver trusting proxies in the given CIDRs (or IPs)
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+	r := &ClientIPResolver{}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetTrustedProxies replaces the trusted proxies, it's safe to be called while serving requests
+func (r *ClientIPResolver) SetTrustedProxies(trustedProxies []string) error {
+	nets := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, cidr := range trustedProxies {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	r.trusted.Store(nets)
+	return nil
+}
+
+// IsTrusted reports whether ip belongs to a trusted proxy
+func (r *ClientIPResolver) IsTrusted(ip net.IP) bool {
+	nets, _ := r.trusted.Load().([]*net.IPNet)
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client IP of req: the remote address, or when it's a trusted proxy,
+// the first untrusted hop from the right of Forwarded (RFC 7239) or X-Forwarded-For, or X-Real-IP
+func (r *ClientIPResolver) Resolve(req *http.Request) string {
+	remote := parseHop(req.RemoteAddr)
+	if remote == nil {
+		return req.RemoteAddr
+	}
+	if !r.IsTrusted(remote) {
+		return remote.String()
+	}
+
+	if ip := r.walk(forwardedFor(req.Header.Values("Forwarded"))); ip != nil {
+		return ip.String()
+	}
+	if ip := r.walk(splitList(req.Header.Values("X-Forwarded-For"))); ip != nil {
+		return ip.String()
+	}
+	if ip := parseHop(req.Header.Get("X-Real-IP")); ip != nil {
+		return ip.String()
+	}
+	return remote.String()
+}
+
+// walk returns the rightmost untrusted hop (the leftmost if all are trusted),
+// nil if the chain is empty or has an invalid hop
+func (r *ClientIPResolver) walk(hops []string) net.IP {
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			return nil
+		}
+		if i == 0 || !r.IsTrusted(ip) {
+			return ip
+		}
+	}
+	return nil
+}
+
+func splitList(values []string) []string {
+	var items []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// forwardedFor returns the `for` parameters of Forwarded headers, eg.
+//
+//	Forwarded: for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, element := range splitList(values) {
+		for _, pair := range strings.Split(element, ";") {
+			i := strings.Index(pair, "=")
+			if i < 0 || !strings.EqualFold(strings.TrimSpace(pair[:i]), "for") {
+				continue
+			}
+			hops = append(hops, strings.Trim(strings.TrimSpace(pair[i+1:]), `"`))
+		}
+	}
+	return hops
+}
+
+// parseHop parses an IP with optional port: 192.0.2.1, 192.0.2.1:80, 2001:db8::1 or [2001:db8::1]:80
+func parseHop(hop string) net.IP {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
+
+// ClientIPMiddleware resolves the client IP of requests with resolver, get it with ClientIP or Request.ClientIP
+func ClientIPMiddleware(resolver *ClientIPResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ContextKeyClientIP, resolver.Resolve(c.Request))
+		c.Next()
+	}
+}
+
+// ClientIP returns the client IP resolved by ClientIPMiddleware,
+// without the middleware it's the remote address as forwarding headers can't be trusted
+func ClientIP(c *gin.Context) string {
+	if ip := c.GetString(ContextKeyClientIP); ip != "" {
+		return ip
+	}
+	if ip := parseHop(c.Request.RemoteAddr); ip != nil {
+		return ip.String()
+	}
+	return c.Request.RemoteAddr
+}
+
+// ClientIP returns the client IP, see ClientIPMiddleware
+func (r *Request) ClientIP() string {
+	return ClientIP(r.GinCtx)
+}
@@ -0,0 +1,84 @@
+package ginext
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"127.0.0.1", "10.0.0.0/8", "::1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"Direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"UntrustedSpoofing", "203.0.113.7:1234", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4"}, "203.0.113.7"},
+		{"XForwardedFor", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.5"}, "198.51.100.1"},
+		{"XForwardedForSpoofedHop", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.5"}, "198.51.100.1"},
+		{"XForwardedForAllTrusted", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "10.0.0.9, 10.0.0.5"}, "10.0.0.9"},
+		{"XForwardedForInvalid", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "garbage"}, "10.0.0.2"},
+		{"XRealIP", "127.0.0.1:1234", map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"Forwarded", "10.0.0.2:1234", map[string]string{"Forwarded": `for=198.51.100.3;proto=https, for="10.0.0.5:8080"`}, "198.51.100.3"},
+		{"ForwardedIPv6", "[::1]:1234", map[string]string{"Forwarded": `For="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"ForwardedTakesPrecedence", "10.0.0.2:1234", map[string]string{"Forwarded": "for=198.51.100.3", "X-Forwarded-For": "198.51.100.4"}, "198.51.100.3"},
+		{"ForwardedUnknown", "10.0.0.2:1234", map[string]string{"Forwarded": "for=unknown", "X-Forwarded-For": "198.51.100.4"}, "198.51.100.4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.want, resolver.Resolve(req))
+		})
+	}
+
+	t.Run("SetTrustedProxies", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+		require.NoError(t, resolver.SetTrustedProxies([]string{"203.0.113.0/24"}))
+		defer resolver.SetTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8", "::1"})
+		assert.Equal(t, "198.51.100.1", resolver.Resolve(req))
+
+		assert.Error(t, resolver.SetTrustedProxies([]string{"not-an-ip"}))
+		assert.Error(t, resolver.SetTrustedProxies([]string{"10.0.0.0/33"}))
+	})
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	var got string
+	router := gin.New()
+	router.Use(ClientIPMiddleware(resolver))
+	router.GET("/", WrapHandler(func(r *Request) (*Response, error) {
+		got = r.ClientIP()
+		return NewResponse(http.StatusOK), nil
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.1.2.3:5555"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "198.51.100.1", got)
+
+	t.Run("WithoutMiddleware", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		assert.Equal(t, "10.1.2.3", ClientIP(c), "forwarding headers are ignored")
+	})
+}
@@ -0,0 +1,346 @@
+package ginext
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// content codings supported by CompressMiddleware
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+var (
+	// ErrUnsupportedEncoding is returned when a request body is encoded with an unsupported Content-Encoding
+	ErrUnsupportedEncoding = NewError(http.StatusUnsupportedMediaType, "unsupported content encoding")
+	// ErrInvalidEncodedBody is returned when a compressed request body can't be decompressed
+	ErrInvalidEncodedBody = NewError(http.StatusBadRequest, "invalid compressed body")
+
+	// encodings supported by CompressMiddleware, by server preference
+	encodings = []string{EncodingGzip, EncodingDeflate}
+)
+
+// CompressConfig presents configuration of CompressMiddleware
+type CompressConfig struct {
+	Enabled            bool     `env:"COMPRESS_ENABLED" envDefault:"false"`
+	Level              int      `env:"COMPRESS_LEVEL" envDefault:"-1"`      // 1 (fastest) to 9 (smallest), -1 is the default level
+	MinSize            int      `env:"COMPRESS_MIN_SIZE" envDefault:"1024"` // in bytes, smaller responses are sent as is
+	ContentTypes       []string `env:"COMPRESS_CONTENT_TYPES" envSeparator:"," envDefault:"application/json,application/x-ndjson,application/yaml,application/xml,text/*"`
+	DecompressRequests bool     `env:"COMPRESS_DECOMPRESS_REQUESTS" envDefault:"true"` // gzip & deflate request bodies
+}
+
+// NewCompressConfig returns a config filled with default values
+func NewCompressConfig() *CompressConfig {
+	return &CompressConfig{
+		Level:              flate.DefaultCompression,
+		MinSize:            1024,
+		ContentTypes:       []string{MIMEJSON, "application/x-ndjson", MIMEYAML, "application/xml", "text/*"},
+		DecompressRequests: true,
+	}
+}
+
+// compressor is implemented by gzip.Writer & zlib.Writer
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// CompressMiddleware compresses responses with gzip or deflate as negotiated by Accept-Encoding: only responses
+// of ContentTypes that are at least MinSize bytes (or flushed, eg. streams) are compressed & Vary: Accept-Encoding
+// is set on any response that could be. ETags are kept as is, so If-Match keeps working on compressed responses.
+// Request bodies sent with Content-Encoding gzip or deflate are decompressed if DecompressRequests, it should be
+// registered before MaxBodyMiddleware so the limit applies to decompressed bodies.
+func CompressMiddleware(config *CompressConfig) gin.HandlerFunc {
+	level := config.Level
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		level = flate.DefaultCompression
+	}
+	pools := map[string]*sync.Pool{
+		EncodingGzip: {New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(io.Discard, level)
+			return w
+		}},
+		EncodingDeflate: {New: func() interface{} {
+			w, _ := zlib.NewWriterLevel(io.Discard, level)
+			return w
+		}},
+	}
+	readers := &sync.Pool{}
+
+	return func(c *gin.Context) {
+		if config.DecompressRequests {
+			release, err := decompressBody(c.Request, readers)
+			if err != nil {
+				_ = c.Error(err)
+				c.Abort()
+				return
+			}
+			defer release()
+		}
+
+		// websocket upgrades hijack the connection
+		if c.GetHeader("Upgrade") != "" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{
+			ResponseWriter: c.Writer,
+			config:         config,
+			encoding:       negotiateEncoding(c.GetHeader("Accept-Encoding")),
+			head:           c.Request.Method == http.MethodHead,
+			pools:          pools,
+		}
+		c.Writer = w
+		defer func() {
+			w.finish()
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
+
+// negotiateEncoding returns the preferred supported encoding of an Accept-Encoding header, "" if none is acceptable
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+	qs := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qs[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range encodings {
+		q, ok := qs[encoding]
+		if !ok {
+			// * only applies to encodings not listed
+			q = qs["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// decompressBody replaces a compressed request body by its decompressed content, release must be called once
+// the body isn't used anymore
+func decompressBody(req *http.Request, readers *sync.Pool) (release func(), err error) {
+	release = func() {}
+	encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || req.Body == nil || req.Body == http.NoBody {
+		return release, nil
+	}
+
+	var body io.ReadCloser
+	switch encoding {
+	case EncodingGzip, "x-gzip":
+		zr, _ := readers.Get().(*gzip.Reader)
+		if zr == nil {
+			zr, err = gzip.NewReader(req.Body)
+		} else {
+			err = zr.Reset(req.Body)
+		}
+		if err != nil {
+			return release, ErrInvalidEncodedBody
+		}
+		body = zr
+		release = func() {
+			_ = zr.Close()
+			readers.Put(zr)
+		}
+	case EncodingDeflate:
+		if body, err = zlib.NewReader(req.Body); err != nil {
+			return release, ErrInvalidEncodedBody
+		}
+		release = func() {
+			_ = body.Close()
+		}
+	default:
+		return release, ErrUnsupportedEncoding
+	}
+
+	req.Body = &decompressedBody{Reader: body, Closer: req.Body}
+	req.Header.Del("Content-Encoding")
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+	return release, nil
+}
+
+// decompressedBody reads the decompressed content & closes the original body
+type decompressedBody struct {
+	io.Reader
+	io.Closer
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		if _, ok := err.(ApiError); !ok {
+			err = ErrInvalidEncodedBody
+		}
+	}
+	return n, err
+}
+
+// compressWriter buffers up to MinSize bytes to decide whether the response is compressed
+type compressWriter struct {
+	gin.ResponseWriter
+
+	config   *CompressConfig
+	encoding string // negotiated, "" if the client doesn't accept any
+	head     bool
+	pools    map[string]*sync.Pool
+
+	buf       []byte
+	started   bool
+	headerNow bool // WriteHeaderNow was called before anything was written
+	zw        compressor
+}
+
+// start decides whether the response is compressed & writes the buffered bytes
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// like net/http, but before the content is compressed
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	if w.compressible() {
+		header.Add("Vary", "Accept-Encoding")
+		if compress && w.encoding != "" && !w.head {
+			header.Set("Content-Encoding", w.encoding)
+			header.Del("Content-Length")
+			w.zw = w.pools[w.encoding].Get().(compressor)
+			w.zw.Reset(w.ResponseWriter)
+		}
+	}
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	_, err := w.out().Write(buf)
+	return err
+}
+
+// compressible tells whether the response could be compressed, whatever its size & the client
+func (w *compressWriter) compressible() bool {
+	status := w.Status()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent {
+		return false
+	}
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, allowed := range w.config.ContentTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mediaType || allowed == "*/*" ||
+			strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *compressWriter) out() io.Writer {
+	if w.zw != nil {
+		return w.zw
+	}
+	return w.ResponseWriter
+}
+
+// finish writes what's left & releases the compressor
+func (w *compressWriter) finish() {
+	if !w.started {
+		if !w.Written() {
+			return
+		}
+		_ = w.start(len(w.buf) >= w.config.MinSize)
+		w.ResponseWriter.WriteHeaderNow()
+	}
+	if w.zw != nil {
+		_ = w.zw.Close()
+		w.zw.Reset(io.Discard)
+		w.pools[w.encoding].Put(w.zw)
+		w.zw = nil
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.started {
+		return w.out().Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.config.MinSize {
+		return len(p), nil
+	}
+	if err := w.start(true); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow commits the headers, they're sent with the first flush or once the response is complete so
+// that the body written in the meantime (eg. streams) can still be compressed
+func (w *compressWriter) WriteHeaderNow() {
+	if w.started {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.headerNow = true
+}
+
+func (w *compressWriter) Written() bool {
+	return w.headerNow || len(w.buf) > 0 || w.ResponseWriter.Written()
+}
+
+// Flush sends what's been written so far, streamed responses are compressed whatever their size
+func (w *compressWriter) Flush() {
+	if !w.started {
+		_ = w.start(true)
+	}
+	if w.zw != nil {
+		_ = w.zw.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
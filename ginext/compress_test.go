@@ -0,0 +1,222 @@
+package ginext
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                         "",
+		"gzip, deflate, br":        EncodingGzip,
+		"deflate":                  EncodingDeflate,
+		"gzip;q=0.5, deflate":      EncodingDeflate,
+		"GZIP":                     EncodingGzip,
+		"*":                        EncodingGzip,
+		"gzip;q=0, *":              EncodingDeflate,
+		"br, identity":             "",
+		"gzip;q=0, deflate;q=0, *": "",
+	} {
+		assert.Equal(t, expected, negotiateEncoding(header), header)
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	large := strings.Repeat("compressible ", 200)
+	config := NewCompressConfig()
+
+	router := gin.New()
+	router.Use(CreateErrorHandler(), CompressMiddleware(config))
+	router.GET("/large", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": large})
+	})
+	router.GET("/small", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": "small"})
+	})
+	router.GET("/image", func(c *gin.Context) {
+		c.Data(http.StatusOK, "image/png", []byte(large))
+	})
+	router.GET("/empty", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.GET("/stream", func(c *gin.Context) {
+		c.Header("Content-Type", "application/x-ndjson")
+		_, _ = c.Writer.WriteString("{\"n\":1}\n")
+		c.Writer.Flush()
+		_, _ = c.Writer.WriteString("{\"n\":2}\n")
+	})
+
+	do := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("gzip", func(t *testing.T) {
+		// writers are pooled, they must be reusable
+		for i := 0; i < 3; i++ {
+			w := do("/large", "gzip, deflate")
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, EncodingGzip, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+			assert.Less(t, w.Body.Len(), len(large))
+
+			zr, err := gzip.NewReader(w.Body)
+			require.NoError(t, err)
+			body, err := io.ReadAll(zr)
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"data":"`+large+`"}`, string(body))
+		}
+	})
+
+	t.Run("deflate", func(t *testing.T) {
+		w := do("/large", "deflate")
+		assert.Equal(t, EncodingDeflate, w.Header().Get("Content-Encoding"))
+		zr, err := zlib.NewReader(w.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"data":"`+large+`"}`, string(body))
+	})
+
+	t.Run("not accepted", func(t *testing.T) {
+		w := do("/large", "")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.JSONEq(t, `{"data":"`+large+`"}`, w.Body.String())
+	})
+
+	t.Run("below min size", func(t *testing.T) {
+		w := do("/small", "gzip")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.JSONEq(t, `{"data":"small"}`, w.Body.String())
+	})
+
+	t.Run("content type not allowed", func(t *testing.T) {
+		w := do("/image", "gzip")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Empty(t, w.Header().Get("Vary"))
+		assert.Equal(t, large, w.Body.String())
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		w := do("/empty", "gzip")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		w := do("/stream", "gzip")
+		assert.Equal(t, EncodingGzip, w.Header().Get("Content-Encoding"))
+		assert.True(t, w.Flushed)
+		zr, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, "{\"n\":1}\n{\"n\":2}\n", string(body))
+	})
+}
+
+func TestCompressStreams(t *testing.T) {
+	router := gin.New()
+	router.Use(CreateErrorHandler(), CompressMiddleware(NewCompressConfig()))
+	router.GET("/ndjson", WrapNDJSONHandler(func(r *Request, s *NDJSONStream) error {
+		return WriteChannel(s, sliceChannel([]int{1, 2}))
+	}))
+	router.GET("/events", WrapSSEHandler(func(r *Request, s *SSEStream) error {
+		return s.Send(Event{ID: "1", Data: "hello"})
+	}, &SSEConfig{Heartbeat: -1}))
+
+	for path, expected := range map[string]string{
+		"/ndjson": "1\n2\n",
+		"/events": "id: 1\ndata: hello\n\n",
+	} {
+		t.Run(path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, EncodingGzip, w.Header().Get("Content-Encoding"))
+			assert.True(t, w.Flushed)
+			zr, err := gzip.NewReader(w.Body)
+			require.NoError(t, err)
+			body, err := io.ReadAll(zr)
+			require.NoError(t, err)
+			assert.Equal(t, expected, string(body))
+		})
+	}
+}
+
+func sliceChannel[T any](values []T) <-chan T {
+	ch := make(chan T, len(values))
+	for _, v := range values {
+		ch <- v
+	}
+	close(ch)
+	return ch
+}
+
+func TestCompressMiddlewareRequestBody(t *testing.T) {
+	router := gin.New()
+	router.Use(CreateErrorHandler(), CompressMiddleware(NewCompressConfig()), MaxBodyMiddleware(64))
+	router.POST("/items", WrapHandler(func(r *Request) (*Response, error) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		r.MustBind(&req)
+		return NewResponseData(http.StatusOK, req.Name), nil
+	}))
+
+	do := func(encoding string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/items", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", encoding)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write([]byte(s))
+		_ = zw.Close()
+		return buf.Bytes()
+	}
+
+	for i := 0; i < 2; i++ {
+		w := do("gzip", gzipped(`{"name":"gopher"}`))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "gopher")
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, _ = zw.Write([]byte(`{"name":"deflated"}`))
+	_ = zw.Close()
+	w := do("deflate", buf.Bytes())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "deflated")
+
+	assert.Equal(t, http.StatusBadRequest, do("gzip", []byte(`{"name":"plain"}`)).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, do("br", []byte(`{}`)).Code)
+
+	// the limit applies to the decompressed body
+	w = do("gzip", gzipped(`{"name":"`+strings.Repeat("a", 1000)+`"}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
@@ -0,0 +1,177 @@
+package ginext
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/logger"
+)
+
+// CORSConfig presents the CORS policy, see CORSMiddleware
+type CORSConfig struct {
+	Enabled          bool     `env:"CORS_ENABLED" envDefault:"false"`
+	AllowOrigins     []string `env:"CORS_ALLOW_ORIGINS" envSeparator:"," envDefault:"*"` // eg. https://app.example.com,https://*.example.com
+	AllowMethods     []string `env:"CORS_ALLOW_METHODS" envSeparator:"," envDefault:"GET,POST,PUT,PATCH,DELETE,OPTIONS"`
+	AllowHeaders     []string `env:"CORS_ALLOW_HEADERS" envSeparator:"," envDefault:"Origin,Accept,Content-Type,Authorization,X-Request-ID,Idempotency-Key,If-Match,If-None-Match"` // * allows any
+	ExposeHeaders    []string `env:"CORS_EXPOSE_HEADERS" envSeparator:"," envDefault:"X-Request-ID,ETag,Retry-After"`
+	AllowCredentials bool     `env:"CORS_ALLOW_CREDENTIALS" envDefault:"false"` // origin * is ignored then
+	MaxAge           int      `env:"CORS_MAX_AGE" envDefault:"600"`             // how long (in seconds) browsers cache preflight responses
+}
+
+// NewCORSConfig returns a config filled with default values
+func NewCORSConfig() *CORSConfig {
+	return &CORSConfig{
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders: []string{"Origin", "Accept", "Content-Type", "Authorization", "X-Request-ID", "Idempotency-Key",
+			"If-Match", "If-None-Match"},
+		ExposeHeaders: []string{"X-Request-ID", "ETag", "Retry-After"},
+		MaxAge:        600,
+	}
+}
+
+type originPattern struct {
+	scheme string
+	host   string // without the leading "*." for wildcard patterns
+	any    bool   // *
+	sub    bool   // *.host matches subdomains of host
+}
+
+// matches reports whether origin (scheme://host[:port]) matches the pattern
+func (p originPattern) matches(scheme, host string) bool {
+	if p.any {
+		return true
+	}
+	if p.scheme != scheme {
+		return false
+	}
+	if p.sub {
+		return strings.HasSuffix(host, "."+p.host)
+	}
+	return host == p.host
+}
+
+func parseOrigin(origin string) (scheme, host string, ok bool) {
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", "", false
+	}
+	return u.Scheme, u.Host, true
+}
+
+// CORSMiddleware applies the CORS policy of config: allowed origins get Access-Control-* headers (wildcard patterns
+// like https://*.example.com match subdomains), preflight requests are answered with 204 or 403 if the origin
+// isn't allowed. It should be registered globally so preflight requests of any route are answered.
+// Credentials are never allowed for "*", any site could make credentialed requests otherwise: it's ignored
+// when AllowCredentials is set, list the origins explicitly instead.
+func CORSMiddleware(config *CORSConfig) gin.HandlerFunc {
+	var patterns []originPattern
+	for _, origin := range config.AllowOrigins {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			if config.AllowCredentials {
+				logger.Tag("ginext.CORSMiddleware").Warn("CORS origin * is ignored as credentials are allowed")
+				continue
+			}
+			patterns = append(patterns, originPattern{any: true})
+			continue
+		}
+		p := originPattern{}
+		if i := strings.Index(origin, "://*."); i >= 0 {
+			p.sub = true
+			origin = origin[:i] + "://" + origin[i+len("://*."):]
+		}
+		scheme, host, ok := parseOrigin(origin)
+		if !ok {
+			continue
+		}
+		p.scheme, p.host = scheme, host
+		patterns = append(patterns, p)
+	}
+
+	allowAnyHeader := false
+	for _, h := range config.AllowHeaders {
+		if strings.TrimSpace(h) == "*" {
+			allowAnyHeader = true
+		}
+	}
+	methods := strings.Join(config.AllowMethods, ", ")
+	headers := strings.Join(config.AllowHeaders, ", ")
+	expose := strings.Join(config.ExposeHeaders, ", ")
+	maxAge := strconv.Itoa(config.MaxAge)
+	// a fixed "*" can be cached by any origin
+	wildcard := len(patterns) == 1 && patterns[0].any
+
+	allowed := func(origin string) bool {
+		scheme, host, ok := parseOrigin(origin)
+		if !ok {
+			return false
+		}
+		for _, p := range patterns {
+			if p.matches(scheme, host) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !wildcard {
+			header.Add("Vary", "Origin")
+		}
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !allowed(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if wildcard {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if config.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if expose != "" {
+				header.Set("Access-Control-Expose-Headers", expose)
+			}
+			c.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", methods)
+		if allowAnyHeader {
+			if requested := c.GetHeader("Access-Control-Request-Headers"); requested != "" {
+				header.Set("Access-Control-Allow-Headers", requested)
+			}
+		} else if headers != "" {
+			header.Set("Access-Control-Allow-Headers", headers)
+		}
+		if config.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
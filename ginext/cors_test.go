@@ -0,0 +1,136 @@
+package ginext
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	config := NewCORSConfig()
+	config.AllowOrigins = []string{"https://app.example.com", "https://*.example.org"}
+	config.AllowCredentials = true
+
+	router := gin.New()
+	router.Use(RequestIDMiddleware, CORSMiddleware(config))
+	router.GET("/items", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	do := func(method, origin string, header map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/items", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		w := do(http.MethodGet, "https://app.example.com", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "X-Request-ID")
+		assert.Contains(t, w.Header().Values("Vary"), "Origin")
+	})
+
+	t.Run("wildcard subdomain", func(t *testing.T) {
+		for origin, allowed := range map[string]bool{
+			"https://api.example.org":     true,
+			"https://a.b.example.org":     true,
+			"https://example.org":         false,
+			"http://api.example.org":      false,
+			"https://api.example.org.com": false,
+			"https://evilexample.org":     false,
+		} {
+			w := do(http.MethodGet, origin, nil)
+			assert.Equal(t, http.StatusOK, w.Code, origin)
+			if allowed {
+				assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"), origin)
+			} else {
+				assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), origin)
+			}
+		}
+	})
+
+	t.Run("same origin", func(t *testing.T) {
+		w := do(http.MethodGet, "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("preflight", func(t *testing.T) {
+		w := do(http.MethodOptions, "https://app.example.com", map[string]string{
+			"Access-Control-Request-Method":  http.MethodPost,
+			"Access-Control-Request-Headers": "content-type",
+		})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), http.MethodPost)
+		assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Content-Type")
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	})
+
+	t.Run("preflight of disallowed origin", func(t *testing.T) {
+		w := do(http.MethodOptions, "https://evil.com", map[string]string{"Access-Control-Request-Method": http.MethodPost})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
+
+func TestCORSMiddlewareAnyOrigin(t *testing.T) {
+	config := NewCORSConfig()
+	config.AllowHeaders = []string{"*"}
+
+	router := gin.New()
+	router.Use(CORSMiddleware(config))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/anything", nil)
+	req.Header.Set("Origin", "https://any.site")
+	req.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+	req.Header.Set("Access-Control-Request-Headers", "x-custom")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "x-custom", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestCORSMiddlewareAnyOriginWithCredentials(t *testing.T) {
+	config := NewCORSConfig()
+	config.AllowOrigins = []string{"*", "https://app.example.com"}
+	config.AllowCredentials = true
+
+	router := gin.New()
+	router.Use(CORSMiddleware(config))
+	router.GET("/items", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	do := func(origin string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("Origin", origin)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// * must not let any site make credentialed requests
+	w := do("https://evil.com")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+	w = do("https://app.example.com")
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+}
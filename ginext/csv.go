@@ -0,0 +1,196 @@
+package ginext
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// csvFlushRows is the number of rows written between flushes while streaming csv
+const csvFlushRows = 100
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func isList(data interface{}) bool {
+	v := reflect.ValueOf(data)
+	return v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+}
+
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+// csvColumns derives the columns of a struct type from its json tags, embedded structs are flattened
+func csvColumns(t reflect.Type) []csvColumn {
+	var columns []csvColumn
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for _, column := range csvColumns(ft) {
+				column.index = append([]int{i}, column.index...)
+				columns = append(columns, column)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		columns = append(columns, csvColumn{name: name, index: []int{i}})
+	}
+	return columns
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex returning an invalid value on nil embedded pointers
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// csvEscape prevents spreadsheets from evaluating a text cell as a formula (CSV injection)
+func csvEscape(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// csvValue formats a cell, numbers & booleans are written as is, other values as json,
+// text that would be evaluated by spreadsheets is escaped
+func csvValue(v reflect.Value) string {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return ""
+	}
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339)
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return csvEscape(s.String())
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return csvEscape(v.String())
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface())
+	}
+	data, _ := json.Marshal(v.Interface())
+	return string(data)
+}
+
+// writeCSV streams a list as csv, the header row comes from the json tags of the items
+// (or the keys of the first item for lists of maps)
+func writeCSV(w http.ResponseWriter, code int, data interface{}) error {
+	w.Header().Set("Content-Type", contentType(MIMECSV))
+	w.WriteHeader(code)
+
+	list := reflect.ValueOf(data)
+	cw := csv.NewWriter(w)
+	flush := func() error {
+		cw.Flush()
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return cw.Error()
+	}
+
+	itemType := list.Type().Elem()
+	for itemType.Kind() == reflect.Ptr {
+		itemType = itemType.Elem()
+	}
+
+	var (
+		header []string
+		row    func(item reflect.Value) []string
+	)
+	switch itemType.Kind() {
+	case reflect.Struct:
+		columns := csvColumns(itemType)
+		for _, column := range columns {
+			header = append(header, column.name)
+		}
+		row = func(item reflect.Value) []string {
+			for item.Kind() == reflect.Ptr && !item.IsNil() {
+				item = item.Elem()
+			}
+			record := make([]string, len(columns))
+			if item.Kind() != reflect.Struct {
+				return record
+			}
+			for i, column := range columns {
+				record[i] = csvValue(fieldByIndex(item, column.index))
+			}
+			return record
+		}
+	case reflect.Map:
+		if list.Len() > 0 {
+			for _, k := range list.Index(0).MapKeys() {
+				header = append(header, fmt.Sprint(k.Interface()))
+			}
+			sort.Strings(header)
+		}
+		row = func(item reflect.Value) []string {
+			record := make([]string, len(header))
+			for i, k := range header {
+				record[i] = csvValue(item.MapIndex(reflect.ValueOf(k).Convert(itemType.Key())))
+			}
+			return record
+		}
+	default:
+		header = []string{"value"}
+		row = func(item reflect.Value) []string {
+			return []string{csvValue(item)}
+		}
+	}
+
+	names := make([]string, len(header))
+	for i, name := range header {
+		names[i] = csvEscape(name)
+	}
+	if err := cw.Write(names); err != nil {
+		return err
+	}
+	for i := 0; i < list.Len(); i++ {
+		if err := cw.Write(row(list.Index(i))); err != nil {
+			return err
+		}
+		if (i+1)%csvFlushRows == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
@@ -4,9 +4,10 @@ import (
 	"context"
 
 	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/common"
 )
 
-// FromGinRequestContext makes a new context from Gin request context, copy x-request-id to if any
+// FromGinRequestContext makes a new context from Gin request context, copy x-request-id, x-user-id & x-tenant-id to if any
 // use request context instead of gin context to handle user cancelling
 func FromGinRequestContext(c *gin.Context) context.Context {
 	ctx := c.Request.Context()
@@ -15,5 +16,19 @@ func FromGinRequestContext(c *gin.Context) context.Context {
 	} else if requestID = c.GetHeader("x-request-id"); requestID != "" {
 		ctx = context.WithValue(ctx, "x-request-id", requestID)
 	}
+
+	if userID := c.GetString(common.HeaderUserID); userID != "" {
+		ctx = context.WithValue(ctx, common.HeaderUserID, userID)
+	} else if userID = c.GetHeader(common.HeaderUserID); userID != "" {
+		ctx = context.WithValue(ctx, common.HeaderUserID, userID)
+	}
+
+	// x-tenant-id is set as uint64 by AuthRequiredMiddleware
+	if tenantID, ok := c.Get(common.HeaderTenantID); ok {
+		ctx = context.WithValue(ctx, common.HeaderTenantID, tenantID)
+	} else if tenantID := Uint64TenantID(c); tenantID != 0 {
+		ctx = context.WithValue(ctx, common.HeaderTenantID, tenantID)
+	}
+
 	return ctx
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/common"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,3 +27,40 @@ func TestContextExtractWithRequestID(t *testing.T) {
 		assert.Equal(t, "test-request-2", ctx.Value("x-request-id").(string))
 	})
 }
+
+func TestContextExtractWithTenantID(t *testing.T) {
+	t.Run("TenantIDFromValue", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/", nil)
+		c.Set(common.HeaderTenantID, uint64(7))
+
+		ctx := FromGinRequestContext(c)
+		assert.Equal(t, uint64(7), ctx.Value(common.HeaderTenantID))
+	})
+
+	t.Run("TenantIDFromHeader", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/", nil)
+		c.Request.Header.Set(common.HeaderTenantID, "8")
+
+		ctx := FromGinRequestContext(c)
+		assert.Equal(t, uint64(8), ctx.Value(common.HeaderTenantID))
+	})
+
+	t.Run("NoTenantID", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/", nil)
+
+		ctx := FromGinRequestContext(c)
+		assert.Nil(t, ctx.Value(common.HeaderTenantID))
+	})
+}
+
+func TestContextExtractWithUserID(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	c.Request.Header.Set(common.HeaderUserID, "u-1")
+
+	ctx := FromGinRequestContext(c)
+	assert.Equal(t, "u-1", ctx.Value(common.HeaderUserID))
+}
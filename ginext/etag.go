@@ -0,0 +1,154 @@
+package ginext
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPreconditionFailed = NewError(http.StatusPreconditionFailed, "resource has been modified")
+)
+
+// ETagMode tells WrapHandler how to compute the ETag of responses
+type ETagMode int
+
+const (
+	ETagNone ETagMode = iota
+	ETagStrong
+	ETagWeak
+)
+
+// ResourceVersion is the current version of the resource a request works on
+type ResourceVersion struct {
+	ETag         string // opaque version (eg. a counter or a hash), quoted if needed
+	LastModified time.Time
+}
+
+// VersionFunc returns the current version of the requested resource, nil if the resource doesn't exist
+type VersionFunc func(r *Request) (*ResourceVersion, error)
+
+// HandlerOption configures WrapHandler
+type HandlerOption func(o *handlerOptions)
+
+type handlerOptions struct {
+	etag    ETagMode
+	version VersionFunc
+}
+
+// WithETag computes a strong ETag over the encoded body of successful GET responses
+// then answers 304 Not Modified when it matches If-None-Match
+func WithETag() HandlerOption {
+	return func(o *handlerOptions) {
+		o.etag = ETagStrong
+	}
+}
+
+// WithWeakETag is WithETag with weak validators (W/"..."), for representations that are only semantically equivalent
+func WithWeakETag() HandlerOption {
+	return func(o *handlerOptions) {
+		o.etag = ETagWeak
+	}
+}
+
+// WithVersion evaluates the conditional headers against the resource version before the handler runs:
+// GET requests matching If-None-Match / If-Modified-Since get 304 without running the handler,
+// PUT, PATCH & DELETE requests not matching If-Match / If-Unmodified-Since get 412
+func WithVersion(fn VersionFunc) HandlerOption {
+	return func(o *handlerOptions) {
+		o.version = fn
+	}
+}
+
+// FormatETag quotes v as an entity tag, it's kept as is if it's already quoted
+func FormatETag(v string, weak bool) string {
+	if v == "" {
+		return ""
+	}
+	if !strings.HasPrefix(v, `"`) && !strings.HasPrefix(v, `W/"`) {
+		v = `"` + v + `"`
+	}
+	if weak && !strings.HasPrefix(v, "W/") {
+		v = "W/" + v
+	}
+	return v
+}
+
+// computeETag hashes the encoded body
+func computeETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	return FormatETag(hex.EncodeToString(sum[:16]), weak)
+}
+
+// matchETag reports whether etag matches one of the comma separated tags in header,
+// weak comparison ignores the W/ prefix, strong comparison never matches weak tags
+func matchETag(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if tag == etag && !strings.HasPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// notModified evaluates If-None-Match, then If-Modified-Since when If-None-Match is absent (RFC 7232 section 6)
+func notModified(header http.Header, etag string, lastModified time.Time) bool {
+	if inm := header.Get("If-None-Match"); inm != "" {
+		return matchETag(inm, etag, true)
+	}
+	if ims := header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// checkPreconditions evaluates the conditional headers of r against the current version,
+// it returns the status to respond without running the handler, 0 to go on
+func checkPreconditions(r *http.Request, version *ResourceVersion) int {
+	var (
+		etag         string
+		lastModified time.Time
+	)
+	if version != nil {
+		etag, lastModified = FormatETag(version.ETag, false), version.LastModified
+	}
+
+	if isSafeMethod(r.Method) {
+		if version != nil && notModified(r.Header, etag, lastModified) {
+			return http.StatusNotModified
+		}
+		return 0
+	}
+
+	if im := r.Header.Get("If-Match"); im != "" {
+		if version == nil || !matchETag(im, etag, false) && strings.TrimSpace(im) != "*" {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && version != nil && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && lastModified.Truncate(time.Second).After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && version != nil && matchETag(inm, etag, true) {
+		return http.StatusPreconditionFailed
+	}
+	return 0
+}
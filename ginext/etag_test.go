@@ -0,0 +1,125 @@
+package ginext
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETag(t *testing.T) {
+	router := gin.New()
+	router.Use(CreateErrorHandler())
+	router.GET("/strong", WrapHandler(func(r *Request) (*Response, error) {
+		return NewResponseData(http.StatusOK, gin.H{"name": "book"}), nil
+	}, WithETag()))
+	router.GET("/weak", WrapHandler(func(r *Request) (*Response, error) {
+		return NewResponseData(http.StatusOK, gin.H{"name": "book"}), nil
+	}, WithWeakETag()))
+	modified := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	router.GET("/declared", WrapHandler(func(r *Request) (*Response, error) {
+		resp := NewResponseData(http.StatusOK, gin.H{"name": "book"})
+		resp.ETag, resp.LastModified = "v7", modified
+		return resp, nil
+	}))
+
+	do := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("/strong", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.JSONEq(t, `{"data":{"name":"book"}}`, w.Body.String())
+
+	w = do("/strong", map[string]string{"If-None-Match": `"other", ` + etag})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	assert.Equal(t, http.StatusOK, do("/strong", map[string]string{"If-None-Match": `"other"`}).Code)
+
+	w = do("/weak", nil)
+	assert.Equal(t, "W/"+etag, w.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, do("/weak", map[string]string{"If-None-Match": etag}).Code)
+
+	w = do("/declared", nil)
+	assert.Equal(t, `"v7"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Tue, 01 Jun 2021 10:00:00 GMT", w.Header().Get("Last-Modified"))
+	assert.Equal(t, http.StatusNotModified, do("/declared", map[string]string{"If-Modified-Since": "Tue, 01 Jun 2021 10:00:00 GMT"}).Code)
+	assert.Equal(t, http.StatusOK, do("/declared", map[string]string{"If-Modified-Since": "Tue, 01 Jun 2021 09:59:59 GMT"}).Code)
+	assert.Equal(t, http.StatusOK, do("/declared", map[string]string{
+		"If-None-Match":     `"v6"`,
+		"If-Modified-Since": "Tue, 01 Jun 2021 10:00:00 GMT",
+	}).Code, "If-Modified-Since is ignored with If-None-Match")
+}
+
+func TestVersionPreconditions(t *testing.T) {
+	version := 3
+	calls := 0
+	versionFn := func(r *Request) (*ResourceVersion, error) {
+		if r.Param("id") != "1" {
+			return nil, nil
+		}
+		return &ResourceVersion{ETag: strconv.Itoa(version)}, nil
+	}
+	handler := func(r *Request) (*Response, error) {
+		calls++
+		if r.GinCtx.Request.Method == http.MethodPut {
+			version++
+		}
+		resp := NewResponseData(http.StatusOK, gin.H{"version": version})
+		resp.ETag = strconv.Itoa(version)
+		return resp, nil
+	}
+
+	router := gin.New()
+	router.Use(CreateErrorHandler())
+	router.GET("/items/:id", WrapHandler(handler, WithVersion(versionFn)))
+	router.PUT("/items/:id", WrapHandler(handler, WithVersion(versionFn)))
+
+	do := func(method, path string, header map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodGet, "/items/1", map[string]string{"If-None-Match": `"3"`})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+	assert.Equal(t, 0, calls, "the handler doesn't run")
+
+	w = do(http.MethodPut, "/items/1", map[string]string{"If-Match": `"2"`})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, 3, version)
+
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodPut, "/items/1", map[string]string{"If-Match": `W/"3"`}).Code,
+		"If-Match uses strong comparison")
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodPut, "/items/2", map[string]string{"If-Match": "*"}).Code,
+		"the resource doesn't exist")
+
+	w = do(http.MethodPut, "/items/1", map[string]string{"If-Match": `"3"`})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodPut, "/items/1", map[string]string{"If-None-Match": "*"}).Code,
+		"create only")
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, "/items/1", nil).Code, "unconditional requests go through")
+}
@@ -3,9 +3,11 @@ package ginext
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/logger"
 )
 
 type Request struct {
@@ -17,6 +19,11 @@ type Response struct {
 	Code   int
 	Header http.Header
 	*GeneralBody
+
+	ETag         string    // version of the returned resource, quoted by FormatETag; computed with WithETag if empty
+	LastModified time.Time // enables If-Modified-Since on GET
+
+	paginated bool // made by NewResponseWithPager, it can be rendered as csv
 }
 
 // NewResponse makes a new response with empty body
@@ -40,6 +47,7 @@ func NewResponseWithPager(code int, data interface{}, pager *Pager) *Response {
 	return &Response{
 		Code:        code,
 		GeneralBody: NewBodyPaginated(data, pager),
+		paginated:   true,
 	}
 }
 
@@ -64,7 +72,14 @@ func (r *Request) Context() context.Context {
 	return r.ctx
 }
 
-func WrapHandler(handler Handler) gin.HandlerFunc {
+// WrapHandler wraps handler as a gin handler, rendering its response as json or its error via the error handler
+// opts enable ETags (WithETag, WithWeakETag) & conditional requests (WithVersion)
+func WrapHandler(handler Handler, opts ...HandlerOption) gin.HandlerFunc {
+	options := &handlerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	return func(c *gin.Context) {
 		var (
 			err  error
@@ -87,22 +102,96 @@ func WrapHandler(handler Handler) gin.HandlerFunc {
 				}
 			}
 
-			if resp.Data != nil || resp.Error != nil {
-				c.JSON(resp.Code, resp.GeneralBody)
-			} else {
-				c.Status(resp.Code)
-			}
+			writeResponse(c, resp, options)
 		}()
 
+		if negotiate(c.GetHeader("Accept"), requestOffers(c.Request.Method)) == "" {
+			err = ErrNotAcceptable
+			return
+		}
+
 		req := NewRequest(c)
+		var version *ResourceVersion
+		if options.version != nil {
+			if version, err = options.version(req); err != nil {
+				return
+			}
+			switch checkPreconditions(c.Request, version) {
+			case http.StatusNotModified:
+				resp = &Response{Code: http.StatusNotModified, GeneralBody: &GeneralBody{}, ETag: version.ETag, LastModified: version.LastModified}
+				return
+			case http.StatusPreconditionFailed:
+				err = ErrPreconditionFailed
+				return
+			}
+		}
 		resp, err = handler(req)
+
+		// the version of a read resource is its validator, unless the handler tells another one
+		if version != nil && resp != nil && isSafeMethod(c.Request.Method) && resp.ETag == "" && resp.LastModified.IsZero() {
+			resp.ETag, resp.LastModified = version.ETag, version.LastModified
+		}
+	}
+}
+
+// writeResponse renders resp in the media type negotiated with Accept,
+// with its validators & as 304 Not Modified if the client has it already
+func writeResponse(c *gin.Context, resp *Response, options *handlerOptions) {
+	hasBody := resp.Data != nil || resp.Error != nil
+	success := resp.Code >= 200 && resp.Code < 300
+	weak := options.etag == ETagWeak
+	etag := FormatETag(resp.ETag, weak)
+
+	if !hasBody {
+		writeValidators(c, etag, resp.LastModified)
+		c.Status(resp.Code)
+		return
+	}
+
+	mediaType := negotiate(c.GetHeader("Accept"), responseOffers(resp))
+	if mediaType == "" {
+		_ = c.Error(ErrNotAcceptable)
+		return
+	}
+	c.Writer.Header().Add("Vary", "Accept")
+
+	if mediaType == MIMECSV {
+		writeValidators(c, etag, resp.LastModified)
+		if err := writeCSV(c.Writer, resp.Code, resp.Data); err != nil {
+			logger.WithCtx(c, "WrapHandler").WithError(err).Warn("failed to stream csv")
+		}
+		return
+	}
+
+	body, err := encodeBody(mediaType, resp)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if success && etag == "" && options.etag != ETagNone {
+		etag = computeETag(body, weak)
+	}
+	writeValidators(c, etag, resp.LastModified)
+	if success && isSafeMethod(c.Request.Method) && notModified(c.Request.Header, etag, resp.LastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(resp.Code, contentType(mediaType), body)
+}
+
+func writeValidators(c *gin.Context, etag string, lastModified time.Time) {
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
 	}
 }
 
-// MustBind does a binding on v with income request data
+// MustBind does a binding on v with income request data, decoded according to its Content-Type
 // it'll panic if any invalid data (and by design, it should be recovered by error handler middleware)
 func (r *Request) MustBind(v interface{}) {
-	r.MustNoError(r.GinCtx.ShouldBind(v))
+	r.MustNoError(r.Bind(v))
 }
 
 func (r *Request) MustBindUri(v interface{}) {
@@ -0,0 +1,320 @@
+// Package idempotency makes unsafe requests (POST, PUT, PATCH, DELETE) carrying an Idempotency-Key header
+// safe to retry: the first response is stored then replayed to retries of the same request.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/praslar/cloud0/logger"
+)
+
+// Headers
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+	HeaderReplayed       = "Idempotent-Replayed" // set on replayed responses
+)
+
+const maxKeyLength = 255
+
+// Record statuses
+const (
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+)
+
+var (
+	// ErrInFlight is returned when the same key is being processed by another request
+	ErrInFlight = ginext.NewError(http.StatusConflict, "a request with the same idempotency key is being processed")
+	// ErrMismatch is returned when the key has been used by a different request
+	ErrMismatch = ginext.NewError(http.StatusUnprocessableEntity, "idempotency key was used with a different request")
+	// ErrInvalidKey is returned when the key is too long
+	ErrInvalidKey = ginext.NewError(http.StatusBadRequest, "invalid idempotency key")
+)
+
+// Record presents a request & its response
+type Record struct {
+	Scope       string // user/tenant the key belongs to
+	Key         string
+	Fingerprint string // hash of method, path, query & body
+	Status      string
+	Code        int
+	Header      http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// Store keeps records
+type Store interface {
+	// Begin saves record as processing if there's no unexpired record of the same scope & key,
+	// otherwise it returns the existing record
+	Begin(ctx context.Context, record *Record) (existing *Record, err error)
+	// Complete saves the response of a processing record
+	Complete(ctx context.Context, record *Record) error
+	// Delete removes a record, so the request can be retried
+	Delete(ctx context.Context, scope, key string) error
+}
+
+// Config presents configuration of the middleware
+type Config struct {
+	TTL               time.Duration `env:"IDEMPOTENCY_TTL" envDefault:"24h"`               // how long responses are replayed
+	ProcessingTimeout time.Duration `env:"IDEMPOTENCY_PROCESSING_TIMEOUT" envDefault:"1m"` // a processing record older than this is considered abandoned
+}
+
+// NewConfig returns a config filled with default values
+func NewConfig() *Config {
+	return &Config{TTL: 24 * time.Hour, ProcessingTimeout: time.Minute}
+}
+
+// Middleware makes unsafe requests with Idempotency-Key idempotent, it should be used after
+// ginext.CreateErrorHandler & the authentication middleware as keys are scoped by user & tenant.
+// Successful & client error responses (including ApiErrors < 500 rendered by the error handler) are stored,
+// requests failing with a server error can be retried, a nil config means default config
+//
+//	router.POST("/orders", ginext.AuthRequiredMiddleware, idempotency.Middleware(idempotency.NewDBStore(nil), nil), createOrder)
+func Middleware(store Store, config *Config) gin.HandlerFunc {
+	if config == nil {
+		config = NewConfig()
+	}
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderIdempotencyKey)
+		if key == "" || !isUnsafe(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			abort(c, ErrInvalidKey)
+			return
+		}
+
+		ctx := c.Request.Context()
+		l := logger.WithCtx(ginext.FromGinRequestContext(c), "idempotency")
+
+		fingerprint, err := fingerprint(c.Request)
+		if err != nil {
+			abort(c, err)
+			return
+		}
+
+		record := &Record{
+			Scope:       scope(c),
+			Key:         key,
+			Fingerprint: fingerprint,
+			Status:      StatusProcessing,
+			ExpiresAt:   time.Now().Add(config.ProcessingTimeout),
+		}
+		existing, err := store.Begin(ctx, record)
+		if err != nil {
+			abort(c, err)
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				abort(c, ErrMismatch)
+			case existing.Status != StatusCompleted:
+				abort(c, ErrInFlight)
+			default:
+				replay(c, existing)
+			}
+			return
+		}
+
+		w := &recorder{ResponseWriter: c.Writer}
+		c.Writer = w
+		completed := false
+		defer func() {
+			c.Writer = w.ResponseWriter
+			if completed {
+				return
+			}
+			// the handler failed (or panicked), let the client retry
+			if err := store.Delete(context.Background(), record.Scope, record.Key); err != nil {
+				l.WithError(err).Error("failed to release idempotency key")
+			}
+		}()
+
+		// client errors are rendered by the error handler once we return, they're stored as it renders them
+		var panicked interface{}
+		func() {
+			defer func() {
+				panicked = recover()
+			}()
+			c.Next()
+		}()
+		defer func() {
+			if panicked != nil {
+				panic(panicked)
+			}
+		}()
+
+		code, header, body, ok := response(c, w, panicked)
+		if !ok {
+			return
+		}
+		record.Status = StatusCompleted
+		record.Code = code
+		record.Header = header
+		record.Body = body
+		record.ExpiresAt = time.Now().Add(config.TTL)
+		if err := store.Complete(context.Background(), record); err != nil {
+			l.WithError(err).Error("failed to store idempotent response")
+			return
+		}
+		completed = true
+	}
+}
+
+// response returns the response to store: the written one or the one the error handler renders for
+// a client error (ApiError < 500), ok is false for server errors
+func response(c *gin.Context, w *recorder, panicked interface{}) (code int, header http.Header, body []byte, ok bool) {
+	var err error
+	if panicked != nil {
+		if err, ok = panicked.(error); !ok {
+			return 0, nil, nil, false
+		}
+	} else if len(c.Errors) > 0 {
+		err = c.Errors.Last().Err
+	}
+
+	if err == nil {
+		if w.Status() >= http.StatusInternalServerError {
+			return 0, nil, nil, false
+		}
+		return w.Status(), responseHeader(w.Header()), w.body.Bytes(), true
+	}
+
+	apiErr, ok := err.(ginext.ApiError)
+	if !ok || apiErr.Code() >= http.StatusInternalServerError || w.Written() {
+		return 0, nil, nil, false
+	}
+	if body, err = json.Marshal(&ginext.GeneralBody{Error: err}); err != nil {
+		return 0, nil, nil, false
+	}
+	header = responseHeader(w.Header())
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	return apiErr.Code(), header, body, true
+}
+
+func isUnsafe(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// scope returns tenant & user of the request, keys of different users never collide
+func scope(c *gin.Context) string {
+	tenantID := ginext.Uint64TenantID(c)
+	if v, ok := c.Get(common.HeaderTenantID); ok {
+		tenantID, _ = v.(uint64)
+	}
+	userID := c.GetString(common.HeaderUserID)
+	if userID == "" {
+		userID = c.GetHeader(common.HeaderUserID)
+	}
+	return strconv.FormatUint(tenantID, 10) + ":" + userID
+}
+
+// fingerprint hashes method, path, query & body, the body is restored to be read by the handler
+func fingerprint(req *http.Request) (string, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return "", err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	h := sha256.New()
+	_, _ = io.WriteString(h, req.Method+" "+req.URL.RequestURI()+"\n")
+	_, _ = h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// responseHeader returns headers to be replayed, per request headers (request ID, rate limit) are excluded.
+// The body is recorded before it's compressed so compression headers are left to the replay too.
+func responseHeader(header http.Header) http.Header {
+	replayed := http.Header{}
+	for k, v := range header {
+		k = http.CanonicalHeaderKey(k)
+		switch {
+		case strings.EqualFold(k, common.HeaderXRequestID) || strings.HasPrefix(k, "Ratelimit-"),
+			k == "Content-Encoding" || k == "Content-Length":
+			continue
+		case k == "Vary":
+			v = withoutAcceptEncoding(v)
+			if len(v) == 0 {
+				continue
+			}
+		}
+		replayed[k] = append([]string(nil), v...)
+	}
+	return replayed
+}
+
+// withoutAcceptEncoding drops Accept-Encoding from Vary values
+func withoutAcceptEncoding(values []string) []string {
+	var kept []string
+	for _, value := range values {
+		var fields []string
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" && !strings.EqualFold(field, "Accept-Encoding") {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) > 0 {
+			kept = append(kept, strings.Join(fields, ", "))
+		}
+	}
+	return kept
+}
+
+// replay writes the stored response
+func replay(c *gin.Context, record *Record) {
+	for k, values := range record.Header {
+		c.Writer.Header().Del(k)
+		for _, v := range values {
+			c.Writer.Header().Add(k, v)
+		}
+	}
+	c.Header(HeaderReplayed, "true")
+	c.Status(record.Code)
+	_, _ = c.Writer.Write(record.Body)
+	c.Abort()
+}
+
+// recorder keeps a copy of the response body
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
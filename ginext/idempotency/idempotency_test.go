@@ -0,0 +1,226 @@
+package idempotency
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/db/dbtest"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/praslar/cloud0/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init("idempotency.test")
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+type testServer struct {
+	router  *gin.Engine
+	orders  int32
+	block   chan struct{}
+	started chan struct{}
+}
+
+func newTestServer(store Store) *testServer {
+	s := &testServer{router: gin.New()}
+	s.router.Use(ginext.CreateErrorHandler(), Middleware(store, nil))
+	s.router.POST("/orders", ginext.WrapHandler(func(r *ginext.Request) (*ginext.Response, error) {
+		if s.block != nil {
+			close(s.started)
+			<-s.block
+		}
+		var req struct {
+			Item string `json:"item" validate:"required"`
+		}
+		r.MustBind(&req)
+		id := atomic.AddInt32(&s.orders, 1)
+		switch req.Item {
+		case "broken":
+			return nil, ginext.NewError(http.StatusInternalServerError, "out of stock")
+		case "taken":
+			return nil, ginext.NewError(http.StatusConflict, "already ordered")
+		}
+		resp := ginext.NewResponseData(http.StatusCreated, map[string]interface{}{"id": id, "item": req.Item})
+		resp.Header = http.Header{"Location": []string{"/orders/" + req.Item}}
+		return resp, nil
+	}))
+	return s
+}
+
+func (s *testServer) do(key, user, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(common.HeaderUserID, user)
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestReplay(t *testing.T) {
+	s := newTestServer(NewDBStore(dbtest.New(t, &IdempotencyRecord{})))
+
+	first := s.do("key-1", "alice", `{"item":"book"}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+
+	retry := s.do("key-1", "alice", `{"item":"book"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.JSONEq(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "/orders/book", retry.Header().Get("Location"))
+	assert.Equal(t, "true", retry.Header().Get(HeaderReplayed))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&s.orders), "the handler runs once")
+
+	t.Run("ScopedByUser", func(t *testing.T) {
+		w := s.do("key-1", "bob", `{"item":"book"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Get(HeaderReplayed))
+	})
+
+	t.Run("DifferentBody", func(t *testing.T) {
+		w := s.do("key-1", "alice", `{"item":"pen"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("WithoutKey", func(t *testing.T) {
+		before := atomic.LoadInt32(&s.orders)
+		s.do("", "alice", `{"item":"book"}`)
+		s.do("", "alice", `{"item":"book"}`)
+		assert.Equal(t, before+2, atomic.LoadInt32(&s.orders))
+	})
+
+	t.Run("TooLongKey", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, s.do(strings.Repeat("k", 300), "alice", `{"item":"book"}`).Code)
+	})
+}
+
+func TestReplayCompressed(t *testing.T) {
+	item := strings.Repeat("book", 500)
+	router := gin.New()
+	router.Use(ginext.CreateErrorHandler(), ginext.CompressMiddleware(ginext.NewCompressConfig()),
+		Middleware(NewDBStore(dbtest.New(t, &IdempotencyRecord{})), nil))
+	router.POST("/orders", func(c *gin.Context) {
+		c.Header("Vary", "Origin")
+		c.JSON(http.StatusCreated, gin.H{"item": item})
+	})
+
+	do := func(acceptEncoding string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{}`))
+		req.Header.Set(HeaderIdempotencyKey, "key-1")
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	gunzip := func(w *httptest.ResponseRecorder) string {
+		zr, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	first := do("gzip")
+	require.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, ginext.EncodingGzip, first.Header().Get("Content-Encoding"))
+	expected := gunzip(first)
+
+	replayed := do("gzip")
+	assert.Equal(t, "true", replayed.Header().Get(HeaderReplayed))
+	assert.Equal(t, ginext.EncodingGzip, replayed.Header().Get("Content-Encoding"))
+	assert.Equal(t, []string{"Origin", "Accept-Encoding"}, replayed.Header().Values("Vary"))
+	assert.JSONEq(t, expected, gunzip(replayed))
+
+	plain := do("")
+	assert.Equal(t, "true", plain.Header().Get(HeaderReplayed))
+	assert.Empty(t, plain.Header().Get("Content-Encoding"))
+	assert.JSONEq(t, expected, plain.Body.String())
+}
+
+func TestFailedRequestCanBeRetried(t *testing.T) {
+	gormDB := dbtest.New(t, &IdempotencyRecord{})
+	s := newTestServer(NewDBStore(gormDB))
+
+	assert.Equal(t, http.StatusInternalServerError, s.do("key-2", "alice", `{"item":"broken"}`).Code)
+
+	var count int64
+	gormDB.Model(&IdempotencyRecord{}).Count(&count)
+	assert.Equal(t, int64(0), count, "server errors release their keys")
+
+	assert.Equal(t, http.StatusCreated, s.do("key-2", "alice", `{"item":"broken-no-more"}`).Code)
+}
+
+func TestClientErrorIsReplayed(t *testing.T) {
+	s := newTestServer(NewDBStore(dbtest.New(t, &IdempotencyRecord{})))
+
+	// returned by the handler
+	first := s.do("key-3", "alice", `{"item":"taken"}`)
+	require.Equal(t, http.StatusConflict, first.Code)
+	retry := s.do("key-3", "alice", `{"item":"taken"}`)
+	assert.Equal(t, http.StatusConflict, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(HeaderReplayed))
+	assert.JSONEq(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&s.orders), "the handler runs once")
+
+	// panicked by MustBind
+	first = s.do("key-4", "alice", `{}`)
+	require.Equal(t, http.StatusBadRequest, first.Code)
+	retry = s.do("key-4", "alice", `{}`)
+	assert.Equal(t, http.StatusBadRequest, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(HeaderReplayed))
+	assert.JSONEq(t, first.Body.String(), retry.Body.String())
+}
+
+func TestInFlight(t *testing.T) {
+	s := newTestServer(NewDBStore(dbtest.New(t, &IdempotencyRecord{})))
+	s.block, s.started = make(chan struct{}), make(chan struct{})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- s.do("key-4", "alice", `{"item":"book"}`) }()
+	<-s.started
+
+	assert.Equal(t, http.StatusConflict, s.do("key-4", "alice", `{"item":"book"}`).Code)
+	close(s.block)
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+}
+
+func TestDBStoreExpiration(t *testing.T) {
+	gormDB := dbtest.New(t, &IdempotencyRecord{})
+	store := NewDBStore(gormDB)
+	ctx := context.Background()
+
+	record := &Record{Scope: "0:alice", Key: "k", Fingerprint: "f1", Status: StatusProcessing, ExpiresAt: time.Now().Add(-time.Second)}
+	existing, err := store.Begin(ctx, record)
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+
+	// the abandoned record is replaced
+	record2 := &Record{Scope: "0:alice", Key: "k", Fingerprint: "f2", Status: StatusProcessing, ExpiresAt: time.Now().Add(time.Minute)}
+	existing, err = store.Begin(ctx, record2)
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+
+	existing, err = store.Begin(ctx, record)
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Equal(t, "f2", existing.Fingerprint)
+
+	require.NoError(t, gormDB.Model(&IdempotencyRecord{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Second)).Error)
+	n, err := store.Cleanup(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
@@ -0,0 +1,189 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/praslar/cloud0/db"
+	"github.com/praslar/cloud0/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Header is a http.Header stored as a json text column
+type Header http.Header
+
+// Value implements the driver.Valuer interface.
+func (h Header) Value() (driver.Value, error) {
+	if h == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (h *Header) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return fmt.Errorf("unsupported type %T for idempotency header", value)
+	}
+	return json.Unmarshal(raw, h)
+}
+
+// IdempotencyRecord is the DB model of a record
+type IdempotencyRecord struct {
+	ID          int64  `gorm:"primaryKey;autoIncrement"`
+	Scope       string `gorm:"size:128;not null;uniqueIndex:idx_idempotency_key,priority:1"`
+	Key         string `gorm:"column:idempotency_key;size:255;not null;uniqueIndex:idx_idempotency_key,priority:2"`
+	Fingerprint string `gorm:"size:64;not null"`
+	Status      string `gorm:"size:16;not null"`
+	Code        int    `gorm:"not null;default:0"`
+	Header      Header `gorm:"type:text"`
+	Body        []byte
+	ExpiresAt   time.Time `gorm:"not null;index"`
+	CreatedAt   time.Time
+}
+
+func (r *IdempotencyRecord) toRecord() *Record {
+	return &Record{
+		Scope:       r.Scope,
+		Key:         r.Key,
+		Fingerprint: r.Fingerprint,
+		Status:      r.Status,
+		Code:        r.Code,
+		Header:      http.Header(r.Header),
+		Body:        r.Body,
+		ExpiresAt:   r.ExpiresAt,
+	}
+}
+
+// Migrate creates/updates the idempotency table
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&IdempotencyRecord{})
+}
+
+// DBStore keeps records in the database, it's also a BaseApp runner deleting expired records
+//
+//	store := idempotency.NewDBStore(nil)
+//	app.RegisterRunner(store)
+type DBStore struct {
+	DB              *gorm.DB      // use db.GetDB() if nil
+	CleanupInterval time.Duration // default 1 hour
+}
+
+// NewDBStore makes a new DB store
+func NewDBStore(gormDB *gorm.DB) *DBStore {
+	return &DBStore{DB: gormDB, CleanupInterval: time.Hour}
+}
+
+func (s *DBStore) getDB(ctx context.Context) *gorm.DB {
+	if s.DB != nil {
+		return s.DB.WithContext(ctx)
+	}
+	return db.GetDB().WithContext(ctx)
+}
+
+// Begin implements the Store interface.
+func (s *DBStore) Begin(ctx context.Context, record *Record) (*Record, error) {
+	row := &IdempotencyRecord{
+		Scope:       record.Scope,
+		Key:         record.Key,
+		Fingerprint: record.Fingerprint,
+		Status:      record.Status,
+		ExpiresAt:   record.ExpiresAt,
+	}
+
+	// the 2nd attempt is after removing an expired record
+	for attempt := 0; attempt < 2; attempt++ {
+		res := s.getDB(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(row)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return nil, nil
+		}
+
+		var existing IdempotencyRecord
+		err := s.getDB(ctx).Where("scope = ? AND idempotency_key = ?", record.Scope, record.Key).Take(&existing).Error
+		if err == gorm.ErrRecordNotFound {
+			continue // deleted in the meantime
+		}
+		if err != nil {
+			return nil, err
+		}
+		if existing.ExpiresAt.After(time.Now()) {
+			return existing.toRecord(), nil
+		}
+
+		err = s.getDB(ctx).Where("id = ? AND expires_at <= ?", existing.ID, time.Now()).Delete(&IdempotencyRecord{}).Error
+		if err != nil {
+			return nil, err
+		}
+		row.ID = 0
+	}
+
+	return nil, ErrInFlight
+}
+
+// Complete implements the Store interface.
+func (s *DBStore) Complete(ctx context.Context, record *Record) error {
+	return s.getDB(ctx).Model(&IdempotencyRecord{}).
+		Where("scope = ? AND idempotency_key = ? AND fingerprint = ?", record.Scope, record.Key, record.Fingerprint).
+		Updates(map[string]interface{}{
+			"status":     record.Status,
+			"code":       record.Code,
+			"header":     Header(record.Header),
+			"body":       record.Body,
+			"expires_at": record.ExpiresAt,
+		}).Error
+}
+
+// Delete implements the Store interface.
+func (s *DBStore) Delete(ctx context.Context, scope, key string) error {
+	return s.getDB(ctx).Where("scope = ? AND idempotency_key = ?", scope, key).Delete(&IdempotencyRecord{}).Error
+}
+
+// Cleanup deletes expired records
+func (s *DBStore) Cleanup(ctx context.Context) (int64, error) {
+	res := s.getDB(ctx).Where("expires_at <= ?", time.Now()).Delete(&IdempotencyRecord{})
+	return res.RowsAffected, res.Error
+}
+
+// Run deletes expired records periodically until ctx is done
+func (s *DBStore) Run(ctx context.Context) error {
+	interval := s.CleanupInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if n, err := s.Cleanup(ctx); err != nil {
+				logger.Tag("idempotency.DBStore").WithError(err).Error("failed to delete expired records")
+			} else if n > 0 {
+				logger.Tag("idempotency.DBStore").Infof("deleted %d expired records", n)
+			}
+		}
+	}
+}
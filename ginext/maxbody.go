@@ -0,0 +1,73 @@
+package ginext
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contextKeyMaxBody holds the *maxBytesReader of the request body, so routes can replace the limit
+const contextKeyMaxBody = "max-body"
+
+// MaxBodyMiddleware limits request bodies to limit bytes, reading a larger body fails with 413 (which MustBind
+// passes to the error handler): right away if Content-Length is too large, once the limit is exceeded otherwise.
+// Used on a route after a global one, it replaces the global limit so routes (eg. uploads) can raise it,
+// it must come before the middlewares reading the body then
+//
+//	router.Use(ginext.MaxBodyMiddleware(1 << 20))
+//	router.POST("/files", ginext.MaxBodyMiddleware(100<<20), ginext.WrapHandler(uploadHandler))
+func MaxBodyMiddleware(limit int64) gin.HandlerFunc {
+	errTooLarge := NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", limit))
+
+	return func(c *gin.Context) {
+		if v, ok := c.Get(contextKeyMaxBody); ok {
+			v.(*maxBytesReader).setLimit(limit, errTooLarge)
+			c.Next()
+			return
+		}
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			r := &maxBytesReader{ReadCloser: c.Request.Body, contentLength: c.Request.ContentLength}
+			r.setLimit(limit, errTooLarge)
+			c.Request.Body = r
+			c.Set(contextKeyMaxBody, r)
+		}
+		c.Next()
+	}
+}
+
+// maxBytesReader is http.MaxBytesReader failing with an ApiError
+type maxBytesReader struct {
+	io.ReadCloser
+	contentLength int64
+	limit         int64
+	read          int64
+	exceeded      bool
+	err           error
+}
+
+func (r *maxBytesReader) setLimit(limit int64, err error) {
+	r.limit, r.err = limit, err
+	// Content-Length is known, no need to read the body to tell it's too large
+	r.exceeded = r.read > limit || r.contentLength > limit
+}
+
+func (r *maxBytesReader) Read(p []byte) (int, error) {
+	if r.exceeded {
+		return 0, r.err
+	}
+	remaining := r.limit - r.read
+	// read one more byte than allowed to tell a body of exactly limit bytes from a larger one
+	if int64(len(p)) > remaining+1 {
+		p = p[:remaining+1]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if int64(n) > remaining {
+		r.read += remaining
+		r.exceeded = true
+		return int(remaining), r.err
+	}
+	r.read += int64(n)
+	return n, err
+}
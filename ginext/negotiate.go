@@ -0,0 +1,285 @@
+package ginext
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/golang/protobuf/proto"
+	"github.com/ugorji/go/codec"
+	"gopkg.in/yaml.v3"
+)
+
+// media types WrapHandler can render & MustBind can decode
+const (
+	MIMEJSON     = binding.MIMEJSON
+	MIMEMsgPack  = binding.MIMEMSGPACK2
+	MIMEYAML     = "application/yaml"
+	MIMEProtobuf = binding.MIMEPROTOBUF
+	MIMECSV      = "text/csv"
+)
+
+var (
+	ErrNotAcceptable        = NewError(http.StatusNotAcceptable, "none of the accepted media types can be produced")
+	ErrUnsupportedMediaType = NewError(http.StatusUnsupportedMediaType, "unsupported content type")
+)
+
+// mediaAliases maps the media types we accept to their canonical form
+var mediaAliases = map[string]string{
+	MIMEJSON:                          MIMEJSON,
+	"text/json":                       MIMEJSON,
+	MIMEMsgPack:                       MIMEMsgPack,
+	binding.MIMEMSGPACK:               MIMEMsgPack,
+	MIMEYAML:                          MIMEYAML,
+	binding.MIMEYAML:                  MIMEYAML,
+	"text/yaml":                       MIMEYAML,
+	MIMEProtobuf:                      MIMEProtobuf,
+	"application/protobuf":            MIMEProtobuf,
+	"application/vnd.google.protobuf": MIMEProtobuf,
+	MIMECSV:                           MIMECSV,
+}
+
+var (
+	// baseOffers are the types any response can be rendered as, in order of preference
+	baseOffers = []string{MIMEJSON, MIMEMsgPack, MIMEYAML}
+	// allOffers are the types a handler may produce, in order of preference
+	allOffers = append(baseOffers[:len(baseOffers):len(baseOffers)], MIMEProtobuf, MIMECSV)
+)
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns the media ranges of an Accept header by decreasing quality, q=0 ranges are dropped
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		if canonical, ok := mediaAliases[mediaType]; ok {
+			mediaType = canonical
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+// negotiate picks the offer the client prefers, offers are in server preference order for wildcards,
+// it returns "" if none is acceptable. An empty Accept header accepts anything.
+func negotiate(accept string, offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	for _, r := range parseAccept(accept) {
+		for _, offer := range offers {
+			if r.mediaType == offer || r.mediaType == "*/*" ||
+				strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(r.mediaType, "*")) {
+				return offer
+			}
+		}
+	}
+	return ""
+}
+
+// requestOffers lists the types the response to a method may be rendered as, checked before the handler runs:
+// only the types any response can be rendered as for unsafe methods, so the handler doesn't commit its
+// side effects to end with 406 because it returned something that isn't a proto message or a paginated list
+func requestOffers(method string) []string {
+	if isSafeMethod(method) {
+		return allOffers
+	}
+	return baseOffers
+}
+
+// responseOffers lists the types resp can be rendered as:
+// protobuf for proto messages, csv for paginated lists (NewResponseWithPager)
+func responseOffers(resp *Response) []string {
+	offers := append([]string(nil), baseOffers...)
+	if resp.Error == nil {
+		if _, ok := resp.Data.(proto.Message); ok {
+			offers = append(offers, MIMEProtobuf)
+		}
+		if resp.paginated && isList(resp.Data) {
+			offers = append(offers, MIMECSV)
+		}
+	}
+	return offers
+}
+
+func contentType(mediaType string) string {
+	if mediaType == MIMEProtobuf {
+		return mediaType
+	}
+	return mediaType + "; charset=utf-8"
+}
+
+// encodeBody encodes resp in a buffered media type
+func encodeBody(mediaType string, resp *Response) ([]byte, error) {
+	switch mediaType {
+	case MIMEProtobuf:
+		return proto.Marshal(resp.Data.(proto.Message))
+	case MIMEMsgPack, MIMEYAML:
+		// go through json so every format has the same field names (json tags) & custom marshalers
+		v, err := toGeneric(resp.GeneralBody)
+		if err != nil {
+			return nil, err
+		}
+		if mediaType == MIMEYAML {
+			return yaml.Marshal(v)
+		}
+		var buf bytes.Buffer
+		err = codec.NewEncoder(&buf, msgpackHandle()).Encode(v)
+		return buf.Bytes(), err
+	default:
+		return json.Marshal(resp.GeneralBody)
+	}
+}
+
+// toGeneric converts v to the maps, slices & scalars of its json representation
+func toGeneric(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out interface{}
+	if err = dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return convertNumbers(out), nil
+}
+
+// convertNumbers turns json.Number into int64 when possible to keep integers as integers
+func convertNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = convertNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertNumbers(item)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+func msgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.RawToString = true
+	h.WriteExt = true
+	return h
+}
+
+// genericBinding decodes a body to generic values then binds them as json,
+// so structs are bound by their json tags whatever the content type
+type genericBinding struct {
+	name   string
+	decode func(r io.Reader) (interface{}, error)
+}
+
+func (b genericBinding) Name() string {
+	return b.name
+}
+
+func (b genericBinding) Bind(req *http.Request, obj interface{}) error {
+	v, err := b.decode(req.Body)
+	if err != nil {
+		return NewError(http.StatusBadRequest, "invalid "+b.name+" body: "+err.Error())
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return NewError(http.StatusBadRequest, "invalid "+b.name+" body: "+err.Error())
+	}
+	return binding.JSON.BindBody(data, obj)
+}
+
+var (
+	msgpackBinding = genericBinding{name: "msgpack", decode: func(r io.Reader) (interface{}, error) {
+		var v interface{}
+		err := codec.NewDecoder(r, msgpackHandle()).Decode(&v)
+		return v, err
+	}}
+	yamlBinding = genericBinding{name: "yaml", decode: func(r io.Reader) (interface{}, error) {
+		var v interface{}
+		err := yaml.NewDecoder(r).Decode(&v)
+		if err == io.EOF {
+			err = nil
+		}
+		return v, err
+	}}
+)
+
+// bindingFor picks the binding of a request by its Content-Type
+func bindingFor(c *gin.Context, obj interface{}) (binding.Binding, error) {
+	if c.Request.Method == http.MethodGet {
+		return binding.Form, nil
+	}
+
+	mediaType := strings.ToLower(c.ContentType())
+	if canonical, ok := mediaAliases[mediaType]; ok {
+		mediaType = canonical
+	}
+	switch mediaType {
+	case MIMEJSON:
+		return binding.JSON, nil
+	case binding.MIMEXML, binding.MIMEXML2:
+		return binding.XML, nil
+	case MIMEMsgPack:
+		return msgpackBinding, nil
+	case MIMEYAML:
+		return yamlBinding, nil
+	case MIMEProtobuf:
+		if _, ok := obj.(proto.Message); ok {
+			return binding.ProtoBuf, nil
+		}
+	case binding.MIMEMultipartPOSTForm:
+		return binding.FormMultipart, nil
+	case binding.MIMEPOSTForm, "":
+		return binding.Form, nil
+	}
+	return nil, ErrUnsupportedMediaType
+}
+
+// Bind decodes the request into v according to its Content-Type then validates it
+func (r *Request) Bind(v interface{}) error {
+	b, err := bindingFor(r.GinCtx, v)
+	if err != nil {
+		return err
+	}
+	return r.GinCtx.ShouldBindWith(v, b)
+}
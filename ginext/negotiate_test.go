@@ -0,0 +1,213 @@
+package ginext
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+	"gopkg.in/yaml.v3"
+)
+
+type negotiateBase struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type negotiateItem struct {
+	negotiateBase
+	Name   string            `json:"name" validate:"required"`
+	Tags   []string          `json:"tags,omitempty"`
+	Secret string            `json:"-"`
+	Note   *string           `json:"note"`
+	Attrs  map[string]string `json:"attrs"`
+}
+
+func negotiateRouter() *gin.Engine {
+	created := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	items := []negotiateItem{
+		{negotiateBase: negotiateBase{ID: 1, CreatedAt: created}, Name: "book, vol. 1", Tags: []string{"a", "b"}, Secret: "s"},
+		{negotiateBase: negotiateBase{ID: 2, CreatedAt: created}, Name: "pen", Attrs: map[string]string{"color": "red"}},
+	}
+
+	router := gin.New()
+	router.Use(CreateErrorHandler())
+	router.GET("/items", WrapHandler(func(r *Request) (*Response, error) {
+		return NewResponseWithPager(http.StatusOK, items, &Pager{TotalRows: 2}), nil
+	}))
+	router.GET("/items/1", WrapHandler(func(r *Request) (*Response, error) {
+		return NewResponseData(http.StatusOK, items[0]), nil
+	}))
+	router.GET("/proto", WrapHandler(func(r *Request) (*Response, error) {
+		return NewResponseData(http.StatusOK, &wrappers.StringValue{Value: "hello"}), nil
+	}))
+	router.POST("/items", WrapHandler(func(r *Request) (*Response, error) {
+		item := negotiateItem{}
+		r.MustBind(&item)
+		return NewResponseData(http.StatusCreated, item), nil
+	}))
+	router.POST("/proto", WrapHandler(func(r *Request) (*Response, error) {
+		msg := &wrappers.StringValue{}
+		r.MustBind(msg)
+		return NewResponseData(http.StatusOK, msg), nil
+	}))
+	return router
+}
+
+func doNegotiate(router *gin.Engine, method, path, accept, contentType string, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{MIMEJSON, MIMEMsgPack, MIMEYAML}
+	assert.Equal(t, MIMEJSON, negotiate("", offers))
+	assert.Equal(t, MIMEJSON, negotiate("text/html, */*;q=0.8", offers))
+	assert.Equal(t, MIMEYAML, negotiate("application/json;q=0.5, application/x-yaml", offers))
+	assert.Equal(t, MIMEMsgPack, negotiate("application/x-msgpack", offers))
+	assert.Equal(t, MIMEJSON, negotiate("application/*", offers))
+	assert.Equal(t, "", negotiate("text/csv, application/json;q=0", offers))
+}
+
+func TestRenderFormats(t *testing.T) {
+	router := negotiateRouter()
+
+	w := doNegotiate(router, http.MethodGet, "/items/1", "", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+
+	t.Run("YAML", func(t *testing.T) {
+		w := doNegotiate(router, http.MethodGet, "/items/1", "application/yaml", "", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/yaml; charset=utf-8", w.Header().Get("Content-Type"))
+		body := map[string]map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "book, vol. 1", body["data"]["name"])
+		assert.Equal(t, 1, body["data"]["id"], "integers stay integers")
+		assert.NotContains(t, body["data"], "Secret")
+	})
+
+	t.Run("MsgPack", func(t *testing.T) {
+		w := doNegotiate(router, http.MethodGet, "/items/1", "application/x-msgpack", "", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/msgpack; charset=utf-8", w.Header().Get("Content-Type"))
+		var body map[string]interface{}
+		require.NoError(t, codec.NewDecoderBytes(w.Body.Bytes(), msgpackHandle()).Decode(&body))
+		assert.Equal(t, "book, vol. 1", body["data"].(map[string]interface{})["name"])
+	})
+
+	t.Run("Protobuf", func(t *testing.T) {
+		w := doNegotiate(router, http.MethodGet, "/proto", "application/x-protobuf", "", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, MIMEProtobuf, w.Header().Get("Content-Type"))
+		msg := &wrappers.StringValue{}
+		require.NoError(t, proto.Unmarshal(w.Body.Bytes(), msg))
+		assert.Equal(t, "hello", msg.Value)
+
+		assert.Equal(t, http.StatusNotAcceptable, doNegotiate(router, http.MethodGet, "/items/1", "application/x-protobuf", "", nil).Code,
+			"only proto messages are rendered as protobuf")
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		w := doNegotiate(router, http.MethodGet, "/items", "text/csv", "", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, strings.Join([]string{
+			"id,created_at,name,tags,note,attrs",
+			`1,2021-06-01T10:00:00Z,"book, vol. 1","[""a"",""b""]",,null`,
+			`2,2021-06-01T10:00:00Z,pen,null,,"{""color"":""red""}"`,
+			"",
+		}, "\n"), w.Body.String())
+
+		assert.Equal(t, http.StatusNotAcceptable, doNegotiate(router, http.MethodGet, "/items/1", "text/csv", "", nil).Code,
+			"only lists are rendered as csv")
+	})
+
+	t.Run("CSVInjection", func(t *testing.T) {
+		router := gin.New()
+		router.GET("/rows", WrapHandler(func(r *Request) (*Response, error) {
+			rows := []map[string]interface{}{
+				{"name": "=HYPERLINK(\"http://evil\")", "note": "+1", "tag": "@SUM(A1)", "total": -5},
+				{"name": "-2+3", "note": "\tcmd", "tag": "safe", "total": 3},
+			}
+			return NewResponseWithPager(http.StatusOK, rows, &Pager{TotalRows: 2}), nil
+		}))
+		w := doNegotiate(router, http.MethodGet, "/rows", "text/csv", "", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, strings.Join([]string{
+			"name,note,tag,total",
+			`"'=HYPERLINK(""http://evil"")",'+1,'@SUM(A1),-5`,
+			"'-2+3,'\tcmd,safe,3",
+			"",
+		}, "\n"), w.Body.String())
+	})
+
+	t.Run("NotAcceptable", func(t *testing.T) {
+		w := doNegotiate(router, http.MethodGet, "/items", "text/html", "", nil)
+		assert.Equal(t, http.StatusNotAcceptable, w.Code)
+		assert.Contains(t, w.Body.String(), "none of the accepted media types")
+	})
+
+	t.Run("NotAcceptableBeforeSideEffects", func(t *testing.T) {
+		calls := 0
+		router.POST("/orders", WrapHandler(func(r *Request) (*Response, error) {
+			calls++
+			return NewResponseData(http.StatusCreated, negotiateItem{Name: "order"}), nil
+		}))
+
+		// csv & protobuf aren't checked up front for unsafe methods, the response may not be renderable as such
+		for _, accept := range []string{"text/csv", "application/x-protobuf"} {
+			w := doNegotiate(router, http.MethodPost, "/orders", accept, "", nil)
+			assert.Equal(t, http.StatusNotAcceptable, w.Code, accept)
+		}
+		assert.Zero(t, calls, "the handler doesn't run")
+
+		w := doNegotiate(router, http.MethodPost, "/orders", "text/csv, application/json;q=0.5", "", nil)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestBindByContentType(t *testing.T) {
+	router := negotiateRouter()
+
+	yamlBody := []byte("name: lamp\nid: 7\ntags: [x]\n")
+	w := doNegotiate(router, http.MethodPost, "/items", "", "application/x-yaml", yamlBody)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"data":{"id":7,"created_at":"0001-01-01T00:00:00Z","name":"lamp","tags":["x"],"note":null,"attrs":null}}`, w.Body.String())
+
+	var msgpackBody []byte
+	require.NoError(t, codec.NewEncoderBytes(&msgpackBody, msgpackHandle()).Encode(map[string]interface{}{"name": "lamp", "id": 7}))
+	w = doNegotiate(router, http.MethodPost, "/items", "application/yaml", "application/msgpack", msgpackBody)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "name: lamp")
+
+	protoBody, err := proto.Marshal(&wrappers.StringValue{Value: "hi"})
+	require.NoError(t, err)
+	w = doNegotiate(router, http.MethodPost, "/proto", "", "application/x-protobuf", protoBody)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"data":{"value":"hi"}}`, w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, doNegotiate(router, http.MethodPost, "/items", "", "application/yaml", []byte("id: 1\n")).Code,
+		"decoded bodies are validated")
+	assert.Equal(t, http.StatusBadRequest, doNegotiate(router, http.MethodPost, "/items", "", "application/yaml", []byte("name: [")).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, doNegotiate(router, http.MethodPost, "/items", "", "application/x-protobuf", protoBody).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, doNegotiate(router, http.MethodPost, "/items", "", "text/plain", []byte("lamp")).Code)
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+// MemoryStore counts requests in memory, limits are per instance
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	// token bucket
+	tokens float64
+	last   time.Time
+	// sliding window
+	window    int64
+	prev, cur int64
+
+	expiresAt time.Time
+}
+
+// NewMemoryStore makes a new memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*memoryBucket{}}
+}
+
+// Allow implements the Store interface.
+func (s *MemoryStore) Allow(_ context.Context, key string, policy *Policy, now time.Time) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	window := policy.window()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(policy.Limit), last: now}
+		s.buckets[key] = b
+	}
+
+	switch policy.algorithm() {
+	case TokenBucket:
+		elapsed := now.Sub(b.last)
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		b.tokens = math.Min(float64(policy.Limit), b.tokens+float64(elapsed)*float64(policy.Limit)/float64(window))
+		b.last = now
+		allowed := b.tokens >= 1
+		if allowed {
+			b.tokens--
+		}
+		b.expiresAt = now.Add(window)
+		return tokenBucketResult(policy, b.tokens, allowed), nil
+
+	case SlidingWindow:
+		index, elapsed := windowOf(now, window)
+		switch {
+		case index == b.window+1:
+			b.prev, b.cur = b.cur, 0
+		case index != b.window:
+			b.prev, b.cur = 0, 0
+		}
+		b.window = index
+
+		count := float64(b.prev)*float64(window-elapsed)/float64(window) + float64(b.cur)
+		allowed := count+1 <= float64(policy.Limit)
+		if allowed {
+			b.cur++
+		}
+		b.expiresAt = now.Add(2 * window)
+		return slidingWindowResult(policy, b.prev, b.cur, elapsed, allowed), nil
+	}
+
+	return nil, fmt.Errorf("ratelimit: unknown algorithm %q", policy.Algorithm)
+}
+
+// sweep removes expired buckets from time to time
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.After(b.expiresAt) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package ratelimit provides a rate limiting middleware with token bucket & sliding window algorithms,
+// limits are counted in a Store: in memory for a single instance or Redis for cluster-wide limits.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/praslar/cloud0/logger"
+)
+
+// Algorithms
+const (
+	TokenBucket   = "token_bucket"   // allows bursts up to Limit, refilled by Limit tokens per Window
+	SlidingWindow = "sliding_window" // at most Limit requests in any Window (weighted estimate of 2 fixed windows)
+)
+
+// ErrTooManyRequests is returned (to the error handler) when a request is rate limited
+var ErrTooManyRequests = ginext.NewError(http.StatusTooManyRequests, "too many requests")
+
+// KeyFunc returns the key that requests are counted by
+type KeyFunc func(c *gin.Context) string
+
+// ByIP counts requests by client IP (see ginext.ClientIPMiddleware)
+func ByIP(c *gin.Context) string {
+	return "ip:" + ginext.ClientIP(c)
+}
+
+// ByUser counts requests by x-user-id, anonymous requests are counted by IP
+func ByUser(c *gin.Context) string {
+	userID := c.GetString(common.HeaderUserID)
+	if userID == "" {
+		userID = c.GetHeader(common.HeaderUserID)
+	}
+	if userID == "" {
+		return ByIP(c)
+	}
+	return "user:" + userID
+}
+
+// ByTenant counts requests by x-tenant-id, requests without tenant are counted by IP
+func ByTenant(c *gin.Context) string {
+	tenantID := ginext.Uint64TenantID(c)
+	if v, ok := c.Get(common.HeaderTenantID); ok {
+		tenantID, _ = v.(uint64)
+	}
+	if tenantID == 0 {
+		return ByIP(c)
+	}
+	return "tenant:" + strconv.FormatUint(tenantID, 10)
+}
+
+// ByRoute counts requests by route, shared by all clients
+func ByRoute(c *gin.Context) string {
+	return "route:" + c.Request.Method + " " + c.FullPath()
+}
+
+// Compose counts requests by the combination of keys, eg. Compose(ByTenant, ByRoute)
+func Compose(keys ...KeyFunc) KeyFunc {
+	return func(c *gin.Context) string {
+		parts := make([]string, 0, len(keys))
+		for _, key := range keys {
+			parts = append(parts, key(c))
+		}
+		return strings.Join(parts, "|")
+	}
+}
+
+// Policy presents a rate limit
+type Policy struct {
+	Name      string        // identifies the policy in store keys, required when policies share a store
+	Algorithm string        // TokenBucket (default) or SlidingWindow
+	Limit     int           // requests per Window
+	Window    time.Duration // default 1 minute
+	Key       KeyFunc       // default ByIP
+}
+
+func (p *Policy) window() time.Duration {
+	if p.Window > 0 {
+		return p.Window
+	}
+	return time.Minute
+}
+
+func (p *Policy) algorithm() string {
+	if p.Algorithm == "" {
+		return TokenBucket
+	}
+	return p.Algorithm
+}
+
+// Result presents the state of a key after a request
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the quota is fully restored (token bucket) or the window ends (sliding window)
+	RetryAfter time.Duration // when the request isn't allowed
+}
+
+// Store counts requests
+type Store interface {
+	// Allow counts a request of key under policy at now then returns the result
+	Allow(ctx context.Context, key string, policy *Policy, now time.Time) (*Result, error)
+}
+
+// Limiter limits requests with a default policy & per-route policies
+//
+//	limiter := ratelimit.New(ratelimit.NewMemoryStore(), &ratelimit.Policy{Limit: 100, Window: time.Minute, Key: ratelimit.ByUser})
+//	limiter.Route(http.MethodPost, "/api/v1/reports", &ratelimit.Policy{Name: "reports", Limit: 5, Window: time.Hour, Key: ratelimit.ByTenant})
+//	router.Use(limiter.Middleware())
+type Limiter struct {
+	Store Store
+
+	mu            sync.RWMutex
+	defaultPolicy *Policy
+	routes        map[string]*Policy
+}
+
+// New makes a new limiter, a nil default policy means only routes with a policy are limited
+func New(store Store, defaultPolicy *Policy) *Limiter {
+	return &Limiter{Store: store, defaultPolicy: defaultPolicy, routes: map[string]*Policy{}}
+}
+
+// Route sets the policy of a route (gin full path, eg. /users/:id), a nil policy disables limiting on the route
+func (l *Limiter) Route(method, path string, policy *Policy) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.routes[method+" "+path] = policy
+	return l
+}
+
+// SetDefault replaces the default policy, it's safe to be called while serving requests (eg. on config reload)
+func (l *Limiter) SetDefault(policy *Policy) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.defaultPolicy = policy
+}
+
+func (l *Limiter) policy(c *gin.Context) *Policy {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if p, ok := l.routes[c.Request.Method+" "+c.FullPath()]; ok {
+		return p
+	}
+	return l.defaultPolicy
+}
+
+// Middleware limits requests, it sets RateLimit-* headers and aborts with ErrTooManyRequests & Retry-After
+// when the limit is exceeded, it should be used after ginext.CreateErrorHandler.
+// Requests are allowed when the store fails
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := l.policy(c)
+		if policy == nil || policy.Limit <= 0 {
+			c.Next()
+			return
+		}
+
+		keyFn := policy.Key
+		if keyFn == nil {
+			keyFn = ByIP
+		}
+		key := fmt.Sprintf("%s:%s:%s", policy.algorithm(), policy.Name, keyFn(c))
+
+		result, err := l.Store.Allow(c.Request.Context(), key, policy, time.Now())
+		if err != nil {
+			logger.WithCtx(ginext.FromGinRequestContext(c), "ratelimit").WithError(err).Error("failed to check rate limit")
+			c.Next()
+			return
+		}
+
+		h := c.Writer.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, seconds(policy.window())))
+		if !result.Allowed {
+			h.Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			_ = c.Error(ErrTooManyRequests)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Middleware limits requests with a single policy, handy for a route or a group
+//
+//	router.POST("/login", ratelimit.Middleware(store, &ratelimit.Policy{Name: "login", Limit: 5, Window: time.Minute}), login)
+func Middleware(store Store, policy *Policy) gin.HandlerFunc {
+	return New(store, policy).Middleware()
+}
+
+// seconds rounds d up to seconds, at least 1 for a positive duration
+func seconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// tokenBucketResult computes the result from the tokens left after the request
+func tokenBucketResult(p *Policy, tokens float64, allowed bool) *Result {
+	perToken := float64(p.window()) / float64(p.Limit)
+	r := &Result{
+		Allowed:   allowed,
+		Limit:     p.Limit,
+		Remaining: int(tokens),
+		Reset:     time.Duration((float64(p.Limit) - tokens) * perToken),
+	}
+	if !allowed {
+		r.RetryAfter = time.Duration((1 - tokens) * perToken)
+	}
+	return r
+}
+
+// slidingWindowResult computes the result from counters of the previous & current windows after the request,
+// elapsed is the time since the current window started
+func slidingWindowResult(p *Policy, prev, cur int64, elapsed time.Duration, allowed bool) *Result {
+	w := p.window()
+	count := float64(prev)*float64(w-elapsed)/float64(w) + float64(cur)
+	r := &Result{
+		Allowed:   allowed,
+		Limit:     p.Limit,
+		Remaining: p.Limit - int(math.Ceil(count)),
+		Reset:     w - elapsed,
+	}
+	if r.Remaining < 0 {
+		r.Remaining = 0
+	}
+
+	if !allowed {
+		r.RetryAfter = w - elapsed
+		if prev > 0 && cur < int64(p.Limit) {
+			// the estimate drops under the limit once enough of the previous window has slid out
+			need := 1 - float64(int64(p.Limit)-cur-1)/float64(prev)
+			if t := time.Duration(need*float64(w)) - elapsed; t < r.RetryAfter {
+				r.RetryAfter = t
+			}
+		}
+	}
+	return r
+}
+
+// windowOf returns the index of the fixed window containing now & the time elapsed in it
+func windowOf(now time.Time, window time.Duration) (int64, time.Duration) {
+	ns := now.UnixNano()
+	return ns / int64(window), time.Duration(ns % int64(window))
+}
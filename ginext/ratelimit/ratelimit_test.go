@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/praslar/cloud0/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init("ratelimit.test")
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// stores returns the stores to test, Redis runs against a local stand-in
+func stores(t *testing.T) map[string]Store {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return map[string]Store{
+		"Memory": NewMemoryStore(),
+		"Redis":  NewRedisStore(client),
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	policy := &Policy{Limit: 3, Window: 3 * time.Second}
+	start := time.Unix(1000, 0)
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for i := 0; i < 3; i++ {
+				r, err := store.Allow(ctx, "k", policy, start)
+				require.NoError(t, err)
+				assert.True(t, r.Allowed, "burst up to the limit")
+				assert.Equal(t, 2-i, r.Remaining)
+			}
+
+			r, err := store.Allow(ctx, "k", policy, start)
+			require.NoError(t, err)
+			assert.False(t, r.Allowed)
+			assert.Equal(t, 0, r.Remaining)
+			assert.Equal(t, time.Second, r.RetryAfter)
+			assert.Equal(t, 3*time.Second, r.Reset)
+
+			// a token per second
+			r, err = store.Allow(ctx, "k", policy, start.Add(time.Second))
+			require.NoError(t, err)
+			assert.True(t, r.Allowed)
+			r, err = store.Allow(ctx, "k", policy, start.Add(time.Second))
+			require.NoError(t, err)
+			assert.False(t, r.Allowed)
+
+			r, err = store.Allow(ctx, "other", policy, start)
+			require.NoError(t, err)
+			assert.True(t, r.Allowed, "keys are independent")
+		})
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	policy := &Policy{Algorithm: SlidingWindow, Limit: 4, Window: 10 * time.Second}
+	start := time.Unix(1000, 0) // start of a window
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for i := 0; i < 4; i++ {
+				r, err := store.Allow(ctx, "k", policy, start.Add(5*time.Second))
+				require.NoError(t, err)
+				assert.True(t, r.Allowed)
+				assert.Equal(t, 3-i, r.Remaining)
+			}
+
+			r, err := store.Allow(ctx, "k", policy, start.Add(5*time.Second))
+			require.NoError(t, err)
+			assert.False(t, r.Allowed)
+			assert.Equal(t, 5*time.Second, r.RetryAfter)
+
+			// half of the previous window is still counted: 4 * 0.5 = 2
+			next := start.Add(15 * time.Second)
+			for i := 0; i < 2; i++ {
+				r, err = store.Allow(ctx, "k", policy, next)
+				require.NoError(t, err)
+				assert.True(t, r.Allowed)
+			}
+			r, err = store.Allow(ctx, "k", policy, next)
+			require.NoError(t, err)
+			assert.False(t, r.Allowed)
+			assert.Equal(t, 0, r.Remaining)
+			assert.Equal(t, 2500*time.Millisecond, r.RetryAfter)
+
+			r, err = store.Allow(ctx, "k", policy, next.Add(r.RetryAfter))
+			require.NoError(t, err)
+			assert.True(t, r.Allowed)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	store := NewMemoryStore()
+	limiter := New(store, &Policy{Limit: 2, Window: time.Minute, Key: ByUser})
+	limiter.Route(http.MethodPost, "/reports/:id", &Policy{Name: "reports", Limit: 1, Window: time.Hour, Key: Compose(ByTenant, ByRoute)})
+	limiter.Route(http.MethodGet, "/free", nil)
+
+	router := gin.New()
+	router.Use(ginext.CreateErrorHandler(), limiter.Middleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/items", ok)
+	router.GET("/free", ok)
+	router.POST("/reports/:id", ok)
+
+	do := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	alice := map[string]string{common.HeaderUserID: "alice"}
+	w := do(http.MethodGet, "/items", alice)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/items", alice).Code)
+
+	w = do(http.MethodGet, "/items", alice)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	body := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.NotNil(t, body["error"])
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/items", map[string]string{common.HeaderUserID: "bob"}).Code)
+
+	t.Run("PerRoute", func(t *testing.T) {
+		tenant := map[string]string{common.HeaderTenantID: "7"}
+		assert.Equal(t, http.StatusOK, do(http.MethodPost, "/reports/1", tenant).Code)
+		assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/reports/2", tenant).Code, "limited by route pattern")
+		assert.Equal(t, http.StatusOK, do(http.MethodPost, "/reports/1", map[string]string{common.HeaderTenantID: "8"}).Code)
+
+		for i := 0; i < 5; i++ {
+			w := do(http.MethodGet, "/free", alice)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+		}
+	})
+
+	t.Run("SetDefault", func(t *testing.T) {
+		limiter.SetDefault(&Policy{Name: "relaxed", Limit: 100, Window: time.Minute, Key: ByUser})
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/items", alice).Code)
+	})
+}
+
+type failingStore struct{}
+
+func (failingStore) Allow(context.Context, string, *Policy, time.Time) (*Result, error) {
+	return nil, assert.AnError
+}
+
+func TestMiddlewareFailOpen(t *testing.T) {
+	router := gin.New()
+	router.Use(Middleware(failingStore{}, &Policy{Limit: 1}))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
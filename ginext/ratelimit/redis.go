@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript refills then takes a token, it returns {allowed, tokens left}
+var tokenBucketScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = limit
+  ts = now
+end
+tokens = math.min(limit, tokens + math.max(0, now - ts) * limit / window)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], window)
+return {allowed, tostring(tokens)}
+`)
+
+// slidingWindowScript counts the request in the current window if the estimate allows it,
+// it returns {allowed, current count, previous count}
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local elapsed = tonumber(ARGV[3])
+local cur = tonumber(redis.call('GET', KEYS[1]) or '0')
+local prev = tonumber(redis.call('GET', KEYS[2]) or '0')
+local allowed = 0
+if prev * (window - elapsed) / window + cur + 1 <= limit then
+  cur = redis.call('INCR', KEYS[1])
+  redis.call('PEXPIRE', KEYS[1], window * 2)
+  allowed = 1
+end
+return {allowed, cur, prev}
+`)
+
+// RedisStore counts requests in Redis (or any server speaking its protocol with Lua scripting),
+// limits are shared by all instances
+type RedisStore struct {
+	Client redis.Scripter
+	Prefix string // prefix of keys, default "ratelimit:"
+}
+
+// NewRedisStore makes a new Redis store
+//
+//	store := ratelimit.NewRedisStore(redis.NewClient(&redis.Options{Addr: "localhost:6379"}))
+func NewRedisStore(client redis.Scripter) *RedisStore {
+	return &RedisStore{Client: client, Prefix: "ratelimit:"}
+}
+
+// Allow implements the Store interface.
+func (s *RedisStore) Allow(ctx context.Context, key string, policy *Policy, now time.Time) (*Result, error) {
+	window := policy.window()
+	// hash tags keep keys of a limit in the same cluster slot
+	key = s.Prefix + "{" + key + "}"
+
+	switch policy.algorithm() {
+	case TokenBucket:
+		values, err := tokenBucketScript.Run(ctx, s.Client, []string{key},
+			policy.Limit, window.Milliseconds(), now.UnixNano()/int64(time.Millisecond)).Slice()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != 2 {
+			return nil, fmt.Errorf("ratelimit: unexpected reply %v", values)
+		}
+		tokens, err := strconv.ParseFloat(fmt.Sprint(values[1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		return tokenBucketResult(policy, tokens, values[0] == int64(1)), nil
+
+	case SlidingWindow:
+		index, elapsed := windowOf(now, window)
+		keys := []string{key + ":" + strconv.FormatInt(index, 10), key + ":" + strconv.FormatInt(index-1, 10)}
+		values, err := slidingWindowScript.Run(ctx, s.Client, keys,
+			policy.Limit, window.Milliseconds(), elapsed.Milliseconds()).Slice()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != 3 {
+			return nil, fmt.Errorf("ratelimit: unexpected reply %v", values)
+		}
+		cur, _ := values[1].(int64)
+		prev, _ := values[2].(int64)
+		return slidingWindowResult(policy, prev, cur, elapsed, values[0] == int64(1)), nil
+	}
+
+	return nil, fmt.Errorf("ratelimit: unknown algorithm %q", policy.Algorithm)
+}
@@ -28,14 +28,22 @@ func AccessLogMiddleware(env string) gin.HandlerFunc {
 
 		defer func() {
 			latency := time.Since(start).Milliseconds()
-			l = l.
+			l := l.
 				WithField("status", c.Writer.Status()).
 				WithField("method", c.Request.Method).
 				WithField("path", path).
-				WithField("ip", c.ClientIP()).
+				WithField("ip", ClientIP(c)).
 				WithField("latency", latency).
+				WithField("bytes", c.Writer.Size()).
 				WithField("user-agent", c.Request.UserAgent())
 
+			if v, ok := c.Get(ContextKeyStream); ok {
+				stats := v.(*StreamStats)
+				l = l.WithField("stream", stats.Type).
+					WithField("stream-messages", stats.Messages).
+					WithField("stream-duration", stats.Duration.Milliseconds())
+			}
+
 			for _, header := range extractHeaders {
 				if v := c.GetHeader(header); v != "" {
 					l = l.WithField(header, v)
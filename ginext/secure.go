@@ -0,0 +1,67 @@
+package ginext
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SecurityHeadersConfig presents the security headers set on every response, empty values aren't set
+type SecurityHeadersConfig struct {
+	Enabled               bool   `env:"SECURITY_HEADERS_ENABLED" envDefault:"false"`
+	HSTSMaxAge            int    `env:"HSTS_MAX_AGE" envDefault:"31536000"` // in seconds, 0 disables Strict-Transport-Security
+	HSTSIncludeSubdomains bool   `env:"HSTS_INCLUDE_SUBDOMAINS" envDefault:"true"`
+	HSTSPreload           bool   `env:"HSTS_PRELOAD" envDefault:"false"`
+	ContentSecurityPolicy string `env:"CONTENT_SECURITY_POLICY" envDefault:"default-src 'none'; frame-ancestors 'none'"`
+	ContentTypeNosniff    bool   `env:"CONTENT_TYPE_NOSNIFF" envDefault:"true"`
+	FrameOptions          string `env:"FRAME_OPTIONS" envDefault:"DENY"`
+	ReferrerPolicy        string `env:"REFERRER_POLICY" envDefault:"strict-origin-when-cross-origin"`
+}
+
+// NewSecurityHeadersConfig returns a config filled with default values, suited to APIs
+func NewSecurityHeadersConfig() *SecurityHeadersConfig {
+	return &SecurityHeadersConfig{
+		HSTSMaxAge:            31536000,
+		HSTSIncludeSubdomains: true,
+		ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+		ContentTypeNosniff:    true,
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "strict-origin-when-cross-origin",
+	}
+}
+
+// SecurityHeadersMiddleware sets HSTS, CSP, X-Content-Type-Options, X-Frame-Options & Referrer-Policy headers,
+// they're set before the handler runs so error responses get them as well
+func SecurityHeadersMiddleware(config *SecurityHeadersConfig) gin.HandlerFunc {
+	headers := map[string]string{}
+	if config.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.Itoa(config.HSTSMaxAge)
+		if config.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if config.HSTSPreload {
+			hsts += "; preload"
+		}
+		headers["Strict-Transport-Security"] = hsts
+	}
+	if config.ContentSecurityPolicy != "" {
+		headers["Content-Security-Policy"] = config.ContentSecurityPolicy
+	}
+	if config.ContentTypeNosniff {
+		headers["X-Content-Type-Options"] = "nosniff"
+	}
+	if config.FrameOptions != "" {
+		headers["X-Frame-Options"] = config.FrameOptions
+	}
+	if config.ReferrerPolicy != "" {
+		headers["Referrer-Policy"] = config.ReferrerPolicy
+	}
+
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		for k, v := range headers {
+			header.Set(k, v)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,31 @@
+package ginext
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	config := NewSecurityHeadersConfig()
+	config.HSTSPreload = true
+	config.ReferrerPolicy = ""
+
+	router := gin.New()
+	router.Use(CreateErrorHandler(), SecurityHeadersMiddleware(config))
+	router.GET("/fail", WrapHandler(func(r *Request) (*Response, error) {
+		return nil, NewError(http.StatusBadRequest, "bad request")
+	}))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fail", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "max-age=31536000; includeSubDomains; preload", w.Header().Get("Strict-Transport-Security"))
+	assert.Equal(t, "default-src 'none'; frame-ancestors 'none'", w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	assert.Empty(t, w.Header().Get("Referrer-Policy"))
+}
@@ -0,0 +1,298 @@
+package ginext
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/logger"
+)
+
+const (
+	MIMEEventStream = "text/event-stream"
+	MIMENDJSON      = "application/x-ndjson"
+
+	// ContextKeyStream holds the *StreamStats of a streamed response, AccessLogMiddleware logs them
+	ContextKeyStream = "stream"
+
+	HeaderLastEventID = "Last-Event-ID"
+
+	defaultHeartbeat = 15 * time.Second
+)
+
+// StreamStats describes a streamed response once it's done
+type StreamStats struct {
+	Type     string // sse or ndjson
+	Messages int
+	Duration time.Duration
+}
+
+// Stream writes a streamed response, its methods are safe for concurrent use
+type Stream struct {
+	c           *gin.Context
+	ctx         context.Context
+	contentType string
+	preamble    []byte // sent when the stream starts
+	mu          sync.Mutex
+	started     bool
+	stats       StreamStats
+}
+
+func newStream(ctx context.Context, c *gin.Context, streamType, contentType string) *Stream {
+	return &Stream{c: c, ctx: ctx, contentType: contentType, stats: StreamStats{Type: streamType}}
+}
+
+// Context is canceled when the client goes away
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// write sends p then flushes it to the client, the status & headers are sent on the first write
+func (s *Stream) write(p []byte, message bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.start()
+	if _, err := s.c.Writer.Write(p); err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	if message {
+		s.stats.Messages++
+	}
+	return nil
+}
+
+// start commits the response, it must be called with mu held
+func (s *Stream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+
+	header := s.c.Writer.Header()
+	header.Set("Content-Type", s.contentType)
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // nginx buffers responses by default
+	s.c.Status(http.StatusOK)
+	s.c.Writer.WriteHeaderNow()
+	if len(s.preamble) > 0 {
+		_, _ = s.c.Writer.Write(s.preamble)
+	}
+	s.c.Writer.Flush()
+}
+
+// finish handles the error the handler returned, it's rendered by the error handler if nothing was sent yet
+func (s *Stream) finish(err error, start time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Duration = time.Since(start)
+	s.c.Set(ContextKeyStream, &s.stats)
+
+	// errors after the client went away are expected
+	if err == nil || s.ctx.Err() != nil {
+		if !s.started {
+			s.start()
+		}
+		return
+	}
+	if !s.started {
+		_ = s.c.Error(err)
+		return
+	}
+	logger.WithCtx(s.ctx, "Stream").WithError(err).Error("stream aborted")
+}
+
+// Event is a Server-Sent Event, Data is sent as is if it's a string or []byte, as json otherwise
+type Event struct {
+	ID    string
+	Event string
+	Data  interface{}
+	Retry time.Duration
+}
+
+// SSEConfig configures WrapSSEHandler
+type SSEConfig struct {
+	Heartbeat time.Duration // period of the comments sent to keep proxies from closing the stream, default 15s, <0 disables
+	Retry     time.Duration // reconnection delay advised to the client, 0 keeps the browser default
+}
+
+// SSEStream sends Server-Sent Events
+type SSEStream struct {
+	*Stream
+	lastEventID string
+}
+
+// LastEventID is the ID of the last event the client got before reconnecting (Last-Event-ID header
+// or lastEventId query param), "" on the first connection. Handlers resume the stream after it.
+func (s *SSEStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send writes an event
+func (s *SSEStream) Send(e Event) error {
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + sseField(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + sseField(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	var data string
+	switch v := e.Data.(type) {
+	case nil:
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data = string(encoded)
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write([]byte(b.String()), true)
+}
+
+// sseField drops line breaks which would end the field
+func sseField(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
+
+// SSEHandler streams events to the client until it returns or its context is done
+type SSEHandler func(r *Request, s *SSEStream) error
+
+// WrapSSEHandler wraps a SSE handler as a gin handler, heartbeats are sent while the handler runs.
+// An error returned before the first event is rendered by the error handler, later ones are logged.
+func WrapSSEHandler(handler SSEHandler, config *SSEConfig) gin.HandlerFunc {
+	if config == nil {
+		config = &SSEConfig{}
+	}
+	heartbeat := config.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = defaultHeartbeat
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		req := NewRequest(c)
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		req.ctx = ctx
+
+		lastEventID := c.GetHeader(HeaderLastEventID)
+		if lastEventID == "" {
+			lastEventID = c.Query("lastEventId")
+		}
+		s := &SSEStream{Stream: newStream(ctx, c, "sse", MIMEEventStream), lastEventID: lastEventID}
+		if config.Retry > 0 {
+			s.preamble = []byte(fmt.Sprintf("retry: %d\n\n", config.Retry.Milliseconds()))
+		}
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		if heartbeat > 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ticker := time.NewTicker(heartbeat)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-ticker.C:
+						if err := s.write([]byte(": ping\n\n"), false); err != nil {
+							// the client is gone, stop the handler
+							cancel()
+							return
+						}
+					}
+				}
+			}()
+		}
+
+		err := handler(req, s)
+		// no ping must be written once the gin handler has returned
+		close(done)
+		wg.Wait()
+		s.finish(err, start)
+	}
+}
+
+// NDJSONStream sends newline delimited json rows
+type NDJSONStream struct {
+	*Stream
+}
+
+// Write sends a row
+func (s *NDJSONStream) Write(row interface{}) error {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	return s.write(append(data, '\n'), true)
+}
+
+// NDJSONHandler streams rows to the client until it returns or its context is done
+type NDJSONHandler func(r *Request, s *NDJSONStream) error
+
+// WrapNDJSONHandler wraps a NDJSON handler as a gin handler.
+// An error returned before the first row is rendered by the error handler, later ones are logged.
+func WrapNDJSONHandler(handler NDJSONHandler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		req := NewRequest(c)
+		s := &NDJSONStream{Stream: newStream(req.Context(), c, "ndjson", MIMENDJSON)}
+		s.finish(handler(req, s), start)
+	}
+}
+
+// WriteChannel sends the rows of ch until it's closed or the client goes away
+func WriteChannel[T any](s *NDJSONStream, ch <-chan T) error {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case row, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := s.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// WriteIterator sends the rows returned by next until it reports there's no more (false) or fails
+func WriteIterator[T any](s *NDJSONStream, next func(ctx context.Context) (T, bool, error)) error {
+	for {
+		row, ok, err := next(s.ctx)
+		if err != nil || !ok {
+			return err
+		}
+		if err = s.Write(row); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package ginext
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func streamServer(t *testing.T, register func(router *gin.Engine)) (*httptest.Server, chan *StreamStats) {
+	stats := make(chan *StreamStats, 1)
+	router := gin.New()
+	router.Use(RequestIDMiddleware, AccessLogMiddleware("test"), func(c *gin.Context) {
+		c.Next()
+		if v, ok := c.Get(ContextKeyStream); ok {
+			stats <- v.(*StreamStats)
+		}
+	}, CreateErrorHandler())
+	register(router)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, stats
+}
+
+func TestSSE(t *testing.T) {
+	server, stats := streamServer(t, func(router *gin.Engine) {
+		router.GET("/events", WrapSSEHandler(func(r *Request, s *SSEStream) error {
+			from, _ := strconv.Atoi(s.LastEventID())
+			for i := from + 1; i <= 3; i++ {
+				if err := s.Send(Event{ID: strconv.Itoa(i), Event: "tick", Data: map[string]int{"n": i}}); err != nil {
+					return err
+				}
+			}
+			return s.Send(Event{Data: "multi\nline"})
+		}, &SSEConfig{Retry: 3 * time.Second}))
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+	req.Header.Set(HeaderLastEventID, "1")
+	rsp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	assert.Equal(t, MIMEEventStream, rsp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", rsp.Header.Get("Cache-Control"))
+	body := readAll(t, rsp)
+	assert.Equal(t, "retry: 3000\n\n"+
+		"id: 2\nevent: tick\ndata: {\"n\":2}\n\n"+
+		"id: 3\nevent: tick\ndata: {\"n\":3}\n\n"+
+		"data: multi\ndata: line\n\n", body)
+
+	s := <-stats
+	assert.Equal(t, "sse", s.Type)
+	assert.Equal(t, 3, s.Messages)
+}
+
+func TestSSEHeartbeatAndDisconnect(t *testing.T) {
+	handlerDone := make(chan error, 1)
+	server, stats := streamServer(t, func(router *gin.Engine) {
+		router.GET("/events", WrapSSEHandler(func(r *Request, s *SSEStream) error {
+			if err := s.Send(Event{Data: "hello"}); err != nil {
+				return err
+			}
+			<-r.Context().Done()
+			handlerDone <- r.Context().Err()
+			return r.Context().Err()
+		}, &SSEConfig{Heartbeat: 10 * time.Millisecond}))
+	})
+
+	rsp, err := http.Get(server.URL + "/events")
+	require.NoError(t, err)
+	reader := bufio.NewReader(rsp.Body)
+
+	// flushed through the middlewares while the handler is still running
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: hello\n", line)
+	for line != ": ping\n" {
+		line, err = reader.ReadString('\n')
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, rsp.Body.Close())
+	select {
+	case err := <-handlerDone:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the handler context isn't canceled on disconnect")
+	}
+	assert.Equal(t, 1, (<-stats).Messages)
+}
+
+func TestSSEHeartbeatStopsWithHandler(t *testing.T) {
+	router := gin.New()
+	router.GET("/events", WrapSSEHandler(func(r *Request, s *SSEStream) error {
+		time.Sleep(5 * time.Millisecond)
+		return s.Send(Event{Data: "bye"})
+	}, &SSEConfig{Heartbeat: time.Millisecond}))
+
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+		body := w.Body.String()
+		// a late ping would be a data race & change the body
+		time.Sleep(3 * time.Millisecond)
+		assert.Equal(t, body, w.Body.String())
+		assert.Contains(t, body, "data: bye\n\n")
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	server, stats := streamServer(t, func(router *gin.Engine) {
+		router.GET("/channel", WrapNDJSONHandler(func(r *Request, s *NDJSONStream) error {
+			ch := make(chan gin.H)
+			go func() {
+				defer close(ch)
+				for i := 1; i <= 3; i++ {
+					ch <- gin.H{"id": i}
+				}
+			}()
+			return WriteChannel(s, ch)
+		}))
+		router.GET("/iterator", WrapNDJSONHandler(func(r *Request, s *NDJSONStream) error {
+			rows := []string{"a", "b"}
+			return WriteIterator(s, func(ctx context.Context) (string, bool, error) {
+				if len(rows) == 0 {
+					return "", false, nil
+				}
+				row := rows[0]
+				rows = rows[1:]
+				return row, true, nil
+			})
+		}))
+		router.GET("/failed", WrapNDJSONHandler(func(r *Request, s *NDJSONStream) error {
+			return NewError(http.StatusForbidden, "not allowed")
+		}))
+		router.GET("/aborted", WrapNDJSONHandler(func(r *Request, s *NDJSONStream) error {
+			_ = s.Write(1)
+			return errors.New("db is gone")
+		}))
+	})
+
+	rsp, err := http.Get(server.URL + "/channel")
+	require.NoError(t, err)
+	assert.Equal(t, MIMENDJSON, rsp.Header.Get("Content-Type"))
+	assert.Equal(t, "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n", readAll(t, rsp))
+	s := <-stats
+	assert.Equal(t, "ndjson", s.Type)
+	assert.Equal(t, 3, s.Messages)
+
+	rsp, err = http.Get(server.URL + "/iterator")
+	require.NoError(t, err)
+	assert.Equal(t, "\"a\"\n\"b\"\n", readAll(t, rsp))
+	<-stats
+
+	rsp, err = http.Get(server.URL + "/failed")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode, "errors before the first row are rendered by the error handler")
+	assert.Equal(t, "application/json; charset=utf-8", rsp.Header.Get("Content-Type"))
+	assert.Contains(t, readAll(t, rsp), "not allowed")
+	<-stats
+
+	rsp, err = http.Get(server.URL + "/aborted")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "1\n", readAll(t, rsp))
+}
+
+func readAll(t *testing.T, rsp *http.Response) string {
+	defer rsp.Body.Close()
+	var b strings.Builder
+	_, err := bufio.NewReader(rsp.Body).WriteTo(&b)
+	require.NoError(t, err)
+	return b.String()
+}
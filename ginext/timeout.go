@@ -0,0 +1,193 @@
+package ginext
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrGatewayTimeout is returned when a handler overruns its deadline
+var ErrGatewayTimeout = NewError(http.StatusGatewayTimeout, "request timed out")
+
+// TimeoutMiddleware gives the handlers of a route a deadline: the context of FromGinRequestContext is canceled
+// when it's exceeded & the client gets 504 right away, whatever the handler writes later is discarded.
+// Responses are buffered until the handler returns, so it doesn't suit streaming or websocket routes.
+//
+//	router.GET("/reports", ginext.TimeoutMiddleware(5*time.Second), ginext.WrapHandler(reportHandler))
+func TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		w := &timeoutWriter{ResponseWriter: c.Writer, header: http.Header{}, code: http.StatusOK, size: -1}
+		for k, v := range c.Writer.Header() {
+			w.header[k] = append([]string(nil), v...)
+		}
+		c.Writer = w
+		timer := time.AfterFunc(timeout, w.timeout)
+
+		defer func() {
+			timer.Stop()
+			c.Writer = w.ResponseWriter
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.done = true
+
+			if r := recover(); r != nil {
+				if w.timedOut {
+					// 504 has been sent, nothing else can be
+					c.Errors = c.Errors[:0]
+					return
+				}
+				// let the error handler render the panic, the buffered response is dropped
+				panic(r)
+			}
+
+			switch {
+			case w.timedOut:
+				// 504 has been sent, the error handler mustn't write again
+				c.Errors = c.Errors[:0]
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				// the handler gave up on the canceled context before the timer fired
+				c.Errors = c.Errors[:0]
+				_ = c.Error(ErrGatewayTimeout)
+			default:
+				w.flush()
+			}
+		}()
+
+		c.Next()
+	}
+}
+
+// timeoutWriter buffers the response until the handler returns or the deadline is exceeded
+type timeoutWriter struct {
+	gin.ResponseWriter
+
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	code     int
+	size     int
+	timedOut bool
+	done     bool
+}
+
+// timeout sends 504 if the handler is still running
+func (w *timeoutWriter) timeout() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	w.timedOut = true
+
+	// the handler may keep running: Content-Length lets the client read the whole response right away &
+	// the connection isn't reused as it's busy until the handler returns
+	body, _ := json.Marshal(&GeneralBody{Error: ErrGatewayTimeout})
+	header := w.ResponseWriter.Header()
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("Connection", "close")
+	w.ResponseWriter.WriteHeader(http.StatusGatewayTimeout)
+	_, _ = w.ResponseWriter.Write(body)
+	w.ResponseWriter.Flush()
+}
+
+// flush sends the buffered response, it must be called with mu held
+func (w *timeoutWriter) flush() {
+	dst := w.ResponseWriter.Header()
+	for k := range dst {
+		delete(dst, k)
+	}
+	for k, v := range w.header {
+		dst[k] = v
+	}
+	// like gin's writer, the status is only recorded until something is written
+	w.ResponseWriter.WriteHeader(w.code)
+	if w.written() {
+		w.ResponseWriter.WriteHeaderNow()
+		_, _ = w.ResponseWriter.Write(w.body.Bytes())
+	}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if code > 0 && !w.written() {
+		w.code = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.written() {
+		w.size = 0
+	}
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !w.written() {
+		w.size = 0
+	}
+	n, err := w.body.Write(p)
+	w.size += n
+	return n, err
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.code
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written()
+}
+
+func (w *timeoutWriter) written() bool {
+	return w.size != -1
+}
+
+// Flush is a no-op, the response is sent once the handler returns
+func (w *timeoutWriter) Flush() {}
+
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("ginext: hijacking isn't supported with TimeoutMiddleware")
+}
+
+func (w *timeoutWriter) Pusher() http.Pusher {
+	return nil
+}
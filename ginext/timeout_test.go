@@ -0,0 +1,158 @@
+package ginext
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxBodyMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(CreateErrorHandler(), MaxBodyMiddleware(16))
+	router.POST("/items", WrapHandler(func(r *Request) (*Response, error) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		r.MustBind(&req)
+		return NewResponseData(http.StatusOK, req.Name), nil
+	}))
+
+	do := func(body string, chunked bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if chunked {
+			req.ContentLength = -1
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do(`{"name":"abcde"}`, false).Code, "exactly 16 bytes")
+	assert.Equal(t, http.StatusOK, do(`{"name":"abc"}`, true).Code, "chunked")
+
+	w := do(`{"name":"too long for the limit"}`, false)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "larger than 16 bytes")
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do(`{"name":"too long for the limit"}`, true).Code,
+		"bodies without Content-Length fail while being read")
+}
+
+func TestMaxBodyMiddlewareRouteOverride(t *testing.T) {
+	router := gin.New()
+	router.Use(CreateErrorHandler(), MaxBodyMiddleware(8))
+	read := func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		c.String(http.StatusOK, "%d", len(body))
+	}
+	router.POST("/small", read)
+	router.POST("/upload", MaxBodyMiddleware(64), read)
+	router.POST("/tiny", MaxBodyMiddleware(4), read)
+
+	do := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return w
+	}
+
+	body := strings.Repeat("a", 32)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do("/small", body).Code)
+	w := do("/upload", body)
+	assert.Equal(t, http.StatusOK, w.Code, "the route raises the global limit")
+	assert.Equal(t, "32", w.Body.String())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do("/upload", strings.Repeat("a", 65)).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do("/tiny", "abcdef").Code, "or lowers it")
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(CreateErrorHandler())
+	timeout := TimeoutMiddleware(50 * time.Millisecond)
+
+	canceled := make(chan error, 1)
+	router.GET("/fast", timeout, WrapHandler(func(r *Request) (*Response, error) {
+		resp := NewResponseData(http.StatusCreated, "ok")
+		resp.Header = http.Header{"X-Custom": []string{"1"}}
+		return resp, nil
+	}))
+	router.GET("/no-content", timeout, WrapHandler(func(r *Request) (*Response, error) {
+		return NewResponse(http.StatusNoContent), nil
+	}))
+	router.GET("/error", timeout, WrapHandler(func(r *Request) (*Response, error) {
+		return nil, NewError(http.StatusConflict, "conflict")
+	}))
+	router.GET("/cooperative", timeout, WrapHandler(func(r *Request) (*Response, error) {
+		<-r.Context().Done()
+		canceled <- r.Context().Err()
+		return nil, r.Context().Err()
+	}))
+	router.GET("/stubborn", timeout, WrapHandler(func(r *Request) (*Response, error) {
+		time.Sleep(150 * time.Millisecond)
+		return NewResponseData(http.StatusOK, "late"), nil
+	}))
+
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := do("/fast")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-Custom"))
+	assert.JSONEq(t, `{"data":"ok"}`, w.Body.String())
+
+	w = do("/no-content")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = do("/error")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = do("/cooperative")
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), "request timed out")
+	require.Error(t, <-canceled, "the handler context is canceled")
+
+	w = do("/stubborn")
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.NotContains(t, w.Body.String(), "late", "the late response is discarded")
+}
+
+func TestTimeoutMiddlewareServer(t *testing.T) {
+	release := make(chan struct{})
+	router := gin.New()
+	router.Use(CreateErrorHandler())
+	router.GET("/stubborn", TimeoutMiddleware(50*time.Millisecond), WrapHandler(func(r *Request) (*Response, error) {
+		// ignores the context
+		<-release
+		return NewResponseData(http.StatusOK, "late"), nil
+	}))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	defer close(release)
+
+	start := time.Now()
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(server.URL + "/stubborn")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Contains(t, string(body), "request timed out")
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+	assert.Less(t, time.Since(start), time.Second, "the 504 is received before the handler returns")
+}
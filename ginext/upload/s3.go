@@ -0,0 +1,123 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// S3Config presents configuration of a S3 compatible storage
+type S3Config struct {
+	Endpoint       string `env:"S3_ENDPOINT"` // empty for AWS, eg. http://minio:9000 otherwise
+	Region         string `env:"S3_REGION" envDefault:"us-east-1"`
+	Bucket         string `env:"S3_BUCKET"`
+	Prefix         string `env:"S3_PREFIX"` // prepended to keys
+	AccessKey      string `env:"S3_ACCESS_KEY"`
+	SecretKey      string `env:"S3_SECRET_KEY"`
+	ForcePathStyle bool   `env:"S3_FORCE_PATH_STYLE"` // required by most S3 compatible servers
+}
+
+// S3Storage stores files in a S3 (compatible) bucket, files are streamed with multipart uploads
+// so their size doesn't need to be known
+type S3Storage struct {
+	Client   s3iface.S3API
+	Bucket   string
+	Prefix   string
+	uploader *s3manager.Uploader
+}
+
+// NewS3Storage creates a storage from config, credentials fall back to the AWS default chain when not set
+func NewS3Storage(config *S3Config) (*S3Storage, error) {
+	awsConfig := aws.NewConfig().
+		WithRegion(config.Region).
+		WithS3ForcePathStyle(config.ForcePathStyle)
+	if config.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint)
+	}
+	if config.AccessKey != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(config.AccessKey, config.SecretKey, ""))
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewS3StorageWithClient(s3.New(sess), config.Bucket, config.Prefix), nil
+}
+
+// NewS3StorageWithClient creates a storage with an existing client
+func NewS3StorageWithClient(client s3iface.S3API, bucket, prefix string) *S3Storage {
+	return &S3Storage{
+		Client:   client,
+		Bucket:   bucket,
+		Prefix:   prefix,
+		uploader: s3manager.NewUploaderWithClient(client),
+	}
+}
+
+func (s *S3Storage) key(key string) string {
+	if s.Prefix == "" {
+		return key
+	}
+	return path.Join(s.Prefix, key)
+}
+
+// Put uploads r, a failed multipart upload is aborted so nothing is kept
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(s.key(key)),
+		Body:        r,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		// the error of the body is wrapped by the SDK
+		if cause := origErr(err); cause != nil {
+			return cause
+		}
+	}
+	return err
+}
+
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.RequestFailure); ok && e.StatusCode() == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	return err
+}
+
+// origErr returns the innermost error of an aws error
+func origErr(err error) error {
+	var cause error
+	for {
+		e, ok := err.(awserr.Error)
+		if !ok || e.OrigErr() == nil {
+			return cause
+		}
+		cause = e.OrigErr()
+		err = cause
+	}
+}
@@ -0,0 +1,81 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newS3Storage(t *testing.T) *S3Storage {
+	server := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	t.Cleanup(server.Close)
+
+	storage, err := NewS3Storage(&S3Config{
+		Endpoint:       server.URL,
+		Region:         "us-east-1",
+		Bucket:         "uploads",
+		Prefix:         "tenant-1",
+		AccessKey:      "key",
+		SecretKey:      "secret",
+		ForcePathStyle: true,
+	})
+	require.NoError(t, err)
+	_, err = storage.Client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("uploads")})
+	require.NoError(t, err)
+	return storage
+}
+
+func TestS3Storage(t *testing.T) {
+	storage := newS3Storage(t)
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	require.NoError(t, storage.Put(ctx, "a.txt", bytes.NewReader(content), "text/plain"))
+
+	head, err := storage.Client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("uploads"), Key: aws.String("tenant-1/a.txt")})
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", aws.StringValue(head.ContentType))
+
+	r, err := storage.Open(ctx, "a.txt")
+	require.NoError(t, err)
+	got, _ := io.ReadAll(r)
+	_ = r.Close()
+	assert.Equal(t, content, got)
+
+	require.NoError(t, storage.Delete(ctx, "a.txt"))
+	_, err = storage.Open(ctx, "a.txt")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestUploadS3(t *testing.T) {
+	storage := newS3Storage(t)
+	config := NewConfig()
+	config.MaxFileSize = 100
+	router := uploadRouter(New(storage, config))
+
+	body, contentType := multipartBody(t, part{field: "doc", filename: "doc.txt", content: []byte("hello")})
+	w := doUpload(router, body, contentType)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	body, contentType = multipartBody(t,
+		part{field: "doc", filename: "doc.txt", content: []byte("hello")},
+		part{field: "big", filename: "big.txt", content: bytes.Repeat([]byte("a"), 200)},
+	)
+	w = doUpload(router, body, contentType)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "file is larger than 100 bytes")
+
+	objects, err := storage.Client.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("uploads")})
+	require.NoError(t, err)
+	assert.Len(t, objects.Contents, 1, "files of the failed upload are deleted")
+}
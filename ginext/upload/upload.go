@@ -0,0 +1,234 @@
+// Package upload streams multipart/form-data files to a Storage (local filesystem or S3 compatible) without
+// buffering them in memory, it enforces size & count limits, sniffs content types & computes checksums.
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/praslar/cloud0/logger"
+)
+
+// sniffLen is the number of bytes used to detect content types, see http.DetectContentType
+const sniffLen = 512
+
+var (
+	// ErrNotMultipart is returned when the request isn't a multipart/form-data one
+	ErrNotMultipart = ginext.NewError(http.StatusUnsupportedMediaType, "multipart/form-data request expected")
+	// ErrNotFound is returned by storages when a file doesn't exist
+	ErrNotFound = ginext.NewError(http.StatusNotFound, "file not found")
+
+	errTooLarge = errors.New("upload: file too large")
+)
+
+// Storage stores uploaded files
+type Storage interface {
+	// Put stores the content of r as key, nothing must be kept if r fails
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Open returns the content of key, ErrNotFound if it doesn't exist
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key, it's not an error if it doesn't exist
+	Delete(ctx context.Context, key string) error
+}
+
+// File presents a stored file
+type File struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"` // as sent by the client, it shouldn't be trusted
+	Key         string `json:"key"`      // in the storage
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"` // hex encoded
+}
+
+// Result presents an upload request
+type Result struct {
+	Files  []*File
+	Values url.Values // non-file fields
+}
+
+// Config presents configuration of uploads
+type Config struct {
+	MaxFileSize  int64    `env:"UPLOAD_MAX_FILE_SIZE" envDefault:"10485760"` // in bytes
+	MaxFiles     int      `env:"UPLOAD_MAX_FILES" envDefault:"10"`
+	MaxValueSize int64    `env:"UPLOAD_MAX_VALUE_SIZE" envDefault:"65536"` // of non-file fields, in bytes
+	AllowedTypes []string `env:"UPLOAD_ALLOWED_TYPES" envSeparator:","`    // sniffed types allowed, eg. image/*,application/pdf, empty allows any
+}
+
+// NewConfig returns a config filled with default values
+func NewConfig() *Config {
+	return &Config{MaxFileSize: 10 << 20, MaxFiles: 10, MaxValueSize: 64 << 10}
+}
+
+// Uploader stores the files of multipart requests
+type Uploader struct {
+	Storage Storage
+	Config  *Config
+	// Key returns the storage key of a file, the default is a random UUID with the file extension
+	Key func(ctx context.Context, file *File) string
+}
+
+// New creates an uploader, nil config means default values
+func New(storage Storage, config *Config) *Uploader {
+	if config == nil {
+		config = NewConfig()
+	}
+	return &Uploader{Storage: storage, Config: config, Key: defaultKey}
+}
+
+func defaultKey(_ context.Context, file *File) string {
+	return uuid.NewString() + strings.ToLower(filepath.Ext(filepath.Base(file.Filename)))
+}
+
+// Upload streams the files of r to the storage, invalid files are reported as validation errors (400)
+// & no file is kept if any fails
+//
+//	result, err := uploader.Upload(r)
+//	if err != nil {
+//		return nil, err
+//	}
+func (u *Uploader) Upload(r *ginext.Request) (*Result, error) {
+	return u.Parse(r.Context(), r.GinCtx.Request)
+}
+
+// Parse is Upload for a http request
+func (u *Uploader) Parse(ctx context.Context, req *http.Request) (*Result, error) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, ErrNotMultipart
+	}
+
+	result := &Result{Values: url.Values{}}
+	if err = u.parse(ctx, reader, result); err != nil {
+		u.cleanup(ctx, result.Files)
+		return nil, err
+	}
+	return result, nil
+}
+
+// parse reads the parts one by one, file parts are streamed to the storage as they come
+func (u *Uploader) parse(ctx context.Context, reader *multipart.Reader, result *Result) error {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// eg. 413 from ginext.MaxBodyMiddleware
+			var apiErr ginext.ApiError
+			if errors.As(err, &apiErr) {
+				return apiErr.(error)
+			}
+			return ginext.NewError(http.StatusBadRequest, "invalid multipart body: "+err.Error())
+		}
+
+		field := part.FormName()
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, u.Config.MaxValueSize+1))
+			if err != nil {
+				return err
+			}
+			if int64(len(value)) > u.Config.MaxValueSize {
+				return ginext.NewValidationErrors(ginext.NewFieldError(field, fmt.Sprintf("value is larger than %d bytes", u.Config.MaxValueSize)))
+			}
+			result.Values.Add(field, string(value))
+			continue
+		}
+
+		if u.Config.MaxFiles > 0 && len(result.Files) >= u.Config.MaxFiles {
+			return ginext.NewValidationErrors(ginext.NewFieldError(field, fmt.Sprintf("at most %d files are allowed", u.Config.MaxFiles)))
+		}
+		file, err := u.store(ctx, field, part.FileName(), part)
+		if err != nil {
+			return err
+		}
+		result.Files = append(result.Files, file)
+	}
+}
+
+// store sniffs, checks & stores a file
+func (u *Uploader) store(ctx context.Context, field, filename string, r io.Reader) (*File, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	file := &File{Field: field, Filename: filename, ContentType: http.DetectContentType(head)}
+	if !u.allowed(file.ContentType) {
+		return nil, ginext.NewValidationErrors(ginext.NewFieldError(field, fmt.Sprintf("content type %s is not allowed", file.ContentType)))
+	}
+	file.Key = u.Key(ctx, file)
+
+	hash := sha256.New()
+	body := &limitedReader{r: io.MultiReader(bytes.NewReader(head), r), limit: u.Config.MaxFileSize}
+	if err = u.Storage.Put(ctx, file.Key, io.TeeReader(body, hash), file.ContentType); err != nil {
+		if errors.Is(err, errTooLarge) || body.exceeded {
+			return nil, ginext.NewValidationErrors(ginext.NewFieldError(field, fmt.Sprintf("file is larger than %d bytes", u.Config.MaxFileSize)))
+		}
+		return nil, err
+	}
+	file.Size = body.n
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return file, nil
+}
+
+// allowed checks a sniffed content type against AllowedTypes
+func (u *Uploader) allowed(contentType string) bool {
+	if len(u.Config.AllowedTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range u.Config.AllowedTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mediaType || allowed == "*/*" ||
+			strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanup deletes the files stored by a failed upload
+func (u *Uploader) cleanup(ctx context.Context, files []*File) {
+	for _, file := range files {
+		if err := u.Storage.Delete(context.Background(), file.Key); err != nil {
+			logger.WithCtx(ctx, "upload.Uploader").WithError(err).WithField("key", file.Key).Error("failed to delete file of failed upload")
+		}
+	}
+}
+
+// limitedReader fails with errTooLarge once more than limit bytes are read, limit <= 0 means no limit
+type limitedReader struct {
+	r        io.Reader
+	limit    int64
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.limit > 0 && l.n > l.limit {
+		l.exceeded = true
+		return 0, errTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,180 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/praslar/cloud0/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init("upload.test")
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+var pngHeader = []byte("\x89PNG\x0D\x0A\x1A\x0A")
+
+type part struct {
+	field, filename string
+	content         []byte
+}
+
+func multipartBody(t *testing.T, parts ...part) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	for _, p := range parts {
+		var (
+			pw  io.Writer
+			err error
+		)
+		if p.filename == "" {
+			pw, err = w.CreateFormField(p.field)
+		} else {
+			pw, err = w.CreateFormFile(p.field, p.filename)
+		}
+		require.NoError(t, err)
+		_, err = pw.Write(p.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return body, w.FormDataContentType()
+}
+
+func uploadRouter(uploader *Uploader) *gin.Engine {
+	router := gin.New()
+	router.Use(ginext.CreateErrorHandler())
+	router.POST("/upload", ginext.WrapHandler(func(r *ginext.Request) (*ginext.Response, error) {
+		result, err := uploader.Upload(r)
+		if err != nil {
+			return nil, err
+		}
+		return ginext.NewResponseData(http.StatusCreated, gin.H{"files": result.Files, "values": result.Values}), nil
+	}))
+	return router
+}
+
+func doUpload(router *gin.Engine, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	var files []string
+	require.NoError(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	}))
+	return files
+}
+
+func TestUploadLocal(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewLocalStorage(dir)
+	require.NoError(t, err)
+	config := NewConfig()
+	config.MaxFileSize = 1024
+	config.MaxFiles = 2
+	config.AllowedTypes = []string{"image/*", "text/plain"}
+	uploader := New(storage, config)
+	router := uploadRouter(uploader)
+
+	image := append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{1}, 600)...)
+	body, contentType := multipartBody(t,
+		part{field: "title", content: []byte("holiday")},
+		part{field: "photo", filename: "Beach.PNG", content: image},
+		part{field: "notes", filename: "notes.txt", content: []byte("sunny")},
+	)
+	w := doUpload(router, body, contentType)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var rsp struct {
+		Data struct {
+			Files  []*File             `json:"files"`
+			Values map[string][]string `json:"values"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+	assert.Equal(t, []string{"holiday"}, rsp.Data.Values["title"])
+	require.Len(t, rsp.Data.Files, 2)
+
+	photo := rsp.Data.Files[0]
+	sum := sha256.Sum256(image)
+	assert.Equal(t, "photo", photo.Field)
+	assert.Equal(t, "Beach.PNG", photo.Filename)
+	assert.Equal(t, "image/png", photo.ContentType)
+	assert.Equal(t, int64(len(image)), photo.Size)
+	assert.Equal(t, hex.EncodeToString(sum[:]), photo.SHA256)
+	assert.Equal(t, ".png", filepath.Ext(photo.Key))
+	assert.Equal(t, "text/plain; charset=utf-8", rsp.Data.Files[1].ContentType)
+
+	r, err := storage.Open(context.Background(), photo.Key)
+	require.NoError(t, err)
+	stored, _ := io.ReadAll(r)
+	_ = r.Close()
+	assert.Equal(t, image, stored)
+
+	t.Run("Invalid", func(t *testing.T) {
+		before := listFiles(t, dir)
+		cases := map[string][]part{
+			"photo": {
+				{field: "doc", filename: "ok.txt", content: []byte("kept until the upload fails")},
+				{field: "photo", filename: "big.png", content: append(append([]byte{}, pngHeader...), make([]byte, 2048)...)},
+			},
+			"script": {{field: "script", filename: "image.png", content: []byte("\x7fELF\x02\x01\x01\x00\x00")}},
+			"third":  {{field: "a", filename: "a.txt", content: []byte("a")}, {field: "b", filename: "b.txt", content: []byte("b")}, {field: "third", filename: "c.txt", content: []byte("c")}},
+		}
+		for field, parts := range cases {
+			body, contentType := multipartBody(t, parts...)
+			w := doUpload(router, body, contentType)
+			assert.Equal(t, http.StatusBadRequest, w.Code, field)
+
+			var rsp struct {
+				Error map[string]string `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+			assert.Contains(t, rsp.Error, field, w.Body.String())
+		}
+		assert.Equal(t, before, listFiles(t, dir), "nothing is kept from failed uploads")
+	})
+
+	t.Run("NotMultipart", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnsupportedMediaType, doUpload(router, bytes.NewReader([]byte("{}")), "application/json").Code)
+	})
+}
+
+func TestLocalStorageKeys(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewLocalStorage(filepath.Join(dir, "files"))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, storage.Put(ctx, "../../escape.txt", bytes.NewReader([]byte("x")), "text/plain"))
+	_, err = os.Stat(filepath.Join(dir, "files", "escape.txt"))
+	assert.NoError(t, err, "keys can't escape the directory")
+
+	require.NoError(t, storage.Put(ctx, "a/b/c.txt", bytes.NewReader([]byte("x")), "text/plain"))
+	require.NoError(t, storage.Delete(ctx, "a/b/c.txt"))
+	require.NoError(t, storage.Delete(ctx, "a/b/c.txt"))
+	_, err = storage.Open(ctx, "a/b/c.txt")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
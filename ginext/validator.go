@@ -67,6 +67,16 @@ func (v *validatorImpl) Engine() interface{} {
 	return v.validate
 }
 
+// NewFieldError makes a field error, to report invalid input checked outside of struct validation
+func NewFieldError(field, message string) ValidatorFieldError {
+	return &validatorFieldError{field: field, message: message}
+}
+
+// NewValidationErrors makes a validation error (400), rendered by the error handler as a map of field => message
+func NewValidationErrors(fields ...ValidatorFieldError) ValidatorErrors {
+	return &validationErrors{fieldErrors: fields}
+}
+
 type validatorFieldError struct {
 	field   string
 	message string
@@ -0,0 +1,290 @@
+// Package ws upgrades requests to WebSocket connections managed by a Hub: connections carry the identity set by
+// ginext.AuthRequiredMiddleware, messages are json envelopes dispatched to typed handlers, and the hub broadcasts
+// to users or tenants then closes connections gracefully when the app shuts down.
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/praslar/cloud0/logger"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrClosed is returned when sending to a closed connection
+	ErrClosed = errors.New("ws: connection closed")
+	// ErrShuttingDown is returned (to the error handler) when upgrading while the hub is closed
+	ErrShuttingDown = ginext.NewError(http.StatusServiceUnavailable, "server is shutting down")
+)
+
+// Message is the json envelope of every message in both directions
+type Message struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id,omitempty"` // set by the client to correlate replies & errors
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error *ErrorBody      `json:"error,omitempty"`
+}
+
+// ErrorBody is sent in messages of type "error", like the error of http responses
+type ErrorBody struct {
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+// Config presents configuration of connections
+type Config struct {
+	SendBuffer   int                        `env:"WS_SEND_BUFFER" envDefault:"64"`    // messages queued per connection
+	ReadLimit    int64                      `env:"WS_READ_LIMIT" envDefault:"65536"`  // max size of an incoming message, in bytes
+	PingInterval time.Duration              `env:"WS_PING_INTERVAL" envDefault:"30s"` // how often connections are pinged
+	PongTimeout  time.Duration              `env:"WS_PONG_TIMEOUT" envDefault:"60s"`  // connections without pong (or message) for this long are closed
+	WriteTimeout time.Duration              `env:"WS_WRITE_TIMEOUT" envDefault:"10s"` // max time to write a message, also how long Send waits on a full buffer
+	CloseTimeout time.Duration              `env:"WS_CLOSE_TIMEOUT" envDefault:"5s"`  // how long Close waits for the peer to acknowledge
+	CheckOrigin  func(r *http.Request) bool // nil accepts same origin requests only
+}
+
+// NewConfig returns a config filled with default values
+func NewConfig() *Config {
+	return &Config{
+		SendBuffer:   64,
+		ReadLimit:    64 << 10,
+		PingInterval: 30 * time.Second,
+		PongTimeout:  60 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		CloseTimeout: 5 * time.Second,
+	}
+}
+
+type outgoing struct {
+	messageType int
+	data        []byte
+}
+
+// Conn is a client connection, its methods are safe for concurrent use
+type Conn struct {
+	ID       string
+	UserID   string
+	TenantID uint64
+
+	hub       *Hub
+	ws        *websocket.Conn
+	ctx       context.Context
+	cancel    context.CancelFunc
+	send      chan outgoing
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeMsg  []byte
+}
+
+// Context carries the request ID, user & tenant of the upgrade request, it's canceled when the connection closes
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+func (c *Conn) log() *logrus.Entry {
+	return logger.WithCtx(c.ctx, "ws.Conn").WithField("conn", c.ID).WithField("user", c.UserID)
+}
+
+// Send queues a message, it blocks while the send buffer is full (backpressure) until ctx is done,
+// the connection closes or WriteTimeout elapses, then the connection is considered too slow & closed
+func (c *Conn) Send(ctx context.Context, msgType string, data interface{}) error {
+	msg, err := encode(msgType, "", data)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(ctx, msg)
+}
+
+func (c *Conn) enqueue(ctx context.Context, msg []byte) error {
+	select {
+	case c.send <- outgoing{messageType: websocket.TextMessage, data: msg}:
+		return nil
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+
+	timer := time.NewTimer(c.hub.config.WriteTimeout)
+	defer timer.Stop()
+	select {
+	case c.send <- outgoing{messageType: websocket.TextMessage, data: msg}:
+		return nil
+	case <-c.closed:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		c.Close(websocket.ClosePolicyViolation, "too slow")
+		return ErrClosed
+	}
+}
+
+// trySend queues a message without waiting, a connection whose buffer is full is closed
+func (c *Conn) trySend(msg []byte) bool {
+	select {
+	case c.send <- outgoing{messageType: websocket.TextMessage, data: msg}:
+		return true
+	case <-c.closed:
+		return false
+	default:
+		c.Close(websocket.ClosePolicyViolation, "too slow")
+		return false
+	}
+}
+
+// reply answers the message in with the same type & ID, waiting for room in the send buffer
+// so clients sending faster than they read are slowed down
+func (c *Conn) reply(in *Message, data interface{}) {
+	msg, err := encode(in.Type, in.ID, data)
+	if err != nil {
+		c.sendError(in, err)
+		return
+	}
+	_ = c.enqueue(c.ctx, msg)
+}
+
+// Close sends a close frame with code & reason then closes the connection once the peer acknowledged it
+// or after CloseTimeout
+func (c *Conn) Close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeMsg = websocket.FormatCloseMessage(code, reason)
+		close(c.closed)
+	})
+}
+
+// abort closes the connection without close frame, when it's broken or the peer has closed it already
+func (c *Conn) abort() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+}
+
+func encode(msgType, id string, data interface{}) ([]byte, error) {
+	msg := Message{Type: msgType, ID: id}
+	switch v := data.(type) {
+	case nil:
+	case *ErrorBody:
+		msg.Error = v
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		msg.Data = raw
+	}
+	return json.Marshal(msg)
+}
+
+// writeLoop writes queued messages & pings until the connection is closed
+func (c *Conn) writeLoop(readDone <-chan struct{}) {
+	config := c.hub.config
+	ticker := time.NewTicker(config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		_ = c.ws.Close()
+	}()
+
+	for {
+		select {
+		case msg := <-c.send:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+			if err := c.ws.WriteMessage(msg.messageType, msg.data); err != nil {
+				c.log().WithError(err).Debug("failed to write message")
+				c.abort()
+				return
+			}
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(config.WriteTimeout)); err != nil {
+				c.abort()
+				return
+			}
+		case <-c.closed:
+			if c.closeMsg == nil {
+				return
+			}
+			c.drain()
+			// graceful close: send the close frame then wait for the peer to answer (the read loop ends)
+			if err := c.ws.WriteControl(websocket.CloseMessage, c.closeMsg, time.Now().Add(config.WriteTimeout)); err == nil {
+				select {
+				case <-readDone:
+				case <-time.After(config.CloseTimeout):
+				}
+			}
+			return
+		}
+	}
+}
+
+// drain writes the messages queued before the connection was closed
+func (c *Conn) drain() {
+	for {
+		select {
+		case msg := <-c.send:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(c.hub.config.WriteTimeout))
+			if err := c.ws.WriteMessage(msg.messageType, msg.data); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// readLoop reads & dispatches messages until the connection is closed
+func (c *Conn) readLoop() {
+	config := c.hub.config
+	c.ws.SetReadLimit(config.ReadLimit)
+	_ = c.ws.SetReadDeadline(time.Now().Add(config.PongTimeout))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(config.PongTimeout))
+	})
+
+	for {
+		messageType, data, err := c.ws.ReadMessage()
+		if err != nil {
+			// the close frame of the peer has been answered by the default close handler
+			if ce, ok := err.(*websocket.CloseError); ok {
+				c.log().WithField("code", ce.Code).Debug("closed by peer")
+			} else {
+				c.log().WithError(err).Debug("failed to read message")
+			}
+			c.abort()
+			return
+		}
+		_ = c.ws.SetReadDeadline(time.Now().Add(config.PongTimeout))
+		if messageType != websocket.TextMessage {
+			c.sendError(nil, ginext.NewError(http.StatusUnsupportedMediaType, "only text messages are supported"))
+			continue
+		}
+
+		in := &Message{}
+		if err = json.Unmarshal(data, in); err != nil || in.Type == "" {
+			c.sendError(nil, ginext.NewError(http.StatusBadRequest, "invalid message"))
+			continue
+		}
+		c.hub.dispatch(c, in)
+	}
+}
+
+// sendError sends err as an "error" message, with the ID of the message in (if any)
+func (c *Conn) sendError(in *Message, err error) {
+	body := &ErrorBody{Detail: err.Error(), Status: http.StatusInternalServerError}
+	if v, ok := err.(ginext.ApiError); ok {
+		body.Status = v.Code()
+	} else {
+		c.log().WithError(err).Error("failed to handle message")
+		body.Detail = http.StatusText(http.StatusInternalServerError)
+	}
+	id := ""
+	if in != nil {
+		id = in.ID
+	}
+	msg, _ := encode(TypeError, id, body)
+	c.trySend(msg)
+}
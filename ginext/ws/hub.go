@@ -0,0 +1,318 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/praslar/cloud0/logger"
+)
+
+// TypeError is the type of messages reporting the failure of a message
+const TypeError = "error"
+
+// HandlerFunc handles a message, a non nil reply is sent back with the type & ID of the message,
+// an error is sent as an "error" message
+type HandlerFunc func(ctx context.Context, c *Conn, msg *Message) (reply interface{}, err error)
+
+// On registers a handler of messages of msgType whose data is decoded to T then validated like ginext.Request.MustBind
+//
+//	ws.On(hub, "subscribe", func(ctx context.Context, c *ws.Conn, req SubscribeRequest) (interface{}, error) {
+//		...
+//	})
+func On[T any](h *Hub, msgType string, fn func(ctx context.Context, c *Conn, data T) (interface{}, error)) {
+	h.Handle(msgType, func(ctx context.Context, c *Conn, msg *Message) (interface{}, error) {
+		var data T
+		if len(msg.Data) > 0 {
+			if err := json.Unmarshal(msg.Data, &data); err != nil {
+				return nil, ginext.NewError(http.StatusBadRequest, "invalid data: "+err.Error())
+			}
+		}
+		if isStruct(data) {
+			if err := binding.Validator.ValidateStruct(data); err != nil {
+				return nil, err
+			}
+		}
+		return fn(ctx, c, data)
+	})
+}
+
+func isStruct(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t != nil && t.Kind() == reflect.Struct
+}
+
+// Hub keeps track of connections to dispatch their messages & broadcast to users or tenants,
+// register it as a runner so connections are closed gracefully on shutdown:
+//
+//	hub := ws.NewHub(nil)
+//	router.GET("/ws", ginext.AuthRequiredMiddleware, hub.Handler())
+//	app.RegisterRunner(hub)
+type Hub struct {
+	OnConnect    func(c *Conn)
+	OnDisconnect func(c *Conn)
+
+	config   *Config
+	upgrader websocket.Upgrader
+	handlers map[string]HandlerFunc
+
+	mu       sync.RWMutex
+	conns    map[*Conn]struct{}
+	byUser   map[string]map[*Conn]struct{}
+	byTenant map[uint64]map[*Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewHub creates a hub, nil config means default values
+func NewHub(config *Config) *Hub {
+	if config == nil {
+		config = NewConfig()
+	}
+	return &Hub{
+		config: config,
+		upgrader: websocket.Upgrader{
+			HandshakeTimeout: config.WriteTimeout,
+			CheckOrigin:      config.CheckOrigin,
+		},
+		handlers: map[string]HandlerFunc{},
+		conns:    map[*Conn]struct{}{},
+		byUser:   map[string]map[*Conn]struct{}{},
+		byTenant: map[uint64]map[*Conn]struct{}{},
+	}
+}
+
+// Handle registers the handler of messages of msgType, handlers must be registered before serving connections
+func (h *Hub) Handle(msgType string, fn HandlerFunc) {
+	h.handlers[msgType] = fn
+}
+
+// Handler upgrades requests to WebSocket, it requires the identity set by ginext.AuthRequiredMiddleware.
+// It blocks until the connection is closed.
+func (h *Hub) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := ginext.GetUserID(c)
+		if userID == "" {
+			_ = c.Error(ginext.NewError(http.StatusUnauthorized, "unauthorized"))
+			c.Abort()
+			return
+		}
+		tenantID, _ := c.Value(common.HeaderTenantID).(uint64)
+
+		h.mu.Lock()
+		if h.closed {
+			h.mu.Unlock()
+			_ = c.Error(ErrShuttingDown)
+			c.Abort()
+			return
+		}
+		h.wg.Add(1)
+		h.mu.Unlock()
+		defer h.wg.Done()
+
+		wsConn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// the upgrader has responded already
+			logger.WithCtx(ginext.FromGinRequestContext(c), "ws.Hub").WithError(err).Debug("failed to upgrade")
+			c.Abort()
+			return
+		}
+
+		ctx, cancel := context.WithCancel(ginext.FromGinRequestContext(c))
+		conn := &Conn{
+			ID:       uuid.NewString(),
+			UserID:   userID,
+			TenantID: tenantID,
+			hub:      h,
+			ws:       wsConn,
+			ctx:      ctx,
+			cancel:   cancel,
+			send:     make(chan outgoing, h.config.SendBuffer),
+			closed:   make(chan struct{}),
+		}
+		h.serve(conn)
+	}
+}
+
+// serve runs conn until it's closed
+func (h *Hub) serve(conn *Conn) {
+	start := time.Now()
+	h.register(conn)
+	conn.log().WithField("tenant", conn.TenantID).Info("websocket connected")
+	if h.OnConnect != nil {
+		h.OnConnect(conn)
+	}
+
+	readDone := make(chan struct{})
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		conn.writeLoop(readDone)
+	}()
+	conn.readLoop()
+	close(readDone)
+	<-writeDone
+
+	h.unregister(conn)
+	conn.cancel()
+	if h.OnDisconnect != nil {
+		h.OnDisconnect(conn)
+	}
+	conn.log().WithField("duration", time.Since(start).Milliseconds()).Info("websocket disconnected")
+}
+
+func (h *Hub) register(conn *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		// upgraded while shutting down
+		conn.Close(websocket.CloseGoingAway, "server is shutting down")
+	}
+	h.conns[conn] = struct{}{}
+	if h.byUser[conn.UserID] == nil {
+		h.byUser[conn.UserID] = map[*Conn]struct{}{}
+	}
+	h.byUser[conn.UserID][conn] = struct{}{}
+	if conn.TenantID != 0 {
+		if h.byTenant[conn.TenantID] == nil {
+			h.byTenant[conn.TenantID] = map[*Conn]struct{}{}
+		}
+		h.byTenant[conn.TenantID][conn] = struct{}{}
+	}
+}
+
+func (h *Hub) unregister(conn *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.conns, conn)
+	if conns := h.byUser[conn.UserID]; conns != nil {
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(h.byUser, conn.UserID)
+		}
+	}
+	if conns := h.byTenant[conn.TenantID]; conns != nil {
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(h.byTenant, conn.TenantID)
+		}
+	}
+}
+
+// dispatch runs the handler of msg, messages of a connection are handled one by one
+func (h *Hub) dispatch(conn *Conn, msg *Message) {
+	fn, ok := h.handlers[msg.Type]
+	if !ok {
+		conn.sendError(msg, ginext.NewError(http.StatusNotFound, fmt.Sprintf("unknown message type `%s`", msg.Type)))
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(error)
+			if !ok {
+				err = fmt.Errorf("unexpected error: %v", r)
+			}
+			conn.sendError(msg, err)
+		}
+	}()
+
+	reply, err := fn(conn.ctx, conn, msg)
+	if err != nil {
+		conn.sendError(msg, err)
+		return
+	}
+	if reply != nil {
+		conn.reply(msg, reply)
+	}
+}
+
+// Count returns the number of open connections
+func (h *Hub) Count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns)
+}
+
+// SendToUser sends a message to every connection of a user, it returns the number of connections it's queued to.
+// It doesn't wait: connections whose send buffer is full are closed as too slow.
+func (h *Hub) SendToUser(userID string, msgType string, data interface{}) (int, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.broadcast(h.byUser[userID], msgType, data)
+}
+
+// SendToTenant sends a message to every connection of a tenant, see SendToUser
+func (h *Hub) SendToTenant(tenantID uint64, msgType string, data interface{}) (int, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.broadcast(h.byTenant[tenantID], msgType, data)
+}
+
+// Broadcast sends a message to every connection, see SendToUser
+func (h *Hub) Broadcast(msgType string, data interface{}) (int, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.broadcast(h.conns, msgType, data)
+}
+
+// broadcast must be called with mu held
+func (h *Hub) broadcast(conns map[*Conn]struct{}, msgType string, data interface{}) (int, error) {
+	msg, err := encode(msgType, "", data)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for conn := range conns {
+		if conn.trySend(msg) {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// Shutdown stops accepting connections then closes open ones with 1001 (going away),
+// it waits until they're closed or ctx is done
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closed = true
+	for conn := range h.conns {
+		conn.Close(websocket.CloseGoingAway, "server is shutting down")
+	}
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run implements service.Runner, it shuts the hub down when ctx is done
+func (h *Hub) Run(ctx context.Context) error {
+	<-ctx.Done()
+	shutCtx, cancel := context.WithTimeout(context.Background(), h.config.WriteTimeout+h.config.CloseTimeout)
+	defer cancel()
+	return h.Shutdown(shutCtx)
+}
@@ -0,0 +1,227 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/praslar/cloud0/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init("ws.test")
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+type echoRequest struct {
+	Text string `json:"text" validate:"required"`
+}
+
+func newTestHub(t *testing.T, config *Config) (*Hub, string) {
+	hub := NewHub(config)
+	On(hub, "echo", func(ctx context.Context, c *Conn, req echoRequest) (interface{}, error) {
+		return gin.H{"text": req.Text, "user": c.UserID, "request_id": ctx.Value(common.HeaderXRequestID)}, nil
+	})
+	hub.Handle("fail", func(ctx context.Context, c *Conn, msg *Message) (interface{}, error) {
+		panic("boom")
+	})
+
+	router := gin.New()
+	router.Use(ginext.RequestIDMiddleware, ginext.CreateErrorHandler())
+	router.GET("/ws", ginext.AuthRequiredMiddleware, hub.Handler())
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return hub, "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+}
+
+func dial(t *testing.T, url, userID, tenantID string) *websocket.Conn {
+	header := http.Header{}
+	header.Set(common.HeaderUserID, userID)
+	header.Set(common.HeaderTenantID, tenantID)
+	header.Set(common.HeaderXRequestID, "req-"+userID)
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) *Message {
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg := &Message{}
+	require.NoError(t, conn.ReadJSON(msg))
+	return msg
+}
+
+func waitCount(t *testing.T, hub *Hub, n int) {
+	require.Eventually(t, func() bool { return hub.Count() == n }, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestMessages(t *testing.T) {
+	_, url := newTestHub(t, nil)
+	conn := dial(t, url, "alice", "1")
+
+	require.NoError(t, conn.WriteJSON(gin.H{"type": "echo", "id": "1", "data": gin.H{"text": "hi"}}))
+	msg := readMessage(t, conn)
+	assert.Equal(t, "echo", msg.Type)
+	assert.Equal(t, "1", msg.ID)
+	assert.JSONEq(t, `{"text":"hi","user":"alice","request_id":"req-alice"}`, string(msg.Data))
+
+	require.NoError(t, conn.WriteJSON(gin.H{"type": "echo", "id": "2", "data": gin.H{}}))
+	msg = readMessage(t, conn)
+	assert.Equal(t, TypeError, msg.Type)
+	assert.Equal(t, "2", msg.ID)
+	assert.Equal(t, http.StatusBadRequest, msg.Error.Status)
+
+	require.NoError(t, conn.WriteJSON(gin.H{"type": "unknown", "id": "3"}))
+	msg = readMessage(t, conn)
+	assert.Equal(t, http.StatusNotFound, msg.Error.Status)
+
+	require.NoError(t, conn.WriteJSON(gin.H{"type": "fail", "id": "4"}))
+	msg = readMessage(t, conn)
+	assert.Equal(t, http.StatusInternalServerError, msg.Error.Status)
+	assert.Equal(t, "Internal Server Error", msg.Error.Detail)
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("not json")))
+	assert.Equal(t, http.StatusBadRequest, readMessage(t, conn).Error.Status)
+}
+
+func TestUnauthorized(t *testing.T) {
+	_, url := newTestHub(t, nil)
+	_, rsp, err := websocket.DefaultDialer.Dial(url, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+}
+
+func TestBroadcast(t *testing.T) {
+	hub, url := newTestHub(t, nil)
+	alice := dial(t, url, "alice", "7")
+	alice2 := dial(t, url, "alice", "7")
+	bob := dial(t, url, "bob", "7")
+	carol := dial(t, url, "carol", "8")
+	waitCount(t, hub, 4)
+
+	n, err := hub.SendToUser("alice", "notification", gin.H{"n": 1})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "notification", readMessage(t, alice).Type)
+	assert.Equal(t, "notification", readMessage(t, alice2).Type)
+
+	n, _ = hub.SendToTenant(7, "announcement", "maintenance")
+	assert.Equal(t, 3, n)
+	for _, conn := range []*websocket.Conn{alice, alice2, bob} {
+		assert.Equal(t, `"maintenance"`, string(readMessage(t, conn).Data))
+	}
+
+	n, _ = hub.Broadcast("all", nil)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, "all", readMessage(t, carol).Type, "carol only gets the broadcast")
+
+	require.NoError(t, bob.Close())
+	waitCount(t, hub, 3)
+	n, _ = hub.SendToUser("bob", "notification", nil)
+	assert.Equal(t, 0, n)
+}
+
+func TestPing(t *testing.T) {
+	config := NewConfig()
+	config.PingInterval = 10 * time.Millisecond
+	config.PongTimeout = 50 * time.Millisecond
+	hub, url := newTestHub(t, config)
+	conn := dial(t, url, "alice", "1")
+
+	pinged := make(chan struct{}, 1)
+	conn.SetPingHandler(func(data string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	// pongs are sent while reading, keep reading longer than PongTimeout
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, 1, hub.Count(), "alive as pongs are answered")
+	assert.NotEmpty(t, pinged)
+
+	dial(t, url, "bob", "1")
+	waitCount(t, hub, 2)
+	// bob never reads, so never answers pings
+	waitCount(t, hub, 1)
+}
+
+func TestBackpressure(t *testing.T) {
+	hub := NewHub(&Config{SendBuffer: 1, WriteTimeout: 20 * time.Millisecond})
+	conn := &Conn{hub: hub, ctx: context.Background(), send: make(chan outgoing, 1), closed: make(chan struct{})}
+
+	require.NoError(t, conn.Send(context.Background(), "a", nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, conn.Send(ctx, "b", nil), context.Canceled, "Send waits for room until ctx is done")
+
+	assert.ErrorIs(t, conn.Send(context.Background(), "b", nil), ErrClosed, "too slow")
+	assert.Equal(t, websocket.ClosePolicyViolation, closeCode(conn))
+
+	slow := &Conn{hub: hub, ctx: context.Background(), send: make(chan outgoing, 1), closed: make(chan struct{})}
+	assert.True(t, slow.trySend([]byte("1")))
+	assert.False(t, slow.trySend([]byte("2")), "broadcasts don't wait")
+	assert.Equal(t, websocket.ClosePolicyViolation, closeCode(slow))
+}
+
+func closeCode(c *Conn) int {
+	select {
+	case <-c.closed:
+		return int(c.closeMsg[0])<<8 | int(c.closeMsg[1])
+	default:
+		return 0
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	hub, url := newTestHub(t, nil)
+	conn := dial(t, url, "alice", "1")
+	waitCount(t, hub, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- hub.Run(ctx) }()
+	n, _ := hub.SendToUser("alice", "last", nil)
+	require.Equal(t, 1, n)
+	cancel()
+
+	assert.Equal(t, "last", readMessage(t, conn).Type, "queued messages are sent before closing")
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("hub hasn't been shut down")
+	}
+	assert.Equal(t, 0, hub.Count())
+
+	header := http.Header{}
+	header.Set(common.HeaderUserID, "alice")
+	_, rsp, err := websocket.DefaultDialer.Dial(url, header)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+}
@@ -0,0 +1,147 @@
+package jobs
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/db"
+	"github.com/praslar/cloud0/ginext"
+	"gorm.io/gorm"
+)
+
+// RegisterAdminRoutes registers the admin API on router, it should be protected by the caller
+//
+//	GET  /jobs?status=dead&type=email.welcome   list jobs (paginated, newest first)
+//	GET  /jobs/:id                              get a job
+//	POST /jobs/:id/retry                        re-queue a dead/canceled job with fresh attempts
+//	POST /jobs/:id/cancel                       cancel a pending job
+//
+//	jobs.RegisterAdminRoutes(router.Group("/admin", adminOnly), nil)
+func RegisterAdminRoutes(router gin.IRouter, gormDB *gorm.DB) {
+	router.GET("/jobs", ginext.WrapHandler(ListHandler(gormDB)))
+	router.GET("/jobs/:id", ginext.WrapHandler(GetHandler(gormDB)))
+	router.POST("/jobs/:id/retry", ginext.WrapHandler(RetryHandler(gormDB)))
+	router.POST("/jobs/:id/cancel", ginext.WrapHandler(CancelHandler(gormDB)))
+}
+
+// adminDB returns the query on jobs, limited to the tenant in context if any
+func adminDB(r *ginext.Request, gormDB *gorm.DB) *gorm.DB {
+	if gormDB == nil {
+		gormDB = db.GetDB()
+	}
+	ctx := r.Context()
+	tx := gormDB.WithContext(ctx).Model(&Job{})
+	if tenantID, ok := db.TenantFromContext(ctx); ok {
+		tx = tx.Where("tenant_id = ?", tenantID)
+	}
+	return tx
+}
+
+func jobID(r *ginext.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.Param("id"), 10, 64)
+	if err != nil {
+		return 0, ginext.NewError(http.StatusBadRequest, "invalid job id")
+	}
+	return id, nil
+}
+
+// ListHandler returns paginated jobs filtered by query params `status` & `type`
+func ListHandler(gormDB *gorm.DB) ginext.Handler {
+	return func(r *ginext.Request) (*ginext.Response, error) {
+		pager := ginext.NewPagerWithGinCtx(r.GinCtx)
+		if pager.Sort == "" {
+			pager.Sort = "-id"
+		}
+
+		tx := adminDB(r, gormDB)
+		if status := r.Query("status"); status != "" {
+			tx = tx.Where("status = ?", status)
+		}
+		if jobType := r.Query("type"); jobType != "" {
+			tx = tx.Where("type = ?", jobType)
+		}
+
+		var jobs []*Job
+		if err := pager.DoQuery(&jobs, tx).Error; err != nil {
+			return nil, err
+		}
+
+		return ginext.NewResponseWithPager(http.StatusOK, jobs, pager), nil
+	}
+}
+
+// GetHandler returns a job
+func GetHandler(gormDB *gorm.DB) ginext.Handler {
+	return func(r *ginext.Request) (*ginext.Response, error) {
+		id, err := jobID(r)
+		if err != nil {
+			return nil, err
+		}
+
+		var jobs []*Job
+		if err = adminDB(r, gormDB).Where("id = ?", id).Limit(1).Find(&jobs).Error; err != nil {
+			return nil, err
+		}
+		if len(jobs) == 0 {
+			return nil, ErrNotFound
+		}
+
+		return ginext.NewResponseData(http.StatusOK, jobs[0]), nil
+	}
+}
+
+// RetryHandler re-queues a dead or canceled job to run now with fresh attempts
+func RetryHandler(gormDB *gorm.DB) ginext.Handler {
+	return transitionHandler(gormDB, []string{StatusDead, StatusCanceled}, func() map[string]interface{} {
+		return map[string]interface{}{
+			"status":      StatusPending,
+			"attempts":    0,
+			"run_at":      time.Now(),
+			"finished_at": nil,
+		}
+	})
+}
+
+// CancelHandler cancels a pending job
+func CancelHandler(gormDB *gorm.DB) ginext.Handler {
+	return transitionHandler(gormDB, []string{StatusPending}, func() map[string]interface{} {
+		return map[string]interface{}{
+			"status":      StatusCanceled,
+			"unique_key":  nil,
+			"finished_at": time.Now(),
+		}
+	})
+}
+
+// transitionHandler updates a job in one of the from statuses, 409 is returned if it's in another status
+func transitionHandler(gormDB *gorm.DB, from []string, updates func() map[string]interface{}) ginext.Handler {
+	return func(r *ginext.Request) (*ginext.Response, error) {
+		id, err := jobID(r)
+		if err != nil {
+			return nil, err
+		}
+
+		res := adminDB(r, gormDB).Where("id = ? AND status IN ?", id, from).Updates(updates())
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			var count int64
+			if err = adminDB(r, gormDB).Where("id = ?", id).Count(&count).Error; err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				return nil, ErrNotFound
+			}
+			return nil, ginext.NewError(http.StatusConflict, "job can't be changed in its current status")
+		}
+
+		var job Job
+		if err = adminDB(r, gormDB).Where("id = ?", id).Take(&job).Error; err != nil {
+			return nil, err
+		}
+		return ginext.NewResponseData(http.StatusOK, &job), nil
+	}
+}
@@ -0,0 +1,211 @@
+// Package jobs implements a background job queue persisted in the database, no message broker needed.
+//
+// Jobs are enqueued with Enqueue then processed by a Worker (a BaseApp runner),
+// a failing job is retried with exponential backoff until it runs out of attempts and becomes dead.
+package jobs
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/db"
+	"github.com/praslar/cloud0/ginext"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Job statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead" // ran out of attempts
+	StatusCanceled  = "canceled"
+)
+
+const defaultMaxAttempts = 25
+
+var (
+	// ErrDuplicate is returned by Enqueue when an unfinished job with the same unique key exists
+	ErrDuplicate = ginext.NewError(409, "job with the same unique key already exists")
+	// ErrNotFound is returned when the job doesn't exist
+	ErrNotFound = ginext.NewError(404, "job not found")
+)
+
+// Job presents a unit of background work
+type Job struct {
+	ID          int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Type        string     `gorm:"size:128;not null;index" json:"type"`
+	Payload     Payload    `json:"payload"`
+	Status      string     `gorm:"size:16;not null;index:idx_job_claim,priority:1" json:"status"`
+	Priority    int        `gorm:"not null;default:0" json:"priority"`               // higher runs first
+	UniqueKey   *string    `gorm:"size:255;uniqueIndex" json:"unique_key,omitempty"` // cleared when the job finishes
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts int        `gorm:"not null" json:"max_attempts"`
+	RunAt       time.Time  `gorm:"not null;index:idx_job_claim,priority:2" json:"run_at"`
+	LockedAt    *time.Time `json:"locked_at,omitempty"`
+	LockedBy    string     `gorm:"size:64" json:"locked_by,omitempty"`
+	LastError   string     `gorm:"type:text" json:"last_error,omitempty"`
+	RequestID   string     `gorm:"size:64" json:"request_id,omitempty"`
+	UserID      string     `gorm:"size:64" json:"user_id,omitempty"`
+	TenantID    uint64     `gorm:"index" json:"tenant_id,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Payload is the json payload of a job, it's rendered as is in json (eg. by the admin API) instead of base64
+type Payload []byte
+
+// Value implements the driver.Valuer interface.
+func (p Payload) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	return []byte(p), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (p *Payload) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*p = nil
+	case string:
+		*p = Payload(v)
+	case []byte:
+		*p = append((*p)[:0], v...)
+	default:
+		return fmt.Errorf("unsupported type %T for job payload", value)
+	}
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (p Payload) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (p *Payload) UnmarshalJSON(b []byte) error {
+	*p = append((*p)[:0], b...)
+	return nil
+}
+
+// GetSortableFields implements the ginext.SortableFieldsGetter interface.
+func (Job) GetSortableFields() []string {
+	return []string{"id", "type", "status", "priority", "run_at", "created_at"}
+}
+
+// Bind decodes the json payload into v
+func (j *Job) Bind(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Migrate creates/updates the jobs table
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Job{})
+}
+
+// Options presents enqueue options, all are optional
+type Options struct {
+	Delay       time.Duration // run after a delay
+	RunAt       time.Time     // run at a specific time, takes precedence over Delay
+	Priority    int           // higher runs first
+	UniqueKey   string        // at most one unfinished job per unique key
+	MaxAttempts int           // default 25
+	DB          *gorm.DB      // enqueue within the caller's transaction, use db.GetDB() if nil
+}
+
+// Enqueue adds a job to the queue, payload is encoded as json (unless it's a []byte, json.RawMessage or Payload,
+// which must be valid json).
+// The request, user & tenant IDs in ctx are saved with the job then restored into the handler context
+//
+//	_, err := jobs.Enqueue(ctx, "email.welcome", WelcomeEmail{UserID: id}, &jobs.Options{Delay: time.Minute})
+func Enqueue(ctx context.Context, jobType string, payload interface{}, opts *Options) (*Job, error) {
+	if jobType == "" {
+		return nil, errors.New("jobs: empty job type")
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	var raw Payload
+	switch v := payload.(type) {
+	case nil:
+	case []byte:
+		raw = v
+	case json.RawMessage:
+		raw = Payload(v)
+	case Payload:
+		raw = v
+	default:
+		var err error
+		if raw, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	if raw != nil && !json.Valid(raw) {
+		return nil, errors.New("jobs: payload isn't valid json")
+	}
+
+	job := &Job{
+		Type:        jobType,
+		Payload:     raw,
+		Status:      StatusPending,
+		Priority:    opts.Priority,
+		MaxAttempts: opts.MaxAttempts,
+		RunAt:       opts.RunAt,
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = defaultMaxAttempts
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now().Add(opts.Delay)
+	}
+	if opts.UniqueKey != "" {
+		job.UniqueKey = &opts.UniqueKey
+	}
+	job.RequestID, _ = ctx.Value("x-request-id").(string)
+	job.UserID, _ = ctx.Value(common.HeaderUserID).(string)
+	job.TenantID, _ = db.TenantFromContext(ctx)
+
+	tx := opts.DB
+	if tx == nil {
+		tx = db.GetDB()
+	}
+	tx = tx.WithContext(ctx)
+	if job.UniqueKey != nil {
+		tx = tx.Clauses(clause.OnConflict{DoNothing: true})
+	}
+
+	res := tx.Create(job)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrDuplicate
+	}
+	return job, nil
+}
+
+// jobContext makes the handler context carrying IDs of the request that enqueued the job
+func jobContext(ctx context.Context, job *Job) context.Context {
+	if job.RequestID != "" {
+		ctx = context.WithValue(ctx, "x-request-id", job.RequestID)
+	}
+	if job.UserID != "" {
+		ctx = context.WithValue(ctx, common.HeaderUserID, job.UserID)
+	}
+	if job.TenantID != 0 {
+		ctx = db.WithTenant(ctx, job.TenantID)
+	}
+	return ctx
+}
@@ -0,0 +1,328 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/db"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type welcomeEmail struct {
+	UserID int `json:"user_id"`
+}
+
+func setupDB(t *testing.T) *gorm.DB {
+	gormDB, err := db.Open(&db.Config{Driver: "sqlite3", DSN: ":memory:", MaxOpenConns: 1, MaxIdleConns: 1})
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close(gormDB) })
+	require.NoError(t, Migrate(gormDB))
+	return gormDB
+}
+
+func newTestWorker(gormDB *gorm.DB) *Worker {
+	config := NewConfig()
+	config.MinBackoff = 0
+	config.MaxBackoff = 0
+	return NewWorker(gormDB, config)
+}
+
+func reload(t *testing.T, gormDB *gorm.DB, job *Job) *Job {
+	var fresh Job
+	require.NoError(t, gormDB.First(&fresh, job.ID).Error)
+	return &fresh
+}
+
+func TestEnqueue(t *testing.T) {
+	gormDB := setupDB(t)
+	ctx := context.WithValue(context.Background(), "x-request-id", "req-1")
+	ctx = context.WithValue(ctx, common.HeaderUserID, "7")
+	ctx = db.WithTenant(ctx, 3)
+
+	job, err := Enqueue(ctx, "email.welcome", welcomeEmail{UserID: 7}, &Options{DB: gormDB, Delay: time.Hour, Priority: 5})
+	require.NoError(t, err)
+
+	job = reload(t, gormDB, job)
+	assert.Equal(t, StatusPending, job.Status)
+	assert.Equal(t, defaultMaxAttempts, job.MaxAttempts)
+	assert.Equal(t, "req-1", job.RequestID)
+	assert.Equal(t, "7", job.UserID)
+	assert.Equal(t, uint64(3), job.TenantID)
+	assert.True(t, job.RunAt.After(time.Now().Add(59*time.Minute)))
+	assert.JSONEq(t, `{"user_id":7}`, string(job.Payload))
+
+	t.Run("UniqueKey", func(t *testing.T) {
+		opts := &Options{DB: gormDB, UniqueKey: "welcome:7"}
+		_, err := Enqueue(ctx, "email.welcome", nil, opts)
+		require.NoError(t, err)
+		_, err = Enqueue(ctx, "email.welcome", nil, opts)
+		assert.Equal(t, ErrDuplicate, err)
+	})
+
+	t.Run("InvalidRawPayload", func(t *testing.T) {
+		_, err := Enqueue(ctx, "email.welcome", []byte("not json"), &Options{DB: gormDB})
+		assert.Error(t, err)
+		job, err := Enqueue(ctx, "email.welcome", json.RawMessage(`{"user_id":8}`), &Options{DB: gormDB})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"user_id":8}`, string(reload(t, gormDB, job).Payload))
+	})
+
+	t.Run("TypeRequired", func(t *testing.T) {
+		_, err := Enqueue(ctx, "", nil, &Options{DB: gormDB})
+		assert.Error(t, err)
+	})
+}
+
+func TestWorkerProcess(t *testing.T) {
+	gormDB := setupDB(t)
+	worker := newTestWorker(gormDB)
+	ctx := context.Background()
+
+	var gotCtx context.Context
+	var gotPayload welcomeEmail
+	worker.Handle("email.welcome", func(ctx context.Context, job *Job) error {
+		gotCtx = ctx
+		return job.Bind(&gotPayload)
+	})
+
+	enqueueCtx := db.WithTenant(context.WithValue(ctx, "x-request-id", "req-1"), 3)
+	job, err := Enqueue(enqueueCtx, "email.welcome", welcomeEmail{UserID: 7}, &Options{DB: gormDB, UniqueKey: "welcome:7"})
+	require.NoError(t, err)
+	_, err = Enqueue(ctx, "unknown", nil, &Options{DB: gormDB})
+	require.NoError(t, err)
+
+	processed, err := worker.ProcessOnce(ctx)
+	require.NoError(t, err)
+	assert.True(t, processed)
+	assert.Equal(t, 7, gotPayload.UserID)
+	assert.Equal(t, "req-1", gotCtx.Value("x-request-id"))
+	tenantID, _ := db.TenantFromContext(gotCtx)
+	assert.Equal(t, uint64(3), tenantID)
+
+	job = reload(t, gormDB, job)
+	assert.Equal(t, StatusSucceeded, job.Status)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Nil(t, job.UniqueKey, "the unique key is released")
+	assert.NotNil(t, job.FinishedAt)
+
+	processed, err = worker.ProcessOnce(ctx)
+	require.NoError(t, err)
+	assert.False(t, processed, "jobs without handler are left to other workers")
+}
+
+func TestWorkerPriorityAndDelay(t *testing.T) {
+	gormDB := setupDB(t)
+	worker := newTestWorker(gormDB)
+	ctx := context.Background()
+
+	var order []string
+	worker.Handle("task", func(ctx context.Context, job *Job) error {
+		var name string
+		_ = job.Bind(&name)
+		order = append(order, name)
+		return nil
+	})
+
+	for _, opts := range []struct {
+		name string
+		opts *Options
+	}{
+		{"low", &Options{DB: gormDB}},
+		{"delayed", &Options{DB: gormDB, Delay: time.Hour, Priority: 10}},
+		{"high", &Options{DB: gormDB, Priority: 5}},
+	} {
+		_, err := Enqueue(ctx, "task", opts.name, opts.opts)
+		require.NoError(t, err)
+	}
+
+	for {
+		processed, err := worker.ProcessOnce(ctx)
+		require.NoError(t, err)
+		if !processed {
+			break
+		}
+	}
+	assert.Equal(t, []string{"high", "low"}, order)
+}
+
+func TestWorkerRetryUntilDead(t *testing.T) {
+	gormDB := setupDB(t)
+	worker := newTestWorker(gormDB)
+	ctx := context.Background()
+
+	var calls int32
+	worker.Handle("flaky", func(ctx context.Context, job *Job) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("boom")
+		}
+		return errors.New("still failing")
+	})
+
+	job, err := Enqueue(ctx, "flaky", nil, &Options{DB: gormDB, MaxAttempts: 3})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		processed, err := worker.ProcessOnce(ctx)
+		require.NoError(t, err)
+		assert.True(t, processed)
+	}
+
+	job = reload(t, gormDB, job)
+	assert.Equal(t, StatusDead, job.Status)
+	assert.Equal(t, 3, job.Attempts)
+	assert.Equal(t, "still failing", job.LastError)
+
+	processed, err := worker.ProcessOnce(ctx)
+	require.NoError(t, err)
+	assert.False(t, processed, "dead jobs are not retried")
+}
+
+func TestWorkerBackoff(t *testing.T) {
+	worker := NewWorker(nil, &Config{MinBackoff: time.Second, MaxBackoff: 5 * time.Second})
+	assert.Equal(t, time.Second, worker.backoff(1))
+	assert.Equal(t, 4*time.Second, worker.backoff(3))
+	assert.Equal(t, 5*time.Second, worker.backoff(10))
+}
+
+func TestWorkerRun(t *testing.T) {
+	gormDB := setupDB(t)
+	worker := newTestWorker(gormDB)
+	worker.Config.PollInterval = 10 * time.Millisecond
+
+	done := make(chan struct{}, 1)
+	worker.Handle("task", func(ctx context.Context, job *Job) error {
+		done <- struct{}{}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		_ = worker.Run(ctx)
+		close(stopped)
+	}()
+
+	_, err := Enqueue(context.Background(), "task", nil, &Options{DB: gormDB})
+	require.NoError(t, err)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("job was not processed")
+	}
+
+	cancel()
+	<-stopped
+}
+
+func TestRequeueStuck(t *testing.T) {
+	gormDB := setupDB(t)
+	worker := newTestWorker(gormDB)
+
+	job, err := Enqueue(context.Background(), "task", nil, &Options{DB: gormDB})
+	require.NoError(t, err)
+	require.NoError(t, gormDB.Model(job).Updates(map[string]interface{}{
+		"status":    StatusRunning,
+		"locked_at": time.Now().Add(-time.Hour),
+	}).Error)
+
+	n, err := worker.RequeueStuck(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, StatusPending, reload(t, gormDB, job).Status)
+}
+
+func TestFinishRequeuedJob(t *testing.T) {
+	gormDB := setupDB(t)
+	first, second := newTestWorker(gormDB), newTestWorker(gormDB)
+	first.Handle("task", func(ctx context.Context, job *Job) error { return nil })
+	second.Handle("task", func(ctx context.Context, job *Job) error { return nil })
+
+	_, err := Enqueue(context.Background(), "task", nil, &Options{DB: gormDB})
+	require.NoError(t, err)
+	job, err := first.claim(context.Background())
+	require.NoError(t, err)
+
+	// the first worker is stuck, the job is requeued then claimed by the second one
+	require.NoError(t, gormDB.Model(job).Update("locked_at", time.Now().Add(-time.Hour)).Error)
+	_, err = first.RequeueStuck(context.Background())
+	require.NoError(t, err)
+	reclaimed, err := second.claim(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, reclaimed)
+
+	require.NoError(t, first.finish(context.Background(), job, nil))
+	fresh := reload(t, gormDB, job)
+	assert.Equal(t, StatusRunning, fresh.Status, "the late result of the first worker is dropped")
+	assert.Equal(t, second.ID, fresh.LockedBy)
+
+	require.NoError(t, second.finish(context.Background(), reclaimed, nil))
+	assert.Equal(t, StatusSucceeded, reload(t, gormDB, job).Status)
+}
+
+func TestAdminRoutes(t *testing.T) {
+	gormDB := setupDB(t)
+	ctx := context.Background()
+	pending, err := Enqueue(ctx, "task", welcomeEmail{UserID: 7}, &Options{DB: gormDB})
+	require.NoError(t, err)
+	dead, err := Enqueue(ctx, "task", nil, &Options{DB: gormDB})
+	require.NoError(t, err)
+	require.NoError(t, gormDB.Model(dead).Updates(map[string]interface{}{"status": StatusDead, "attempts": 25}).Error)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ginext.CreateErrorHandler())
+	RegisterAdminRoutes(router, gormDB)
+
+	doRequest := func(method, path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		body := map[string]interface{}{}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	code, body := doRequest(http.MethodGet, "/jobs?status=dead")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, body["data"], 1)
+	assert.Equal(t, float64(dead.ID), body["data"].([]interface{})[0].(map[string]interface{})["id"])
+
+	code, body = doRequest(http.MethodGet, "/jobs/1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"user_id": float64(7)}, body["data"].(map[string]interface{})["payload"],
+		"payloads are readable json, not base64")
+
+	code, _ = doRequest(http.MethodPost, "/jobs/2/retry")
+	require.Equal(t, http.StatusOK, code)
+	dead = reload(t, gormDB, dead)
+	assert.Equal(t, StatusPending, dead.Status)
+	assert.Equal(t, 0, dead.Attempts)
+
+	code, _ = doRequest(http.MethodPost, "/jobs/1/cancel")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusCanceled, reload(t, gormDB, pending).Status)
+
+	code, _ = doRequest(http.MethodPost, "/jobs/1/cancel")
+	assert.Equal(t, http.StatusConflict, code, "canceled job can't be canceled again")
+
+	code, _ = doRequest(http.MethodGet, "/jobs/100")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestNewConfig(t *testing.T) {
+	t.Setenv("JOBS_CONCURRENCY", "10")
+	cfg := NewConfig()
+	assert.Equal(t, 4, cfg.Concurrency, "the environment isn't read")
+	assert.Equal(t, time.Hour, cfg.MaxBackoff)
+	assert.Equal(t, 15*time.Minute, cfg.LockTimeout)
+}
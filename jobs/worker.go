@@ -0,0 +1,304 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/config"
+	"github.com/praslar/cloud0/db"
+	"github.com/praslar/cloud0/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HandlerFunc processes a job, a returned error (or panic) makes the job retried later
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+// Config presents configuration of the worker pool
+type Config struct {
+	Concurrency  int           `env:"JOBS_CONCURRENCY" envDefault:"4"`
+	PollInterval time.Duration `env:"JOBS_POLL_INTERVAL" envDefault:"1s"`
+	MinBackoff   time.Duration `env:"JOBS_MIN_BACKOFF" envDefault:"1s"`
+	MaxBackoff   time.Duration `env:"JOBS_MAX_BACKOFF" envDefault:"1h"`
+	LockTimeout  time.Duration `env:"JOBS_LOCK_TIMEOUT" envDefault:"15m"` // running jobs older than this are considered crashed & re-queued
+}
+
+// NewConfig returns a config filled with default values, the environment isn't read:
+// load the config with app.RegisterConfig (or config.Load) to apply JOBS_* variables
+func NewConfig() *Config {
+	cfg := &Config{}
+	_ = config.Defaults(cfg)
+	return cfg
+}
+
+// Worker is a pool of goroutines claiming & processing jobs,
+// it implements service.Runner so it can be managed by BaseApp
+//
+//	worker := jobs.NewWorker(nil, nil)
+//	worker.Handle("email.welcome", func(ctx context.Context, job *jobs.Job) error {
+//		var email WelcomeEmail
+//		if err := job.Bind(&email); err != nil {
+//			return err
+//		}
+//		...
+//	})
+//	app.RegisterRunner(worker)
+//
+// multiple workers (replicas) can run concurrently, jobs are claimed with FOR UPDATE SKIP LOCKED
+type Worker struct {
+	DB     *gorm.DB // use db.GetDB() if nil
+	Config *Config
+	ID     string // identifies the worker in locked_by
+
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+}
+
+// NewWorker makes a new worker, a nil config means default config
+func NewWorker(gormDB *gorm.DB, config *Config) *Worker {
+	if config == nil {
+		config = NewConfig()
+	}
+	hostname, _ := os.Hostname()
+	return &Worker{
+		DB:       gormDB,
+		Config:   config,
+		ID:       fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8]),
+		handlers: map[string]HandlerFunc{},
+	}
+}
+
+func (w *Worker) getDB() *gorm.DB {
+	if w.DB != nil {
+		return w.DB
+	}
+	return db.GetDB()
+}
+
+// Handle registers the handler of a job type, only registered types are claimed by the worker
+func (w *Worker) Handle(jobType string, handler HandlerFunc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers[jobType] = handler
+}
+
+func (w *Worker) types() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	types := make([]string, 0, len(w.handlers))
+	for t := range w.handlers {
+		types = append(types, t)
+	}
+	return types
+}
+
+func (w *Worker) handler(jobType string) HandlerFunc {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.handlers[jobType]
+}
+
+// Run processes jobs with Config.Concurrency goroutines until ctx is done,
+// jobs interrupted by the shutdown are put back to the queue without consuming an attempt
+func (w *Worker) Run(ctx context.Context) error {
+	concurrency := w.Config.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+
+	w.requeueLoop(ctx)
+	wg.Wait()
+	return nil
+}
+
+func (w *Worker) loop(ctx context.Context) {
+	l := logger.Tag("jobs.Worker")
+	ticker := time.NewTicker(w.Config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// drain the queue as long as there are due jobs
+		for ctx.Err() == nil {
+			processed, err := w.ProcessOnce(ctx)
+			if err != nil && ctx.Err() == nil {
+				l.WithError(err).Error("failed to process job")
+			}
+			if err != nil || !processed {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// requeueLoop periodically puts crashed running jobs back to the queue
+func (w *Worker) requeueLoop(ctx context.Context) {
+	if w.Config.LockTimeout <= 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(w.Config.LockTimeout / 2)
+	defer ticker.Stop()
+	for {
+		if n, err := w.RequeueStuck(ctx); err != nil && ctx.Err() == nil {
+			logger.Tag("jobs.Worker").WithError(err).Error("failed to requeue stuck jobs")
+		} else if n > 0 {
+			logger.Tag("jobs.Worker").Warnf("requeued %d stuck jobs", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RequeueStuck puts running jobs locked for longer than Config.LockTimeout back to the queue
+func (w *Worker) RequeueStuck(ctx context.Context) (int64, error) {
+	tx := w.getDB().WithContext(ctx).Model(&Job{}).
+		Where("status = ? AND locked_at < ?", StatusRunning, time.Now().Add(-w.Config.LockTimeout)).
+		Updates(map[string]interface{}{
+			"status":     StatusPending,
+			"locked_at":  nil,
+			"locked_by":  "",
+			"last_error": "worker lock timeout",
+		})
+	return tx.RowsAffected, tx.Error
+}
+
+// ProcessOnce claims the next due job then runs its handler,
+// it returns false if there's no job to process
+func (w *Worker) ProcessOnce(ctx context.Context) (bool, error) {
+	job, err := w.claim(ctx)
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	err = w.run(ctx, job)
+	return true, w.finish(ctx, job, err)
+}
+
+// claim picks the next due job & marks it running
+func (w *Worker) claim(ctx context.Context) (*Job, error) {
+	types := w.types()
+	if len(types) == 0 {
+		return nil, nil
+	}
+
+	var job *Job
+	err := w.getDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var jobs []*Job
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND run_at <= ? AND type IN ?", StatusPending, time.Now(), types).
+			Order("priority DESC, run_at, id").
+			Limit(1).
+			Find(&jobs).Error
+		if err != nil || len(jobs) == 0 {
+			return err
+		}
+
+		job = jobs[0]
+		now := time.Now()
+		job.Status = StatusRunning
+		job.Attempts++
+		job.LockedAt = &now
+		job.LockedBy = w.ID
+		return tx.Model(job).Updates(map[string]interface{}{
+			"status":    job.Status,
+			"attempts":  job.Attempts,
+			"locked_at": job.LockedAt,
+			"locked_by": job.LockedBy,
+		}).Error
+	})
+	return job, err
+}
+
+// run calls the job handler, recovering panics
+func (w *Worker) run(ctx context.Context, job *Job) (err error) {
+	handler := w.handler(job.Type)
+	if handler == nil {
+		return fmt.Errorf("no handler for job type %q", job.Type)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.WithCtx(jobContext(ctx, job), "jobs.Worker").
+				WithField("job_id", job.ID).WithField("stack", string(debug.Stack())).
+				Errorf("job panicked: %v", r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return handler(jobContext(ctx, job), job)
+}
+
+// finish saves the result of a job run
+func (w *Worker) finish(ctx context.Context, job *Job, runErr error) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"locked_at": nil,
+		"locked_by": "",
+	}
+
+	switch {
+	case runErr == nil:
+		updates["status"] = StatusSucceeded
+		updates["finished_at"] = now
+		updates["unique_key"] = nil
+		updates["last_error"] = ""
+	case ctx.Err() != nil:
+		// interrupted by the shutdown, it doesn't count
+		updates["status"] = StatusPending
+		updates["attempts"] = job.Attempts - 1
+	case job.Attempts >= job.MaxAttempts:
+		logger.WithCtx(jobContext(ctx, job), "jobs.Worker").WithField("job_id", job.ID).WithError(runErr).
+			Errorf("job %s is dead after %d attempts", job.Type, job.Attempts)
+		updates["status"] = StatusDead
+		updates["finished_at"] = now
+		updates["unique_key"] = nil
+		updates["last_error"] = runErr.Error()
+	default:
+		logger.WithCtx(jobContext(ctx, job), "jobs.Worker").WithField("job_id", job.ID).WithError(runErr).
+			Warnf("job %s failed, attempt %d/%d", job.Type, job.Attempts, job.MaxAttempts)
+		updates["status"] = StatusPending
+		updates["run_at"] = now.Add(w.backoff(job.Attempts))
+		updates["last_error"] = runErr.Error()
+	}
+
+	// the job must be updated even if ctx is canceled,
+	// unless it has been requeued (see RequeueStuck) & claimed by another worker meanwhile
+	tx := w.getDB().WithContext(context.Background()).Model(&Job{}).
+		Where("id = ? AND status = ? AND locked_by = ?", job.ID, StatusRunning, w.ID).Updates(updates)
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		logger.WithCtx(jobContext(ctx, job), "jobs.Worker").WithField("job_id", job.ID).
+			Warnf("job %s isn't locked by this worker anymore, its result is dropped", job.Type)
+	}
+	return tx.Error
+}
+
+// backoff returns the exponential delay before the next attempt
+func (w *Worker) backoff(attempts int) time.Duration {
+	return common.Backoff(attempts, w.Config.MinBackoff, w.Config.MaxBackoff)
+}
@@ -70,3 +70,16 @@ func WithCtx(ctx context.Context, tag string) *logrus.Entry {
 func WithField(key string, value interface{}) *logrus.Entry {
 	return DefaultBaseEntry.WithField(key, value)
 }
+
+// SetLevel changes the log level of the default logger, eg. "debug", "info", "warn"
+func SetLevel(level string) error {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	if DefaultLogger == nil {
+		Init("common")
+	}
+	DefaultLogger.SetLevel(l)
+	return nil
+}
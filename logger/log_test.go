@@ -27,3 +27,13 @@ func TestTagWithCtx(t *testing.T) {
 
 	entry.Debug("finish log unit tests")
 }
+
+func TestSetLevel(t *testing.T) {
+	Init("test")
+	level := DefaultLogger.GetLevel()
+	defer DefaultLogger.SetLevel(level)
+
+	assert.NoError(t, SetLevel("warn"))
+	assert.Equal(t, "warning", DefaultLogger.GetLevel().String())
+	assert.Error(t, SetLevel("loud"))
+}
@@ -0,0 +1,83 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNext(t *testing.T) {
+	from := time.Date(2021, 10, 15, 10, 20, 30, 0, time.UTC) // a friday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2021, 10, 15, 10, 21, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 10, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2021, 10, 16, 3, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2021, 10, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2021, 10, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2021, 10, 22, 0, 0, 0, 0, time.UTC)}, // day-of-month or day-of-week
+		{"0 0 * * 7", time.Date(2021, 10, 17, 0, 0, 0, 0, time.UTC)},  // 7 is sunday
+		{"45 20,40 10 * * *", time.Date(2021, 10, 15, 10, 20, 45, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 10, 15, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2021, 10, 15, 10, 21, 0, 0, time.UTC)}, // aligned to multiples of 90s
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec, time.UTC)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, s.Next(from))
+		})
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	hcm, err := time.LoadLocation("Asia/Ho_Chi_Minh")
+	require.NoError(t, err)
+	from := time.Date(2021, 10, 15, 0, 0, 0, 0, time.UTC)
+	want := time.Date(2021, 10, 15, 20, 0, 0, 0, time.UTC) // 3:00 in UTC+7
+
+	s, err := Parse("0 3 * * *", hcm)
+	require.NoError(t, err)
+	assert.Equal(t, want, s.Next(from))
+
+	s, err = Parse("CRON_TZ=Asia/Ho_Chi_Minh 0 3 * * *", time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, want, s.Next(from))
+}
+
+func TestParseDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	// 2:30 doesn't exist on 2021-03-14
+	s, err := Parse("30 2 * * *", ny)
+	require.NoError(t, err)
+	next := s.Next(time.Date(2021, 3, 13, 12, 0, 0, 0, ny))
+	assert.Equal(t, time.Date(2021, 3, 15, 2, 30, 0, 0, ny), next)
+}
+
+func TestEveryAligned(t *testing.T) {
+	s := Every(time.Minute)
+	at := time.Date(2021, 6, 1, 10, 0, 25, 0, time.UTC)
+	assert.Equal(t, time.Date(2021, 6, 1, 10, 1, 0, 0, time.UTC), s.Next(at))
+	assert.Equal(t, time.Date(2021, 6, 1, 10, 2, 0, 0, time.UTC), s.Next(time.Date(2021, 6, 1, 10, 1, 0, 0, time.UTC)))
+	assert.Equal(t, s.Next(at), s.Next(at.Add(20*time.Second)), "replicas started at different times fire together")
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8",
+		"*/0 * * * *", "5-1 * * * *", "* * * foo *", "@every -1s", "@every x", "TZ=Nowhere/City * * * * *",
+	} {
+		_, err := Parse(spec, nil)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,264 @@
+// Package scheduler runs periodic jobs (cron expressions or fixed intervals) within the app lifecycle.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/praslar/cloud0/db/lock"
+	"github.com/praslar/cloud0/logger"
+)
+
+const (
+	defaultStopTimeout = 30 * time.Second
+	// lockHoldMargin keeps single replica locks a bit after the run to tolerate clock skew between replicas,
+	// otherwise a replica firing slightly later would run the job again.
+	// The lock is never held past half the time to the next activation, so short intervals don't skip runs
+	lockHoldMargin = 5 * time.Second
+)
+
+// Func is a scheduled job
+type Func func(ctx context.Context) error
+
+// Options presents options of a scheduled job, all are optional
+type Options struct {
+	Location      *time.Location // time zone of the cron expression, default time.Local
+	Jitter        time.Duration  // random delay up to Jitter before each run, to spread load
+	AllowOverlap  bool           // by default a run is skipped while the previous one is still running
+	SingleReplica bool           // run on one replica only, guarded by a DB lock (see Scheduler.Locker)
+	Timeout       time.Duration  // cancel the run's context after Timeout, 0 means no timeout
+}
+
+type entry struct {
+	name     string
+	schedule Schedule
+	fn       Func
+	opts     Options
+	running  int32
+}
+
+// Scheduler runs registered jobs on their schedules,
+// it implements service.Runner so it can be managed by BaseApp (see BaseApp.Scheduler):
+//
+//	s := app.Scheduler()
+//	_ = s.Cron("cleanup", "0 3 * * *", cleanup, &scheduler.Options{SingleReplica: true})
+//	_ = s.Every("refresh-cache", time.Minute, refresh, &scheduler.Options{Jitter: 10 * time.Second})
+//
+// on stop (SIGTERM), it stops firing then waits up to StopTimeout for running jobs before canceling their context
+type Scheduler struct {
+	Locker      *lock.Locker  // locker of SingleReplica jobs, lock.New(nil) if nil
+	StopTimeout time.Duration // default 30s
+
+	mu      sync.Mutex
+	entries []*entry
+	start   func(e *entry) // set while running, starts jobs added after Run
+}
+
+// New makes a new scheduler
+func New() *Scheduler {
+	return &Scheduler{StopTimeout: defaultStopTimeout}
+}
+
+// Cron schedules fn with a cron expression, see Parse for the supported syntax
+func (s *Scheduler) Cron(name, spec string, fn Func, opts *Options) error {
+	var location *time.Location
+	if opts != nil {
+		location = opts.Location
+	}
+	schedule, err := Parse(spec, location)
+	if err != nil {
+		return err
+	}
+	return s.Schedule(name, schedule, fn, opts)
+}
+
+// Every schedules fn at a fixed interval aligned to the wall clock (see Every schedule),
+// the first run is at most one interval after the start
+func (s *Scheduler) Every(name string, interval time.Duration, fn Func, opts *Options) error {
+	if interval <= 0 {
+		return errors.New("scheduler: interval must be positive")
+	}
+	return s.Schedule(name, Every(interval), fn, opts)
+}
+
+// Schedule schedules fn with a custom schedule, names must be unique
+func (s *Scheduler) Schedule(name string, schedule Schedule, fn Func, opts *Options) error {
+	if name == "" || fn == nil {
+		return errors.New("scheduler: name and func are required")
+	}
+	e := &entry{name: name, schedule: schedule, fn: fn}
+	if opts != nil {
+		e.opts = *opts
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.entries {
+		if existing.name == name {
+			return fmt.Errorf("scheduler: job %q already exists", name)
+		}
+	}
+	s.entries = append(s.entries, e)
+	if s.start != nil {
+		s.start(e)
+	}
+	return nil
+}
+
+// Run fires jobs until ctx is done then waits for running jobs
+func (s *Scheduler) Run(ctx context.Context) error {
+	// jobs get their own context so they can finish after ctx is done
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	var loops, runs sync.WaitGroup
+	s.mu.Lock()
+	s.start = func(e *entry) {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			s.loop(ctx, jobCtx, e, &runs)
+		}()
+	}
+	for _, e := range s.entries {
+		s.start(e)
+	}
+	s.mu.Unlock()
+
+	<-ctx.Done()
+	s.mu.Lock()
+	s.start = nil
+	s.mu.Unlock()
+	loops.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		runs.Wait()
+		close(done)
+	}()
+
+	stopTimeout := s.StopTimeout
+	if stopTimeout <= 0 {
+		stopTimeout = defaultStopTimeout
+	}
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		logger.Tag("scheduler").Warnf("jobs are still running after %s, canceling them", stopTimeout)
+		cancelJobs()
+		<-done
+	}
+	return nil
+}
+
+// loop fires e on its schedule until ctx is done
+func (s *Scheduler) loop(ctx, jobCtx context.Context, e *entry, runs *sync.WaitGroup) {
+	l := logger.Tag("scheduler").WithField("job", e.name)
+	for {
+		next := e.schedule.Next(time.Now())
+		if next.IsZero() {
+			l.Warn("no next activation, job won't run anymore")
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !e.opts.AllowOverlap && !atomic.CompareAndSwapInt32(&e.running, 0, 1) {
+			l.Warn("previous run is still running, skipped")
+			continue
+		}
+
+		runs.Add(1)
+		go func() {
+			defer runs.Done()
+			if !e.opts.AllowOverlap {
+				defer atomic.StoreInt32(&e.running, 0)
+			}
+			s.fire(ctx, jobCtx, e, next)
+		}()
+	}
+}
+
+// fire runs e once: waits for the jitter, takes the lock of single replica jobs then calls the job
+func (s *Scheduler) fire(ctx, jobCtx context.Context, e *entry, scheduledAt time.Time) {
+	l := logger.Tag("scheduler").WithField("job", e.name)
+
+	if e.opts.Jitter > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(rand.Int63n(int64(e.opts.Jitter)))):
+		}
+	}
+
+	if e.opts.SingleReplica {
+		locker := s.Locker
+		if locker == nil {
+			locker = lock.New(nil)
+		}
+		jobLock, err := locker.TryLock(jobCtx, "scheduler:"+e.name)
+		if err == lock.ErrNotAcquired {
+			l.Debug("running on another replica, skipped")
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("failed to acquire lock, skipped")
+			return
+		}
+		defer func() {
+			// hold the lock until other replicas have passed the same activation
+			holdUntil := scheduledAt.Add(e.opts.Jitter + lockHoldMargin)
+			if next := e.schedule.Next(scheduledAt); !next.IsZero() {
+				if half := scheduledAt.Add(next.Sub(scheduledAt) / 2); half.Before(holdUntil) {
+					holdUntil = half
+				}
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(holdUntil)):
+			}
+			if err := jobLock.Release(context.Background()); err != nil {
+				l.WithError(err).Warn("failed to release lock")
+			}
+		}()
+	}
+
+	runCtx := jobCtx
+	if e.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(jobCtx, e.opts.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	if err := run(runCtx, e); err != nil {
+		l.WithError(err).WithField("latency", time.Since(start).String()).Error("job failed")
+		return
+	}
+	l.WithField("latency", time.Since(start).String()).Debug("job done")
+}
+
+// run calls the job, recovering panics
+func run(ctx context.Context, e *entry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Tag("scheduler").WithField("job", e.name).WithField("stack", string(debug.Stack())).
+				Errorf("job panicked: %v", r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return e.fn(ctx)
+}
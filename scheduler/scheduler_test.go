@@ -0,0 +1,245 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/praslar/cloud0/db"
+	"github.com/praslar/cloud0/db/lock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// start runs s in background, the returned func stops it & waits for Run to return
+func start(s *Scheduler) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = s.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestEvery(t *testing.T) {
+	s := New()
+	var calls int32
+	require.NoError(t, s.Every("tick", 10*time.Millisecond, func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}, nil))
+	assert.Error(t, s.Every("tick", time.Second, func(ctx context.Context) error { return nil }, nil), "duplicated name")
+	assert.Error(t, s.Cron("bad", "* *", func(ctx context.Context) error { return nil }, nil))
+
+	stop := start(s)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&calls) >= 3 }, time.Second, 5*time.Millisecond)
+
+	// jobs can be added while running
+	var late int32
+	require.NoError(t, s.Every("late", 10*time.Millisecond, func(ctx context.Context) error {
+		atomic.AddInt32(&late, 1)
+		return nil
+	}, nil))
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&late) >= 1 }, time.Second, 5*time.Millisecond)
+
+	stop()
+	n := atomic.LoadInt32(&calls)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&calls), "no more runs after stop")
+}
+
+func TestOverlapPrevention(t *testing.T) {
+	var running, maxRunning, calls int32
+	job := func(ctx context.Context) error {
+		cur := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			old := atomic.LoadInt32(&maxRunning)
+			if cur <= old || atomic.CompareAndSwapInt32(&maxRunning, old, cur) {
+				break
+			}
+		}
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(35 * time.Millisecond)
+		return nil
+	}
+
+	s := New()
+	require.NoError(t, s.Every("slow", 10*time.Millisecond, job, nil))
+	stop := start(s)
+	time.Sleep(150 * time.Millisecond)
+	stop()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&maxRunning))
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&calls), int32(2))
+
+	atomic.StoreInt32(&maxRunning, 0)
+	s = New()
+	require.NoError(t, s.Every("slow", 10*time.Millisecond, job, &Options{AllowOverlap: true}))
+	stop = start(s)
+	time.Sleep(150 * time.Millisecond)
+	stop()
+	assert.Greater(t, atomic.LoadInt32(&maxRunning), int32(1))
+}
+
+func TestPanicRecovery(t *testing.T) {
+	s := New()
+	var calls int32
+	require.NoError(t, s.Every("panic", 10*time.Millisecond, func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("boom")
+		}
+		return errors.New("failed")
+	}, nil))
+
+	stop := start(s)
+	defer stop()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&calls) >= 3 }, time.Second, 5*time.Millisecond)
+}
+
+func TestGracefulStop(t *testing.T) {
+	s := New()
+	started := make(chan struct{})
+	var finished, canceled int32
+	require.NoError(t, s.Every("long", 10*time.Millisecond, func(ctx context.Context) error {
+		close(started)
+		select {
+		case <-time.After(50 * time.Millisecond):
+			atomic.StoreInt32(&finished, 1)
+		case <-ctx.Done():
+			atomic.StoreInt32(&canceled, 1)
+		}
+		return nil
+	}, nil))
+
+	stop := start(s)
+	<-started
+	stop()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&finished), "running job should finish before Run returns")
+
+	t.Run("StopTimeout", func(t *testing.T) {
+		s := New()
+		s.StopTimeout = 20 * time.Millisecond
+		started := make(chan struct{})
+		require.NoError(t, s.Every("stuck", 10*time.Millisecond, func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			atomic.StoreInt32(&canceled, 1)
+			return ctx.Err()
+		}, nil))
+
+		stop := start(s)
+		<-started
+		stop()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&canceled))
+	})
+}
+
+func TestSingleReplica(t *testing.T) {
+	gormDB, err := db.Open(&db.Config{Driver: "sqlite3", DSN: ":memory:", MaxOpenConns: 1, MaxIdleConns: 1})
+	require.NoError(t, err)
+	defer db.Close(gormDB)
+
+	var calls int32
+	job := func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+
+	// two replicas firing at the same time
+	var stops []func()
+	for i := 0; i < 2; i++ {
+		s := New()
+		s.Locker = lock.New(gormDB)
+		require.NoError(t, s.Schedule("report", onceSchedule(time.Now().Add(20*time.Millisecond)), job, &Options{SingleReplica: true}))
+		stops = append(stops, start(s))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for _, stop := range stops {
+		stop()
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestSingleReplicaShortInterval(t *testing.T) {
+	gormDB, err := db.Open(&db.Config{Driver: "sqlite3", DSN: ":memory:", MaxOpenConns: 1, MaxIdleConns: 1})
+	require.NoError(t, err)
+	defer db.Close(gormDB)
+
+	var calls int32
+	job := func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}
+
+	// the lock isn't held for the whole margin, runs shorter than it aren't skipped on every replica
+	var stops []func()
+	for i := 0; i < 2; i++ {
+		s := New()
+		s.Locker = lock.New(gormDB)
+		require.NoError(t, s.Every("refresh", 30*time.Millisecond, job, &Options{SingleReplica: true}))
+		stops = append(stops, start(s))
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	for _, stop := range stops {
+		stop()
+	}
+	n := atomic.LoadInt32(&calls)
+	assert.GreaterOrEqual(t, n, int32(4))
+	assert.LessOrEqual(t, n, int32(7), "a single replica runs each activation")
+}
+
+func TestSingleReplicaOffsetStart(t *testing.T) {
+	gormDB, err := db.Open(&db.Config{Driver: "sqlite3", DSN: ":memory:", MaxOpenConns: 1, MaxIdleConns: 1})
+	require.NoError(t, err)
+	defer db.Close(gormDB)
+
+	var mu sync.Mutex
+	var runs []time.Time
+	job := func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs = append(runs, time.Now())
+		return nil
+	}
+
+	// replicas don't start at the same time, they must still fire at the same activations
+	var stops []func()
+	for i := 0; i < 2; i++ {
+		s := New()
+		s.Locker = lock.New(gormDB)
+		require.NoError(t, s.Every("refresh", 100*time.Millisecond, job, &Options{SingleReplica: true}))
+		stops = append(stops, start(s))
+		time.Sleep(60 * time.Millisecond)
+	}
+
+	time.Sleep(540 * time.Millisecond)
+	for _, stop := range stops {
+		stop()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.GreaterOrEqual(t, len(runs), 4)
+	for i := 1; i < len(runs); i++ {
+		assert.Greater(t, runs[i].Sub(runs[i-1]), 80*time.Millisecond, "a single replica runs each activation")
+	}
+}
+
+// onceSchedule activates once at the given time
+type onceSchedule time.Time
+
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if t.Before(time.Time(s)) {
+		return time.Time(s)
+	}
+	return time.Time{}
+}
@@ -2,23 +2,41 @@ package service
 
 import (
 	"github.com/praslar/cloud0/db"
+	"github.com/praslar/cloud0/ginext"
 )
 
-// AppConfig presents some basic app configuration
+// AppConfig presents some basic app configuration,
+// fields tagged `reload:"true"` are applied on reload (SIGHUP), others require a restart.
+// Reloads don't change BaseApp.Config, read the reloaded values with CurrentConfig(app, app.Config).
 type AppConfig struct {
-	Port          int      `env:"PORT" envDefault:"8088"`
-	Env           string   `env:"ENV" envDefault:"stg"`
-	DebugPort     int      `env:"DEBUG_PORT" envDefault:"7070"`
-	ReadTimeout   int      `env:"READ_TIMEOUT" envDefault:"15"`
-	EnableProfile bool     `env:"ENABLE_PROFILE" envDefault:"true"` // enable profile listener
-	EnableDB      bool     `env:"ENABLE_DB" envDefault:"false"`
-	TrustedProxy  []string `env:"TRUSTED_PROXY" envSeparator:"," envDefault:"127.0.0.1,10.0.0.0/8,192.168.0.0/16"`
-	Debug         bool     `env:"DEBUG" envDefault:"false"`
-	DB            *db.Config
+	Port                int      `env:"PORT" envDefault:"8088"`
+	Env                 string   `env:"ENV" envDefault:"stg"`
+	DebugPort           int      `env:"DEBUG_PORT" envDefault:"7070"`
+	ReadTimeout         int      `env:"READ_TIMEOUT" envDefault:"15"`          // in seconds, to read a whole request
+	ReadHeaderTimeout   int      `env:"READ_HEADER_TIMEOUT" envDefault:"0"`    // in seconds, 0 means ReadTimeout
+	WriteTimeout        int      `env:"WRITE_TIMEOUT" envDefault:"0"`          // in seconds, 0 disables it (it would cut SSE & long downloads)
+	IdleTimeout         int      `env:"IDLE_TIMEOUT" envDefault:"120"`         // in seconds, for keep-alive connections
+	MaxHeaderBytes      int      `env:"MAX_HEADER_BYTES" envDefault:"1048576"` // request line & headers
+	MaxBodyBytes        int64    `env:"MAX_BODY_BYTES" envDefault:"0"`         // larger requests get 413, 0 disables the limit, routes can change it with ginext.MaxBodyMiddleware
+	EnableProfile       bool     `env:"ENABLE_PROFILE" envDefault:"true"`      // enable profile listener
+	EnableDB            bool     `env:"ENABLE_DB" envDefault:"false"`
+	TrustedProxy        []string `env:"TRUSTED_PROXY" envSeparator:"," envDefault:"127.0.0.1,10.0.0.0/8,192.168.0.0/16" reload:"true"`
+	ProxyProtocol       bool     `env:"PROXY_PROTOCOL" envDefault:"false"` // accept PROXY protocol headers from TrustedProxy
+	Debug               bool     `env:"DEBUG" envDefault:"false" reload:"true"`
+	LogLevel            string   `env:"LOG_LEVEL" reload:"true"`
+	ConfigWatchFile     string   `env:"CONFIG_WATCH_FILE"`                    // a KEY=VALUE env file, the config is reloaded when it changes
+	ConfigWatchInterval int      `env:"CONFIG_WATCH_INTERVAL" envDefault:"5"` // in seconds
+	DB                  *db.Config
+	CORS                *ginext.CORSConfig            // registered when CORS_ENABLED
+	SecurityHeaders     *ginext.SecurityHeadersConfig // registered when SECURITY_HEADERS_ENABLED
+	Compress            *ginext.CompressConfig        // registered when COMPRESS_ENABLED
 }
 
 func NewAppConfig() *AppConfig {
 	return &AppConfig{
-		DB: &db.Config{},
+		DB:              &db.Config{},
+		CORS:            ginext.NewCORSConfig(),
+		SecurityHeaders: ginext.NewSecurityHeadersConfig(),
+		Compress:        ginext.NewCompressConfig(),
 	}
 }
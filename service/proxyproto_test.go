@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveRemoteAddr serves http on a PROXY protocol listener, responding the request RemoteAddr
+func serveRemoteAddr(t *testing.T, trusted bool) string {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := &proxyProtoListener{Listener: l, trusted: func(net.IP) bool { return trusted }}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.RemoteAddr)
+	})}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+	return l.Addr().String()
+}
+
+// doRequest sends header then a http request on a new connection, returns the response body
+func doRequest(t *testing.T, addr string, header []byte) (string, error) {
+	conn, err := net.Dial("tcp4", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(append(header, "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"...))
+	require.NoError(t, err)
+	rsp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	return string(body), err
+}
+
+func proxyV2Header(ip net.IP, port uint16) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	payload := make([]byte, 12)
+	copy(payload[0:4], ip.To4())
+	copy(payload[4:8], net.IPv4(127, 0, 0, 1).To4())
+	binary.BigEndian.PutUint16(payload[8:10], port)
+	binary.BigEndian.PutUint16(payload[10:12], 8088)
+	header = append(header, 0x21, 0x11, 0, 12)
+	return append(header, payload...)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	addr := serveRemoteAddr(t, true)
+
+	t.Run("V1", func(t *testing.T) {
+		body, err := doRequest(t, addr, []byte("PROXY TCP4 198.51.100.1 127.0.0.1 56324 8088\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "198.51.100.1:56324", body)
+	})
+
+	t.Run("V2", func(t *testing.T) {
+		body, err := doRequest(t, addr, proxyV2Header(net.IPv4(198, 51, 100, 2), 4000))
+		require.NoError(t, err)
+		assert.Equal(t, "198.51.100.2:4000", body)
+	})
+
+	t.Run("NoHeader", func(t *testing.T) {
+		body, err := doRequest(t, addr, nil)
+		require.NoError(t, err)
+		assert.Contains(t, body, "127.0.0.1:")
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		body, _ := doRequest(t, addr, []byte("PROXY TCP4 nope\r\n"))
+		assert.NotContains(t, body, "nope")
+		assert.NotContains(t, body, "127.0.0.1:", "the request isn't served")
+	})
+
+	t.Run("Untrusted", func(t *testing.T) {
+		untrusted := serveRemoteAddr(t, false)
+		body, err := doRequest(t, untrusted, nil)
+		require.NoError(t, err)
+		assert.Contains(t, body, "127.0.0.1:")
+
+		// the header isn't honored
+		body, _ = doRequest(t, untrusted, []byte("PROXY TCP4 198.51.100.1 127.0.0.1 1 2\r\n"))
+		assert.NotContains(t, body, "198.51.100.1")
+	})
+}
@@ -0,0 +1,370 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/config"
+	"github.com/praslar/cloud0/ginext"
+	"github.com/praslar/cloud0/logger"
+)
+
+// ConfigChange presents a config field changed on reload
+type ConfigChange struct {
+	Field      string // path of the field, eg. "AppConfig.Debug"
+	Env        string // env variable of the field, eg. "DEBUG"
+	Old, New   interface{}
+	Reloadable bool // applied to the running app, otherwise the change requires a restart
+
+	current, fresh reflect.Value // the field in the current & the reloaded versions of the config
+}
+
+// ConfigChangeFunc is called when a reloadable config field has changed
+type ConfigChangeFunc func(change ConfigChange)
+
+// configRegistry keeps user config structs & change subscribers
+type configRegistry struct {
+	mu          sync.Mutex
+	configs     []interface{}
+	subscribers map[string][]ConfigChangeFunc
+	versions    sync.Map // registered config (or app.Config) -> its latest version
+}
+
+// latest returns the latest version of a registered config, cfg itself until it's reloaded
+func (r *configRegistry) latest(cfg interface{}) interface{} {
+	if v, ok := r.versions.Load(cfg); ok {
+		return v
+	}
+	return cfg
+}
+
+// RegisterConfig loads cfg (a pointer to struct with env tags) with app.ConfigLoader then keeps it to be reloaded,
+// fields tagged `reload:"true"` are updated on reload, subscribe with OnConfigChange to apply them
+// or read them with CurrentConfig. cfg itself is never changed, so it can be read while reloading.
+//
+//	type Config struct {
+//		RateLimit int    `env:"RATE_LIMIT" envDefault:"100" reload:"true"`
+//		Bucket    string `env:"BUCKET"`
+//	}
+//	cfg := &Config{}
+//	if err := app.RegisterConfig(cfg); err != nil { ... }
+func (app *BaseApp) RegisterConfig(cfg interface{}) error {
+	if err := app.ConfigLoader.Load(cfg); err != nil {
+		return err
+	}
+	logger.Tag("BaseApp.RegisterConfig").WithField("config", config.Dump(cfg)).Infof("effective config %T", cfg)
+
+	app.configReg.mu.Lock()
+	defer app.configReg.mu.Unlock()
+	app.configReg.configs = append(app.configReg.configs, cfg)
+	return nil
+}
+
+// CurrentConfig returns the latest version of cfg (app.Config or a config passed to RegisterConfig):
+// a reload publishes a new version with the reloadable changes, it's safe to be called while serving requests
+//
+//	limit := service.CurrentConfig(app, cfg).RateLimit
+func CurrentConfig[T any](app *BaseApp, cfg *T) *T {
+	return app.configReg.latest(cfg).(*T)
+}
+
+// OnConfigChange subscribes to changes of a reloadable field by its env name (eg. "LOG_LEVEL")
+// or its path (eg. "AppConfig.Debug"), fn is called after the new version has been published (see CurrentConfig),
+// it may call OnConfigChange or Reload
+func (app *BaseApp) OnConfigChange(key string, fn ConfigChangeFunc) {
+	app.configReg.mu.Lock()
+	defer app.configReg.mu.Unlock()
+	if app.configReg.subscribers == nil {
+		app.configReg.subscribers = map[string][]ConfigChangeFunc{}
+	}
+	app.configReg.subscribers[key] = append(app.configReg.subscribers[key], fn)
+}
+
+// Reload reloads the app config & registered configs with app.ConfigLoader, publishes their new versions
+// with the reloadable changes (see CurrentConfig) then notifies subscribers, it's called on SIGHUP
+func (app *BaseApp) Reload() ([]ConfigChange, error) {
+	app.configReg.mu.Lock()
+	changes, notify, err := app.reload()
+	app.configReg.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// subscribers are called without the lock, they may subscribe or reload
+	for _, fn := range notify {
+		fn()
+	}
+	return changes, nil
+}
+
+// reload loads & publishes the new versions of the configs, it returns the subscriber calls to be made
+func (app *BaseApp) reload() ([]ConfigChange, []func(), error) {
+	l := logger.Tag("BaseApp.Reload")
+
+	type version struct {
+		cfg, fresh interface{}
+	}
+	fresh := NewAppConfig()
+	if err := app.ConfigLoader.Load(fresh); err != nil {
+		return nil, nil, err
+	}
+	app.deriveConfig(fresh)
+	changes := diffConfig("AppConfig", reflect.ValueOf(app.configReg.latest(app.Config)), reflect.ValueOf(fresh))
+	versions := []version{{app.Config, fresh}}
+
+	for _, cfg := range app.configReg.configs {
+		current := reflect.ValueOf(app.configReg.latest(cfg))
+		freshCfg := reflect.New(current.Elem().Type())
+		if err := app.ConfigLoader.Load(freshCfg.Interface()); err != nil {
+			return nil, nil, err
+		}
+		changes = append(changes, diffConfig(current.Elem().Type().Name(), current, freshCfg)...)
+		versions = append(versions, version{cfg, freshCfg.Interface()})
+	}
+
+	if len(changes) == 0 {
+		l.Info("config reloaded, nothing changed")
+		return nil, nil, nil
+	}
+
+	var notify []func()
+	for _, c := range changes {
+		entry := l.WithField("field", c.Field).WithField("env", c.Env)
+		if !config.SensitiveEnv.MatchString(c.Env) {
+			entry = entry.WithField("old", c.Old).WithField("new", c.New)
+		}
+		if !c.Reloadable {
+			entry.Warn("config changed but it's not reloadable, restart to apply")
+			// the new version keeps the running value
+			c.fresh.Set(c.current)
+			continue
+		}
+
+		entry.Info("config changed")
+		for _, key := range []string{c.Env, c.Field} {
+			for _, fn := range app.configReg.subscribers[key] {
+				fn, c := fn, c
+				notify = append(notify, func() { fn(c) })
+			}
+		}
+	}
+
+	// fresh versions aren't shared until they're published
+	for _, v := range versions {
+		app.configReg.versions.Store(v.cfg, v.fresh)
+	}
+	return changes, notify, nil
+}
+
+// deriveConfig fills the settings of cfg that default to other settings, it's applied to reloaded configs
+// as well so derived values aren't reported as changes
+func (app *BaseApp) deriveConfig(cfg *AppConfig) {
+	if !cfg.EnableDB {
+		return
+	}
+	if cfg.Debug && cfg.DB.LogLevel == "" {
+		cfg.DB.LogLevel = "info"
+	}
+	if cfg.DB.ApplicationName == "" {
+		cfg.DB.ApplicationName = app.Name
+	}
+}
+
+// diffConfig compares 2 config structs field by field, nested structs are walked
+func diffConfig(path string, current, fresh reflect.Value) []ConfigChange {
+	for current.Kind() == reflect.Ptr {
+		if current.IsNil() || fresh.IsNil() {
+			return nil
+		}
+		current, fresh = current.Elem(), fresh.Elem()
+	}
+	if current.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var changes []ConfigChange
+	t := current.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		fieldPath := path + "." + field.Name
+
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Time{}) && field.Tag.Get("env") == "" {
+			changes = append(changes, diffConfig(fieldPath, current.Field(i), fresh.Field(i))...)
+			continue
+		}
+
+		oldValue, newValue := current.Field(i), fresh.Field(i)
+		if reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			continue
+		}
+
+		envName := strings.Split(field.Tag.Get("env"), ",")[0]
+		changes = append(changes, ConfigChange{
+			Field:      fieldPath,
+			Env:        envName,
+			Old:        oldValue.Interface(),
+			New:        newValue.Interface(),
+			Reloadable: field.Tag.Get("reload") == "true",
+			current:    oldValue,
+			fresh:      newValue,
+		})
+	}
+	return changes
+}
+
+// configWatcher reloads the config when the config file (yaml, json, toml or KEY=VALUE env file) changes
+type configWatcher struct {
+	app      *BaseApp
+	path     string
+	interval time.Duration
+	modTime  time.Time
+}
+
+// Run implements the Runner interface.
+func (w *configWatcher) Run(ctx context.Context) error {
+	l := logger.Tag("BaseApp.ConfigWatcher").WithField("file", w.path)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(w.path)
+		if err != nil {
+			l.WithError(err).Warn("failed to stat config file")
+			continue
+		}
+		if !info.ModTime().After(w.modTime) {
+			continue
+		}
+		w.modTime = info.ModTime()
+
+		l.Info("config file changed, reloading")
+		if !config.IsConfigFile(w.path) {
+			if err = loadEnvFile(w.path); err != nil {
+				l.WithError(err).Error("failed to load config file")
+				continue
+			}
+		}
+		if _, err = w.app.Reload(); err != nil {
+			l.WithError(err).Error("failed to reload config")
+		}
+	}
+}
+
+// loadEnvFile sets env variables from a file of KEY=VALUE lines, empty lines & # comments are ignored
+func loadEnvFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if err = os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// watchConfigFile loads the config file then registers a runner reloading the config when it changes
+func (app *BaseApp) watchConfigFile() error {
+	path := app.Config.ConfigWatchFile
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if config.IsConfigFile(path) {
+		app.ConfigLoader.File = path
+	} else if err = loadEnvFile(path); err != nil {
+		return err
+	}
+	if err = app.ConfigLoader.Load(app.Config); err != nil {
+		return err
+	}
+
+	interval := time.Duration(app.Config.ConfigWatchInterval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	app.RegisterRunner(&configWatcher{app: app, path: path, interval: interval, modTime: info.ModTime()})
+	return nil
+}
+
+// registerReloadSubscribers applies the reloadable settings of AppConfig
+func (app *BaseApp) registerReloadSubscribers() {
+	app.setDebug(app.Config.Debug)
+	app.OnConfigChange("DEBUG", func(c ConfigChange) {
+		app.setDebug(c.New.(bool))
+	})
+
+	if app.Config.LogLevel != "" {
+		if err := logger.SetLevel(app.Config.LogLevel); err != nil {
+			logger.Tag("BaseApp.Initialize").WithError(err).Warn("invalid log level")
+		}
+	}
+	app.OnConfigChange("TRUSTED_PROXY", func(c ConfigChange) {
+		if err := app.clientIP.SetTrustedProxies(c.New.([]string)); err != nil {
+			logger.Tag("BaseApp.Reload").WithError(err).Error("invalid trusted proxies, keep the previous ones")
+		}
+	})
+	app.OnConfigChange("LOG_LEVEL", func(c ConfigChange) {
+		if err := logger.SetLevel(c.New.(string)); err != nil {
+			logger.Tag("BaseApp.Reload").WithError(err).Warn("invalid log level")
+		}
+	})
+}
+
+func (app *BaseApp) setDebug(debug bool) {
+	var v int32
+	if debug {
+		v = 1
+	}
+	atomic.StoreInt32(&app.debug, v)
+}
+
+// errorHandler is the error handler printing stacks in debug mode, it follows the reloadable debug flag
+func (app *BaseApp) errorHandler() gin.HandlerFunc {
+	withStack, withoutStack := ginext.CreateErrorHandler(true), ginext.CreateErrorHandler(false)
+	return func(c *gin.Context) {
+		if atomic.LoadInt32(&app.debug) == 1 {
+			withStack(c)
+			return
+		}
+		withoutStack(c)
+	}
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/db"
+	"github.com/praslar/cloud0/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type userConfig struct {
+	RateLimit int    `env:"TEST_RATE_LIMIT" envDefault:"100" reload:"true"`
+	Bucket    string `env:"TEST_BUCKET" envDefault:"files"`
+	APIKey    string `env:"TEST_API_KEY" reload:"true"`
+}
+
+func TestReload(t *testing.T) {
+	t.Setenv("PORT", "0")
+	t.Setenv("DEBUG", "false")
+	t.Setenv("TEST_RATE_LIMIT", "100")
+	gin.SetMode(gin.TestMode)
+	logger.Init("test")
+	level := logger.DefaultLogger.GetLevel()
+	defer logger.DefaultLogger.SetLevel(level)
+
+	app := NewApp("reload", "v1")
+	require.NoError(t, app.Initialize())
+	cfg := &userConfig{}
+	require.NoError(t, app.RegisterConfig(cfg))
+
+	var rateLimits []int
+	app.OnConfigChange("TEST_RATE_LIMIT", func(c ConfigChange) {
+		rateLimits = append(rateLimits, c.New.(int))
+	})
+
+	changes, err := app.Reload()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	t.Setenv("DEBUG", "true")
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("TEST_RATE_LIMIT", "50")
+	t.Setenv("TEST_BUCKET", "images")
+	t.Setenv("TEST_API_KEY", "secret")
+	t.Setenv("DB_NAME", "other")
+
+	changes, err = app.Reload()
+	require.NoError(t, err)
+	fields := map[string]ConfigChange{}
+	for _, c := range changes {
+		fields[c.Field] = c
+	}
+	assert.Len(t, fields, 6)
+	assert.Equal(t, "DB_NAME", fields["AppConfig.DB.Name"].Env)
+	assert.False(t, fields["userConfig.Bucket"].Reloadable)
+
+	// reloadable fields are applied to the new versions
+	appConfig, current := CurrentConfig(app, app.Config), CurrentConfig(app, cfg)
+	assert.True(t, appConfig.Debug)
+	assert.Equal(t, int32(1), app.debug)
+	assert.Equal(t, "error", logger.DefaultLogger.GetLevel().String())
+	assert.Equal(t, 50, current.RateLimit)
+	assert.Equal(t, "secret", current.APIKey)
+	assert.Equal(t, []int{50}, rateLimits)
+
+	// others require restart
+	assert.Equal(t, "files", current.Bucket)
+	assert.Equal(t, "", appConfig.DB.Name)
+
+	// registered configs aren't changed
+	assert.False(t, app.Config.Debug)
+	assert.Equal(t, 100, cfg.RateLimit)
+
+	changes, err = app.Reload()
+	require.NoError(t, err)
+	assert.Len(t, changes, 2, "only the changes requiring a restart are reported again")
+	assert.Equal(t, []int{50}, rateLimits)
+}
+
+func TestReloadFromSubscriber(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger.Init("test")
+	t.Setenv("TEST_RATE_LIMIT", "100")
+
+	app := NewApp("nested", "v1")
+	require.NoError(t, app.Initialize())
+	cfg := &userConfig{}
+	require.NoError(t, app.RegisterConfig(cfg))
+
+	done := make(chan struct{})
+	app.OnConfigChange("TEST_RATE_LIMIT", func(c ConfigChange) {
+		app.OnConfigChange("TEST_API_KEY", func(c ConfigChange) {})
+		changes, err := app.Reload()
+		assert.NoError(t, err)
+		assert.Empty(t, changes, "the new version is published before subscribers are called")
+		close(done)
+	})
+
+	t.Setenv("TEST_RATE_LIMIT", "10")
+	go func() { _, _ = app.Reload() }()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscriber deadlocked")
+	}
+	assert.Equal(t, 10, CurrentConfig(app, cfg).RateLimit)
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	t.Setenv("PORT", "0")
+	t.Setenv("TEST_RATE_LIMIT", "100")
+	gin.SetMode(gin.TestMode)
+	logger.Init("test")
+
+	app := NewApp("sighup", "v1")
+	cfg := &userConfig{}
+	require.NoError(t, app.RegisterConfig(cfg))
+	reloaded := make(chan int, 1)
+	app.OnConfigChange("TEST_RATE_LIMIT", func(c ConfigChange) {
+		reloaded <- c.New.(int)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- app.Start(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	t.Setenv("TEST_RATE_LIMIT", "10")
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	select {
+	case n := <-reloaded:
+		assert.Equal(t, 10, n)
+	case <-time.After(2 * time.Second):
+		t.Fatal("config hasn't been reloaded")
+	}
+
+	select {
+	case err := <-startErr:
+		t.Fatalf("app stopped on SIGHUP: %v", err)
+	default:
+	}
+
+	cancel()
+	assert.NoError(t, <-startErr)
+}
+
+func TestConfigWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.env")
+	require.NoError(t, os.WriteFile(path, []byte("# comment\nTEST_RATE_LIMIT=20\nexport TEST_BUCKET=\"docs\"\n"), 0o600))
+	t.Setenv("PORT", "0")
+	t.Setenv("CONFIG_WATCH_FILE", path)
+	t.Setenv("TEST_RATE_LIMIT", "100")
+	t.Setenv("TEST_BUCKET", "")
+	gin.SetMode(gin.TestMode)
+	logger.Init("test")
+
+	app := NewApp("watch", "v1")
+	require.NoError(t, app.Initialize())
+	cfg := &userConfig{}
+	require.NoError(t, app.RegisterConfig(cfg))
+	assert.Equal(t, 20, cfg.RateLimit, "the file is loaded on start")
+	assert.Equal(t, "docs", cfg.Bucket)
+
+	reloaded := make(chan int, 1)
+	app.OnConfigChange("TEST_RATE_LIMIT", func(c ConfigChange) {
+		reloaded <- c.New.(int)
+	})
+
+	watcher := app.runners[len(app.runners)-1].(*configWatcher)
+	watcher.interval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = watcher.Run(ctx) }()
+
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, os.WriteFile(path, []byte("TEST_RATE_LIMIT=30\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second)))
+
+	select {
+	case n := <-reloaded:
+		assert.Equal(t, 30, n)
+	case <-time.After(2 * time.Second):
+		t.Fatal("config hasn't been reloaded")
+	}
+}
+
+func TestReloadDerivedDBConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger.Init("test")
+	t.Setenv("DEBUG", "true")
+	t.Setenv("ENABLE_DB", "true")
+	t.Setenv("DB_DRIVER", "postgres")
+	t.Setenv("DB_HOST", "127.0.0.1")
+	t.Setenv("DB_PORT", "1")
+	t.Setenv("DB_LAZY_CONNECT", "true")
+
+	app := NewApp("derived", "v1")
+	require.NoError(t, app.Initialize())
+	defer db.CloseDB()
+	assert.Equal(t, "info", app.Config.DB.LogLevel)
+	assert.Equal(t, "derived", app.Config.DB.ApplicationName)
+
+	// derived values aren't changes
+	changes, err := app.Reload()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+// TestReloadWhileServing is meant to be run with -race: configs are read while new versions are published
+func TestReloadWhileServing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger.Init("test")
+	t.Setenv("TEST_RATE_LIMIT", "100")
+	level := logger.DefaultLogger.GetLevel()
+	defer logger.DefaultLogger.SetLevel(level)
+
+	app := NewApp("serving", "v1")
+	require.NoError(t, app.Initialize())
+	cfg := &userConfig{}
+	require.NoError(t, app.RegisterConfig(cfg))
+
+	rateLimit := int64(cfg.RateLimit)
+	app.OnConfigChange("TEST_RATE_LIMIT", func(c ConfigChange) {
+		atomic.StoreInt64(&rateLimit, int64(c.New.(int)))
+	})
+	app.Router.GET("/limit", func(c *gin.Context) {
+		current := CurrentConfig(app, cfg)
+		c.String(http.StatusOK, "%s %d %d %v", cfg.Bucket, current.RateLimit, atomic.LoadInt64(&rateLimit),
+			CurrentConfig(app, app.Config).TrustedProxy)
+	})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				w := httptest.NewRecorder()
+				app.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/limit", nil))
+				assert.Equal(t, http.StatusOK, w.Code)
+			}
+		}()
+	}
+
+	for i := 1; i <= 20; i++ {
+		t.Setenv("TEST_RATE_LIMIT", strconv.Itoa(i))
+		t.Setenv("DEBUG", strconv.FormatBool(i%2 == 0))
+		t.Setenv("TRUSTED_PROXY", "10.0.0."+strconv.Itoa(i))
+		_, err := app.Reload()
+		require.NoError(t, err)
+	}
+	close(done)
+	wg.Wait()
+	assert.Equal(t, int64(20), atomic.LoadInt64(&rateLimit))
+	assert.Equal(t, 20, CurrentConfig(app, cfg).RateLimit)
+}
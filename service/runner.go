@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/praslar/cloud0/logger"
+)
+
+// Runner presents a background component whose lifecycle is managed by BaseApp,
+// Run should block until ctx is done (the app is shutting down) then return
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// RunnerFunc is an adapter to allow the use of ordinary functions as Runner
+type RunnerFunc func(ctx context.Context) error
+
+// Run calls f(ctx)
+func (f RunnerFunc) Run(ctx context.Context) error {
+	return f(ctx)
+}
+
+// RegisterRunner registers a background runner, it's started when the app starts
+// and stopped after the http server has been shut down
+//
+//	app.RegisterRunner(outbox.NewRelay(nil, publisher, nil))
+func (app *BaseApp) RegisterRunner(runners ...Runner) {
+	app.runners = append(app.runners, runners...)
+}
+
+// startRunners starts all registered runners then returns a channel that's closed when all of them have returned
+func (app *BaseApp) startRunners(ctx context.Context) <-chan struct{} {
+	l := logger.Tag("BaseApp.Runner")
+	wg := &sync.WaitGroup{}
+	for _, r := range app.runners {
+		wg.Add(1)
+		go func(r Runner) {
+			defer wg.Done()
+			if err := r.Run(ctx); err != nil && err != context.Canceled {
+				l.WithError(err).Errorf("runner %T stopped with error", r)
+			}
+		}(r)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	return done
+}
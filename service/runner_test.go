@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunnersLifecycle(t *testing.T) {
+	_ = os.Setenv("PORT", "0")
+	gin.SetMode(gin.TestMode)
+	logger.Init("test")
+
+	app := NewApp("runner", "v1")
+	require.NoError(t, app.Initialize())
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	app.RegisterRunner(RunnerFunc(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+		return ctx.Err()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- app.Start(ctx)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("runner hasn't been started")
+	}
+
+	cancel()
+	select {
+	case err := <-startErr:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("app hasn't been stopped")
+	}
+
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("Start returned before the runner stopped")
+	}
+}
+
+func TestSchedulerRegisteredOnce(t *testing.T) {
+	app := NewApp("scheduler", "v1")
+	s := app.Scheduler()
+	assert.Same(t, s, app.Scheduler())
+	assert.Len(t, app.runners, 1)
+}
@@ -0,0 +1,14 @@
+package service
+
+import "github.com/praslar/cloud0/scheduler"
+
+// Scheduler returns the app scheduler, it's created & registered as a runner on the first call
+//
+//	_ = app.Scheduler().Cron("cleanup", "0 3 * * *", cleanup, &scheduler.Options{SingleReplica: true})
+func (app *BaseApp) Scheduler() *scheduler.Scheduler {
+	if app.scheduler == nil {
+		app.scheduler = scheduler.New()
+		app.RegisterRunner(app.scheduler)
+	}
+	return app.scheduler
+}
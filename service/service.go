@@ -14,24 +14,32 @@ import (
 
 	_ "net/http/pprof"
 
-	"github.com/caarlos0/env/v6"
 	"github.com/gin-gonic/gin"
+	"github.com/praslar/cloud0/config"
 	"github.com/praslar/cloud0/db"
 	"github.com/praslar/cloud0/ginext"
 	"github.com/praslar/cloud0/logger"
+	"github.com/praslar/cloud0/scheduler"
 	"gorm.io/gorm"
 )
 
 type BaseApp struct {
-	Config     *AppConfig
-	Name       string
-	Version    string
-	Router     *gin.Engine
-	HttpServer *http.Server
+	Config       *AppConfig
+	ConfigLoader *config.Loader // loads Config & registered configs from defaults, config file, env & secret files
+	Name         string
+	Version      string
+	Router       *gin.Engine
+	HttpServer   *http.Server
 
 	listener       net.Listener
 	initialized    bool
 	healthDisabled bool
+	runners        []Runner
+	dbWatcher      *db.HealthWatcher
+	scheduler      *scheduler.Scheduler
+	configReg      configRegistry
+	debug          int32 // AppConfig.Debug, read atomically as it's reloadable
+	clientIP       *ginext.ClientIPResolver
 }
 
 func NewApp(name, version string) *BaseApp {
@@ -41,6 +49,7 @@ func NewApp(name, version string) *BaseApp {
 		Router:         gin.New(),
 		HttpServer:     &http.Server{},
 		Config:         NewAppConfig(),
+		ConfigLoader:   config.NewLoader(),
 		healthDisabled: false,
 	}
 
@@ -54,37 +63,76 @@ func (app *BaseApp) DisableHealthEndpoint() {
 }
 
 func (app *BaseApp) Initialize() error {
-	if err := env.Parse(app); err != nil {
+	if err := app.ConfigLoader.Load(app.Config); err != nil {
 		return err
 	}
+	if app.Config.ConfigWatchFile != "" {
+		if err := app.watchConfigFile(); err != nil {
+			return err
+		}
+	}
+	app.deriveConfig(app.Config)
+	logger.Tag("BaseApp.Initialize").WithField("config", config.Dump(app.Config)).Info("effective config")
+	app.registerReloadSubscribers()
 
 	app.HttpServer.ReadTimeout = time.Duration(app.Config.ReadTimeout) * time.Second
-
-	// wait to gin 1.8 to support this
-	//_ = app.Router.SetTrustedProxies(app.Config.TrustedProxy)
-	app.Router.ForwardedByClientIP = true
+	app.HttpServer.ReadHeaderTimeout = time.Duration(app.Config.ReadHeaderTimeout) * time.Second
+	app.HttpServer.WriteTimeout = time.Duration(app.Config.WriteTimeout) * time.Second
+	app.HttpServer.IdleTimeout = time.Duration(app.Config.IdleTimeout) * time.Second
+	app.HttpServer.MaxHeaderBytes = app.Config.MaxHeaderBytes
+
+	// client IP is resolved by ginext.ClientIPMiddleware, forwarding headers are only trusted from TrustedProxy
+	app.Router.ForwardedByClientIP = false
+	clientIP, err := ginext.NewClientIPResolver(app.Config.TrustedProxy)
+	if err != nil {
+		return err
+	}
+	app.clientIP = clientIP
 
 	// register default middlewares
 	app.Router.Use(
 		ginext.RequestIDMiddleware,
+		ginext.ClientIPMiddleware(app.clientIP),
 		ginext.AccessLogMiddleware(app.Config.Env),
-		ginext.CreateErrorHandler(app.Config.Debug),
+		app.errorHandler(),
 	)
+	// before MaxBodyMiddleware, so the limit applies to decompressed request bodies
+	if app.Config.Compress.Enabled {
+		app.Router.Use(ginext.CompressMiddleware(app.Config.Compress))
+	}
+	if app.Config.MaxBodyBytes > 0 {
+		app.Router.Use(ginext.MaxBodyMiddleware(app.Config.MaxBodyBytes))
+	}
+	// headers are set before handlers run, so error responses get them too
+	if app.Config.SecurityHeaders.Enabled {
+		app.Router.Use(ginext.SecurityHeadersMiddleware(app.Config.SecurityHeaders))
+	}
+	if app.Config.CORS.Enabled {
+		app.Router.Use(ginext.CORSMiddleware(app.Config.CORS))
+	}
 
 	// register routes
 	if !app.healthDisabled {
 		healthHandler := app.HealthHandler()
 		app.Router.GET("/status", healthHandler)
 		app.Router.GET("/status-q", healthHandler)
+		app.Router.GET("/ready", app.ReadinessHandler())
 	}
 
 	app.Router.NoRoute(ginext.NotFoundHandler)
 
 	if app.Config.EnableDB {
-		err := db.OpenDefault(app.Config.DB)
+		err = db.OpenDefault(app.Config.DB)
 		if err != nil {
 			return errors.New("failed to open default DB: " + err.Error())
 		}
+
+		// keep track of DB state for health endpoints, it's unhealthy on starting degraded (DB_LAZY_CONNECT)
+		app.dbWatcher = db.NewHealthWatcher(db.GetDB(), time.Duration(app.Config.DB.HealthCheckInterval)*time.Second)
+		if err = app.dbWatcher.Check(context.Background()); err != nil {
+			logger.Tag("BaseApp.Initialize").WithError(err).Warn("starting degraded, database is unreachable")
+		}
+		app.RegisterRunner(app.dbWatcher)
 	}
 
 	app.initialized = true
@@ -92,82 +140,56 @@ func (app *BaseApp) Initialize() error {
 	return nil
 }
 
-// HealthHandler makes health check handler
+// HealthHandler makes health check handler, it also reports the DB state if DB is enabled
 func (app *BaseApp) HealthHandler() gin.HandlerFunc {
-	rsp := struct {
+	type healthResponse struct {
 		Name     string `json:"name"`
 		Version  string `json:"version"`
 		Hostname string `json:"hostname"`
-	}{
+		DB       string `json:"db,omitempty"`
+	}
+	rsp := healthResponse{
 		Name:    app.Name,
 		Version: app.Version,
 	}
 	rsp.Hostname, _ = os.Hostname()
 
 	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, rsp)
-	}
-}
-
-func (app *BaseApp) StartTLS(ctx context.Context, certPath string, keyPath string) error {
-	l := logger.Tag("BaseApp.Start")
-	var err error
-
-	if !app.initialized {
-		if err = app.Initialize(); err != nil {
-			return errors.New("failed to initialize app: " + err.Error())
+		r := rsp
+		if app.dbWatcher != nil {
+			r.DB = "down"
+			if app.dbWatcher.Healthy() {
+				r.DB = "up"
+			}
 		}
+		c.JSON(http.StatusOK, r)
 	}
+}
 
-	if app.listener, err = net.Listen("tcp4", fmt.Sprintf("0.0.0.0:%d", app.Config.Port)); err != nil {
-		return errors.New("failed to listen: " + err.Error())
-	}
-
-	errCh := make(chan error, 1)
-
-	go func() {
-		l.Printf("start listening on %s", app.listener.Addr().String())
-		if err := app.HttpServer.ServeTLS(app.listener, certPath, keyPath); err != nil && err != http.ErrServerClosed {
-			errCh <- err
-			return
-		}
-
-		// no error, close channel
-		close(errCh)
-	}()
-
-	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
-	go func() {
-		defer func() {
-			l.Info("shutting down http server ...")
-			shutCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			_ = app.HttpServer.Shutdown(shutCtx)
-			cancel()
-		}()
-		select {
-		case gotSignal, ok := <-signalCh:
-			if !ok {
-				// channel close
-				return
-			}
-			l.Printf("got signal: %v", gotSignal)
-			return
-		case <-ctx.Done():
-			l.Printf("context has done")
+// ReadinessHandler makes readiness check handler, it responds 503 while the DB (if enabled) is unreachable
+func (app *BaseApp) ReadinessHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.dbWatcher != nil && !app.dbWatcher.Healthy() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"ready": false, "db": "down"})
 			return
 		}
-	}()
-
-	go func() {
-		l.Printf("start listening debug server on port %d", app.Config.DebugPort)
-		_ = http.ListenAndServe("0.0.0.0:"+strconv.Itoa(app.Config.DebugPort), nil)
-	}()
+		c.JSON(http.StatusOK, gin.H{"ready": true})
+	}
+}
 
-	return <-errCh
+func (app *BaseApp) StartTLS(ctx context.Context, certPath string, keyPath string) error {
+	return app.serve(ctx, func(listener net.Listener) error {
+		return app.HttpServer.ServeTLS(listener, certPath, keyPath)
+	})
 }
 
 func (app *BaseApp) Start(ctx context.Context) error {
+	return app.serve(ctx, app.HttpServer.Serve)
+}
+
+// serve initializes the app if needed, starts registered runners then serves http requests via serveFn
+// it blocks until the server is shut down (by a signal or ctx) and all runners have stopped
+func (app *BaseApp) serve(ctx context.Context, serveFn func(listener net.Listener) error) error {
 	l := logger.Tag("BaseApp.Start")
 	var err error
 
@@ -180,12 +202,18 @@ func (app *BaseApp) Start(ctx context.Context) error {
 	if app.listener, err = net.Listen("tcp4", fmt.Sprintf("0.0.0.0:%d", app.Config.Port)); err != nil {
 		return errors.New("failed to listen: " + err.Error())
 	}
+	if app.Config.ProxyProtocol {
+		app.listener = &proxyProtoListener{Listener: app.listener, trusted: app.clientIP.IsTrusted}
+	}
+
+	runnerCtx, stopRunners := context.WithCancel(context.Background())
+	runnersDone := app.startRunners(runnerCtx)
 
 	errCh := make(chan error, 1)
 
 	go func() {
 		l.Printf("start listening on %s", app.listener.Addr().String())
-		if err := app.HttpServer.Serve(app.listener); err != nil && err != http.ErrServerClosed {
+		if err := serveFn(app.listener); err != nil && err != http.ErrServerClosed {
 			errCh <- err
 			return
 		}
@@ -194,27 +222,41 @@ func (app *BaseApp) Start(ctx context.Context) error {
 		close(errCh)
 	}()
 
+	shutdownDone := make(chan struct{})
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 	go func() {
+		defer close(shutdownDone)
 		defer func() {
 			l.Info("shutting down http server ...")
 			shutCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			_ = app.HttpServer.Shutdown(shutCtx)
 			cancel()
+
+			l.Info("stopping background runners ...")
+			stopRunners()
+			<-runnersDone
 		}()
 
-		select {
-		case gotSignal, ok := <-signalCh:
-			if !ok {
-				// channel close
+		for {
+			select {
+			case gotSignal, ok := <-signalCh:
+				if !ok {
+					// channel close
+					return
+				}
+				l.Printf("got signal: %v", gotSignal)
+				if gotSignal == syscall.SIGHUP {
+					if _, err := app.Reload(); err != nil {
+						l.WithError(err).Error("failed to reload config")
+					}
+					continue
+				}
+				return
+			case <-ctx.Done():
+				l.Printf("context has done")
 				return
 			}
-			l.Printf("got signal: %v", gotSignal)
-			return
-		case <-ctx.Done():
-			l.Printf("context has done")
-			return
 		}
 	}()
 
@@ -223,7 +265,13 @@ func (app *BaseApp) Start(ctx context.Context) error {
 		_ = http.ListenAndServe("0.0.0.0:"+strconv.Itoa(app.Config.DebugPort), nil)
 	}()
 
-	return <-errCh
+	if err = <-errCh; err != nil {
+		stopRunners()
+		return err
+	}
+
+	<-shutdownDone
+	return nil
 }
 
 func (app *BaseApp) Listener() net.Listener {
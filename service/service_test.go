@@ -2,12 +2,16 @@ package service
 
 import (
 	"context"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/praslar/cloud0/common"
+	"github.com/praslar/cloud0/db"
 	"github.com/praslar/cloud0/logger"
 
 	"github.com/gin-gonic/gin"
@@ -44,3 +48,99 @@ func TestStartService(t *testing.T) {
 
 	assert.NotEmpty(t, rsp.Header.Get(common.HeaderXRequestID))
 }
+
+func TestStartDegradedWithoutDB(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger.Init("test")
+	t.Setenv("ENABLE_DB", "true")
+	t.Setenv("DB_DRIVER", "postgres")
+	t.Setenv("DB_HOST", "127.0.0.1")
+	t.Setenv("DB_PORT", "1")
+	t.Setenv("DB_LAZY_CONNECT", "true")
+
+	app := NewApp("degraded", "v1")
+	require.NoError(t, app.Initialize())
+	defer db.CloseDB()
+
+	w := httptest.NewRecorder()
+	app.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	app.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"db":"down"`)
+}
+
+func TestReadyWithoutDB(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := NewApp("ready", "v1")
+	require.NoError(t, app.Initialize())
+
+	w := httptest.NewRecorder()
+	app.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "db")
+}
+
+func TestServerLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("WRITE_TIMEOUT", "30")
+	t.Setenv("MAX_BODY_BYTES", "8")
+
+	app := NewApp("limits", "v1")
+	require.NoError(t, app.Initialize())
+	assert.Equal(t, 30*time.Second, app.HttpServer.WriteTimeout)
+	assert.Equal(t, 120*time.Second, app.HttpServer.IdleTimeout)
+	assert.Equal(t, 1<<20, app.HttpServer.MaxHeaderBytes)
+
+	app.Router.POST("/echo", func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			_ = c.Error(err)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	app.Router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("more than 8 bytes")))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestCORSAndSecurityHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("CORS_ENABLED", "true")
+	t.Setenv("CORS_ALLOW_ORIGINS", "https://*.example.com")
+	t.Setenv("SECURITY_HEADERS_ENABLED", "true")
+
+	app := NewApp("cors", "v1")
+	require.NoError(t, app.Initialize())
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/status", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	app.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.NotEmpty(t, w.Header().Get(common.HeaderXRequestID))
+}
+
+func TestCompression(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("COMPRESS_ENABLED", "true")
+	t.Setenv("COMPRESS_MIN_SIZE", "10")
+
+	app := NewApp("compress", "v1")
+	require.NoError(t, app.Initialize())
+	app.Router.GET("/items", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": strings.Repeat("item ", 100)})
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	app.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+}